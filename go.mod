@@ -2,12 +2,18 @@ module security-groups-manager
 
 require (
 	github.com/aws/aws-lambda-go v1.23.0
-	github.com/aws/aws-sdk-go-v2 v1.6.0
+	github.com/aws/aws-sdk-go-v2 v1.9.0
 	github.com/aws/aws-sdk-go-v2/config v1.3.0
-	github.com/aws/aws-sdk-go-v2/service/ec2 v1.9.0
+	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.5.0
+	github.com/aws/aws-sdk-go-v2/service/ec2 v1.16.0
+	github.com/aws/aws-sdk-go-v2/service/lambda v1.8.0
+	github.com/aws/aws-sdk-go-v2/service/sns v1.8.0
 	github.com/go-test/deep v1.0.7
+	github.com/google/cel-go v0.9.0
 	github.com/jedib0t/go-pretty/v6 v6.2.2
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/net v0.0.0-20210825183410-e898025ed96a
+	google.golang.org/genproto v0.0.0-20210831024726-fe130286e0e2
 	inet.af/netaddr v0.0.0-20210603230628-bf05d8b52dda
 )
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
 	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/cloudwatch"
 	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
 	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lambda"
@@ -10,6 +11,19 @@ import (
 
 func main() {
 	pulumi.Run(func(ctx *pulumi.Context) error {
+		callerIdentity, err := aws.GetCallerIdentity(ctx)
+		if err != nil {
+			return err
+		}
+
+		region, err := aws.GetRegion(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		notificationSNSTopicArn := config.Get(ctx, "NOTIFICATION_SNS_TOPIC_ARN")
+		stateStoreTableName := config.Get(ctx, "STATE_STORE_TABLE_NAME")
+
 		cloudWatchLogsPolicy, err := iam.NewPolicy(ctx, "SecurityGroupsManagerLambdaFunctionCloudWatchLogsPolicy", &iam.PolicyArgs{
 			Path: pulumi.String("/"),
 			Policy: pulumi.String(`{
@@ -31,6 +45,30 @@ func main() {
 			return err
 		}
 
+		var dynamoDBPolicy *iam.Policy
+		if stateStoreTableName != "" {
+			dynamoDBPolicy, err = iam.NewPolicy(ctx, "SecurityGroupsManagerLambdaFunctionDynamoDBPolicy", &iam.PolicyArgs{
+				Path: pulumi.String("/"),
+				Policy: pulumi.Sprintf(`{
+					"Version": "2012-10-17",
+					"Statement": [
+						{
+							"Action": [
+								"dynamodb:GetItem",
+								"dynamodb:PutItem",
+								"dynamodb:Query"
+							],
+							"Resource": "arn:aws:dynamodb:%s:%s:table/%s",
+							"Effect": "Allow"
+						}
+					]
+				}`, region.Name, callerIdentity.AccountId, stateStoreTableName),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		ec2Policy, err := iam.NewPolicy(ctx, "SecurityGroupsManagerLambdaFunctionEC2Policy", &iam.PolicyArgs{
 			Path: pulumi.String("/"),
 			Policy: pulumi.String(`{
@@ -43,7 +81,9 @@ func main() {
 							"ec2:CreateTags",
 							"ec2:DeleteTags",
 							"ec2:DescribeRegions",
+							"ec2:DescribeSecurityGroupRules",
 							"ec2:DescribeSecurityGroups",
+							"ec2:ModifySecurityGroupRules",
 							"ec2:RevokeSecurityGroupEgress",
 							"ec2:RevokeSecurityGroupIngress",
 							"ec2:UpdateSecurityGroupRuleDescriptionsEgress",
@@ -59,6 +99,39 @@ func main() {
 			return err
 		}
 
+		var snsPolicy *iam.Policy
+		if notificationSNSTopicArn != "" {
+			snsPolicy, err = iam.NewPolicy(ctx, "SecurityGroupsManagerLambdaFunctionSNSPolicy", &iam.PolicyArgs{
+				Path: pulumi.String("/"),
+				Policy: pulumi.Sprintf(`{
+					"Version": "2012-10-17",
+					"Statement": [
+						{
+							"Action": [
+								"sns:Publish"
+							],
+							"Resource": "%s",
+							"Effect": "Allow"
+						}
+					]
+				}`, notificationSNSTopicArn),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		managedPolicyArns := pulumi.StringArray{
+			cloudWatchLogsPolicy.Arn,
+			ec2Policy.Arn,
+		}
+		if dynamoDBPolicy != nil {
+			managedPolicyArns = append(managedPolicyArns, dynamoDBPolicy.Arn)
+		}
+		if snsPolicy != nil {
+			managedPolicyArns = append(managedPolicyArns, snsPolicy.Arn)
+		}
+
 		role, err := iam.NewRole(ctx, "SecurityGroupsManagerLambdaFunctionRole", &iam.RoleArgs{
 			AssumeRolePolicy: pulumi.String(`{
 				"Version": "2012-10-17",
@@ -72,11 +145,8 @@ func main() {
 				  }
 				]
 			  }`),
-			ManagedPolicyArns: pulumi.StringArray{
-				cloudWatchLogsPolicy.Arn,
-				ec2Policy.Arn,
-			},
-			Path: pulumi.String("/"),
+			ManagedPolicyArns: managedPolicyArns,
+			Path:              pulumi.String("/"),
 		})
 		if err != nil {
 			return err
@@ -86,8 +156,10 @@ func main() {
 			Code: pulumi.NewFileArchive("ManagedSecurityGroups.zip"),
 			Environment: lambda.FunctionEnvironmentArgs{
 				Variables: pulumi.StringMap{
-					"CONFIGURATION": pulumi.String(config.Require(ctx, "CONFIGURATION")),
-					"DEBUG":         pulumi.String(config.Require(ctx, "DEBUG")),
+					"CONFIGURATION":              pulumi.String(config.Require(ctx, "CONFIGURATION")),
+					"DEBUG":                      pulumi.String(config.Require(ctx, "DEBUG")),
+					"NOTIFICATION_SNS_TOPIC_ARN": pulumi.String(notificationSNSTopicArn),
+					"STATE_STORE_TABLE_NAME":     pulumi.String(stateStoreTableName),
 				},
 			},
 			Handler: pulumi.String("main"),
@@ -99,6 +171,25 @@ func main() {
 			return err
 		}
 
+		_, err = iam.NewRolePolicy(ctx, "SecurityGroupsManagerLambdaFunctionLambdaPolicy", &iam.RolePolicyArgs{
+			Policy: pulumi.Sprintf(`{
+				"Version": "2012-10-17",
+				"Statement": [
+					{
+						"Action": [
+							"lambda:InvokeFunction"
+						],
+						"Resource": "%s",
+						"Effect": "Allow"
+					}
+				]
+			}`, lambdaFunction.Arn),
+			Role: role.Name,
+		})
+		if err != nil {
+			return err
+		}
+
 		scheduledEventRule, err := cloudwatch.NewEventRule(ctx, "SecurityGroupsManagerLambdaFunctionScheduledEventRule", &cloudwatch.EventRuleArgs{
 			ScheduleExpression: pulumi.String(config.Require(ctx, "SCHEDULE_EXPRESSION")),
 		})
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"inet.af/netaddr"
+)
+
+const (
+	accessRequestAddressEnvironmentVariableName = "ACCESS_REQUEST_ADDRESS"
+	accessRequestSecretEnvironmentVariableName  = "ACCESS_REQUEST_SECRET"
+	accessRequestTokenEnvironmentVariableName   = "ACCESS_REQUEST_TOKEN"
+)
+
+const defaultAccessRequestAddress = ":8080"
+
+const (
+	signatureHeaderName          = "X-Signature"
+	signatureTimestampHeaderName = "X-Signature-Timestamp"
+)
+
+// The maximum age of a signed access request, guarding against replays
+const maximumSignatureAge = 5 * time.Minute
+
+// The maximum size of an access request body, read before the request is authenticated
+const maximumAccessRequestSize = 4096
+
+// The characters allowed in a security group rule description, which the requester is written into
+var descriptionPattern = regexp.MustCompile(`^[a-zA-Z0-9. _\-:/()#,@\[\]+=&;{}!$*]*$`)
+
+// AccessProfile describes the access granted to a caller of the access request endpoint. Only the IpProtocol,
+// FromPort and ToPort of the IpPermission template are used, the source is always the caller's IP address
+type AccessProfile struct {
+	GroupId      *string
+	IpPermission IpPermission
+	Name         *string
+	TTL          *string
+}
+
+type AccessRequest struct {
+	Profile   string
+	Requester string
+}
+
+// AccessRequestHandler authenticates access requests, records a grant for the caller's source IP and triggers a
+// reconciliation. It serves both HTTP requests and API Gateway events. TriggerReconciliation must only start the
+// reconciliation, so that the caller isn't kept waiting on it
+type AccessRequestHandler struct {
+	AccessProfiles        []AccessProfile
+	GrantStore            GrantStore
+	Now                   func() time.Time
+	Secret                string
+	Token                 string
+	TriggerReconciliation func() error
+}
+
+func NewAccessRequestHandler(accessProfiles []AccessProfile, grantStore GrantStore, secret string, token string, triggerReconciliation func() error) *AccessRequestHandler {
+	accessRequestHandler := new(AccessRequestHandler)
+
+	accessRequestHandler.AccessProfiles = accessProfiles
+	accessRequestHandler.GrantStore = grantStore
+	accessRequestHandler.Now = time.Now
+	accessRequestHandler.Secret = secret
+	accessRequestHandler.Token = token
+	accessRequestHandler.TriggerReconciliation = triggerReconciliation
+
+	return accessRequestHandler
+}
+
+func (a *AccessRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maximumAccessRequestSize))
+	if err != nil {
+		http.Error(w, "Unable to read request", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	sourceIp, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIp = r.RemoteAddr
+	}
+
+	statusCode, responseBody := a.handle(r.Method, r.Header, body, sourceIp)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	fmt.Fprintln(w, responseBody)
+}
+
+// handleApiGatewayEvent handles an API Gateway REST API (payload format 1.0) or HTTP API (payload format 2.0) event
+func (a *AccessRequestHandler) handleApiGatewayEvent(event json.RawMessage) (events.APIGatewayProxyResponse, error) {
+	var payloadFormat struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(event, &payloadFormat); err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	var method, body, sourceIp string
+	var isBase64Encoded bool
+	headers := make(http.Header)
+
+	if payloadFormat.Version == "2.0" {
+		var request events.APIGatewayV2HTTPRequest
+		if err := json.Unmarshal(event, &request); err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		method, body, sourceIp, isBase64Encoded = request.RequestContext.HTTP.Method, request.Body, request.RequestContext.HTTP.SourceIP, request.IsBase64Encoded
+		for name, value := range request.Headers {
+			headers.Set(name, value)
+		}
+	} else {
+		var request events.APIGatewayProxyRequest
+		if err := json.Unmarshal(event, &request); err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		method, body, sourceIp, isBase64Encoded = request.HTTPMethod, request.Body, request.RequestContext.Identity.SourceIP, request.IsBase64Encoded
+		for name, value := range request.Headers {
+			headers.Set(name, value)
+		}
+	}
+
+	if isBase64Encoded {
+		decodedBody, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: errorResponseBody("Unable to decode request")}, nil
+		}
+
+		body = string(decodedBody)
+	}
+
+	statusCode, responseBody := a.handle(method, headers, []byte(body), sourceIp)
+
+	return events.APIGatewayProxyResponse{
+		Body: responseBody,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		StatusCode: statusCode,
+	}, nil
+}
+
+func (a *AccessRequestHandler) handle(method string, headers http.Header, body []byte, sourceIp string) (int, string) {
+	if method != http.MethodPost {
+		return http.StatusMethodNotAllowed, errorResponseBody("Method not allowed")
+	}
+
+	if a.GrantStore == nil || (a.Secret == "" && a.Token == "") {
+		return http.StatusServiceUnavailable, errorResponseBody("Access requests are not enabled")
+	}
+
+	ip, err := netaddr.ParseIP(sourceIp)
+	if err != nil {
+		return http.StatusBadRequest, errorResponseBody(fmt.Sprintf("Invalid source IP %s", sourceIp))
+	}
+
+	if !a.authenticate(headers, body, ip) {
+		logger.Warnf("Rejected unauthenticated access request from %s", sourceIp)
+
+		return http.StatusUnauthorized, errorResponseBody("Unauthorized")
+	}
+
+	var accessRequest AccessRequest
+	if err := json.Unmarshal(body, &accessRequest); err != nil {
+		return http.StatusBadRequest, errorResponseBody("Unable to unmarshal request")
+	}
+
+	accessProfile := a.findAccessProfile(accessRequest.Profile)
+	if accessProfile == nil {
+		return http.StatusNotFound, errorResponseBody(fmt.Sprintf("Unknown access profile %s", accessRequest.Profile))
+	}
+
+	if description := accessGrantDescription(accessRequest.Requester, accessRequest.Profile); len(description) > maximumDescriptionLength || !descriptionPattern.MatchString(description) {
+		return http.StatusBadRequest, errorResponseBody("Invalid requester")
+	}
+
+	ttl, err := time.ParseDuration(aws.ToString(accessProfile.TTL))
+	if err != nil || ttl <= 0 {
+		logger.Infof("Access profile %s has an invalid TTL: %v", accessRequest.Profile, err)
+
+		return http.StatusInternalServerError, errorResponseBody(fmt.Sprintf("Access profile %s has an invalid TTL", accessRequest.Profile))
+	}
+
+	now := a.Now().UTC()
+	grant := Grant{
+		ExpiresAt: now.Add(ttl),
+		GrantedAt: now,
+		GroupId:   aws.ToString(accessProfile.GroupId),
+		Profile:   accessRequest.Profile,
+		Requester: accessRequest.Requester,
+		SourceIp:  ip.String(),
+	}
+
+	if err := a.GrantStore.Add(grant); err != nil {
+		logger.Errorf("Unable to record grant: %v", err)
+
+		return http.StatusInternalServerError, errorResponseBody("Unable to record grant")
+	}
+
+	logger.Infof("Granted %s access to %s through %s until %s", grant.SourceIp, grant.GroupId, grant.Profile, grant.ExpiresAt.Format(time.RFC3339))
+
+	if err := a.TriggerReconciliation(); err != nil {
+		logger.Errorf("Unable to trigger reconciliation after granting access: %v", err)
+
+		return http.StatusBadGateway, errorResponseBody("Grant recorded but reconciliation could not be triggered")
+	}
+
+	b, err := json.Marshal(grant)
+	if err != nil {
+		return http.StatusInternalServerError, errorResponseBody("Unable to marshal grant")
+	}
+
+	return http.StatusAccepted, string(b)
+}
+
+// authenticate accepts either a pre-shared token (Authorization: Bearer <token>), or an HMAC-SHA256 signature of
+// "<timestamp>\n<source IP>\n<body>" keyed with the secret, sent in the X-Signature header alongside the unix timestamp
+// in the X-Signature-Timestamp header. Signing the source IP keeps a captured request from granting access to another
+// address. The token and the secret are distinct, so the HMAC key is never sent over the wire
+func (a *AccessRequestHandler) authenticate(headers http.Header, body []byte, sourceIp netaddr.IP) bool {
+	if token := strings.TrimPrefix(headers.Get("Authorization"), "Bearer "); token != headers.Get("Authorization") {
+		return a.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+	}
+
+	if a.Secret == "" {
+		return false
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(headers.Get(signatureHeaderName), "sha256="))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+
+	timestamp, err := strconv.ParseInt(headers.Get(signatureTimestampHeaderName), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	if age := a.Now().Sub(time.Unix(timestamp, 0)); age > maximumSignatureAge || age < -maximumSignatureAge {
+		return false
+	}
+
+	return hmac.Equal(signature, signAccessRequest(a.Secret, timestamp, sourceIp, body))
+}
+
+func (a *AccessRequestHandler) findAccessProfile(name string) *AccessProfile {
+	for i := range a.AccessProfiles {
+		if aws.ToString(a.AccessProfiles[i].Name) == name {
+			return &a.AccessProfiles[i]
+		}
+	}
+
+	return nil
+}
+
+func signAccessRequest(secret string, timestamp int64, sourceIp netaddr.IP, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "\n" + sourceIp.String() + "\n"))
+	mac.Write(body)
+
+	return mac.Sum(nil)
+}
+
+func errorResponseBody(message string) string {
+	b, _ := json.Marshal(struct {
+		Message string
+	}{
+		Message: message,
+	})
+
+	return string(b)
+}
+
+// isApiGatewayEvent reports whether a Lambda event was sent by API Gateway rather than EventBridge
+func isApiGatewayEvent(event json.RawMessage) bool {
+	var probe struct {
+		HTTPMethod     string          `json:"httpMethod"`
+		RequestContext json.RawMessage `json:"requestContext"`
+		Version        string          `json:"version"`
+	}
+
+	if err := json.Unmarshal(event, &probe); err != nil {
+		return false
+	}
+
+	return probe.HTTPMethod != "" || (probe.Version == "2.0" && probe.RequestContext != nil)
+}
+
+// addGrants adds an inbound IpPermission for every active grant of the security group. The grant's expiry is carried
+// over to the source so it's revoked by the first reconciliation after it expires
+func (s *SecurityGroup) addGrants(grants []Grant, accessProfiles []AccessProfile, now time.Time) {
+	for _, grant := range grants {
+		if grant.GroupId != aws.ToString(s.GroupId) || !grant.isActive(now) {
+			continue
+		}
+
+		var accessProfile *AccessProfile
+		for i := range accessProfiles {
+			if aws.ToString(accessProfiles[i].Name) == grant.Profile {
+				accessProfile = &accessProfiles[i]
+
+				break
+			}
+		}
+
+		if accessProfile == nil || aws.ToString(accessProfile.GroupId) != grant.GroupId {
+			logger.Warnf("Ignoring grant for %s through unknown access profile %s", grant.SourceIp, grant.Profile)
+
+			continue
+		}
+
+		ip, err := netaddr.ParseIP(grant.SourceIp)
+		if err != nil {
+			logger.Warnf("Ignoring grant for invalid source IP %s: %v", grant.SourceIp, err)
+
+			continue
+		}
+
+		ipPermission := IpPermission{
+			FromPort:   accessProfile.IpPermission.FromPort,
+			IpProtocol: accessProfile.IpPermission.IpProtocol,
+			ToPort:     accessProfile.IpPermission.ToPort,
+		}
+
+		description := aws.String(accessGrantDescription(grant.Requester, grant.Profile))
+		expiresAt := aws.String(grant.ExpiresAt.UTC().Format(time.RFC3339))
+
+		if ip.Is6() {
+			ipPermission.Ipv6Ranges = []Ipv6Range{
+				{
+					CidrIpv6:    aws.String(netaddr.IPPrefixFrom(ip, ip.BitLen()).String()),
+					Description: description,
+					ExpiresAt:   expiresAt,
+				},
+			}
+		} else {
+			ipPermission.IpRanges = []IpRange{
+				{
+					CidrIp:      aws.String(netaddr.IPPrefixFrom(ip, ip.BitLen()).String()),
+					Description: description,
+					ExpiresAt:   expiresAt,
+				},
+			}
+		}
+
+		s.IpPermissions = append(s.IpPermissions, ipPermission)
+	}
+}
+
+// accessGrantDescription is the description of the rule granting access to a requester through an access profile
+func accessGrantDescription(requester string, profile string) string {
+	return fmt.Sprintf("Access requested by %s through %s", requester, profile)
+}
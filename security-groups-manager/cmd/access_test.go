@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"inet.af/netaddr"
+)
+
+type memoryGrantStore struct {
+	Grants []Grant
+}
+
+func (m *memoryGrantStore) Add(grant Grant) error {
+	m.Grants = append(m.Grants, grant)
+
+	return nil
+}
+
+func (m *memoryGrantStore) List() ([]Grant, error) {
+	return m.Grants, nil
+}
+
+func newTestAccessRequestHandler(now time.Time, reconcileErr error) (*AccessRequestHandler, *memoryGrantStore, *int) {
+	accessProfiles := []AccessProfile{
+		{
+			GroupId: aws.String("sg-1"),
+			IpPermission: IpPermission{
+				FromPort:   aws.Int32(22),
+				IpProtocol: aws.String("tcp"),
+				ToPort:     aws.Int32(22),
+			},
+			Name: aws.String("ssh"),
+			TTL:  aws.String("1h"),
+		},
+	}
+
+	grantStore := new(memoryGrantStore)
+	reconciliations := 0
+
+	accessRequestHandler := NewAccessRequestHandler(accessProfiles, grantStore, "secret", "token", func() error {
+		reconciliations++
+
+		return reconcileErr
+	})
+	accessRequestHandler.Now = func() time.Time { return now }
+
+	return accessRequestHandler, grantStore, &reconciliations
+}
+
+func TestAccessRequestHandler(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	body := `{"Profile": "ssh", "Requester": "alice"}`
+	sourceIp := netaddr.MustParseIP("192.0.2.1")
+	signature := "sha256=" + hex.EncodeToString(signAccessRequest("secret", now.Unix(), sourceIp, []byte(body)))
+
+	tests := []struct {
+		name         string
+		method       string
+		headers      map[string]string
+		body         string
+		reconcileErr error
+		want         int
+	}{
+		{name: "Token", method: http.MethodPost, headers: map[string]string{"Authorization": "Bearer token"}, body: body, want: http.StatusAccepted},
+		{name: "Wrong token", method: http.MethodPost, headers: map[string]string{"Authorization": "Bearer guess"}, body: body, want: http.StatusUnauthorized},
+		{name: "Secret as token", method: http.MethodPost, headers: map[string]string{"Authorization": "Bearer secret"}, body: body, want: http.StatusUnauthorized},
+		{name: "Signature", method: http.MethodPost, headers: map[string]string{signatureHeaderName: signature, signatureTimestampHeaderName: strconv.FormatInt(now.Unix(), 10)}, body: body, want: http.StatusAccepted},
+		{name: "Signature for another source IP", method: http.MethodPost, headers: map[string]string{signatureHeaderName: "sha256=" + hex.EncodeToString(signAccessRequest("secret", now.Unix(), netaddr.MustParseIP("198.51.100.1"), []byte(body))), signatureTimestampHeaderName: strconv.FormatInt(now.Unix(), 10)}, body: body, want: http.StatusUnauthorized},
+		{name: "Signature of another body", method: http.MethodPost, headers: map[string]string{signatureHeaderName: signature, signatureTimestampHeaderName: strconv.FormatInt(now.Unix(), 10)}, body: `{"Profile": "ssh", "Requester": "mallory"}`, want: http.StatusUnauthorized},
+		{name: "Stale signature", method: http.MethodPost, headers: map[string]string{signatureHeaderName: "sha256=" + hex.EncodeToString(signAccessRequest("secret", now.Add(-time.Hour).Unix(), sourceIp, []byte(body))), signatureTimestampHeaderName: strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)}, body: body, want: http.StatusUnauthorized},
+		{name: "No credentials", method: http.MethodPost, body: body, want: http.StatusUnauthorized},
+		{name: "Wrong method", method: http.MethodGet, headers: map[string]string{"Authorization": "Bearer token"}, want: http.StatusMethodNotAllowed},
+		{name: "Unknown profile", method: http.MethodPost, headers: map[string]string{"Authorization": "Bearer token"}, body: `{"Profile": "rdp"}`, want: http.StatusNotFound},
+		{name: "Requester with invalid characters", method: http.MethodPost, headers: map[string]string{"Authorization": "Bearer token"}, body: `{"Profile": "ssh", "Requester": "alice\u003cscript\u003e"}`, want: http.StatusBadRequest},
+		{name: "Requester too long", method: http.MethodPost, headers: map[string]string{"Authorization": "Bearer token"}, body: `{"Profile": "ssh", "Requester": "` + strings.Repeat("a", 256) + `"}`, want: http.StatusBadRequest},
+		{name: "Body too large", method: http.MethodPost, headers: map[string]string{"Authorization": "Bearer token"}, body: `{"Profile": "ssh", "Requester": "alice", "Padding": "` + strings.Repeat("a", maximumAccessRequestSize) + `"}`, want: http.StatusRequestEntityTooLarge},
+		{name: "Reconciliation not triggered", method: http.MethodPost, headers: map[string]string{"Authorization": "Bearer token"}, body: body, reconcileErr: fmt.Errorf("invocation failed"), want: http.StatusBadGateway},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accessRequestHandler, grantStore, reconciliations := newTestAccessRequestHandler(now, test.reconcileErr)
+
+			request := httptest.NewRequest(test.method, "/", strings.NewReader(test.body))
+			request.RemoteAddr = "192.0.2.1:51234"
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+
+			recorder := httptest.NewRecorder()
+			accessRequestHandler.ServeHTTP(recorder, request)
+
+			assert.Equal(t, test.want, recorder.Code)
+
+			if test.want == http.StatusAccepted || test.want == http.StatusBadGateway {
+				assert.Equal(t, []Grant{
+					{
+						ExpiresAt: now.Add(time.Hour),
+						GrantedAt: now,
+						GroupId:   "sg-1",
+						Profile:   "ssh",
+						Requester: "alice",
+						SourceIp:  "192.0.2.1",
+					},
+				}, grantStore.Grants)
+				assert.Equal(t, 1, *reconciliations)
+			} else {
+				assert.Empty(t, grantStore.Grants)
+				assert.Equal(t, 0, *reconciliations)
+			}
+		})
+	}
+}
+
+func TestHandleApiGatewayEvent(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		event string
+		want  string
+	}{
+		{
+			name:  "REST API",
+			event: `{"httpMethod": "POST", "headers": {"authorization": "Bearer token"}, "body": "{\"Profile\": \"ssh\"}", "requestContext": {"identity": {"sourceIp": "192.0.2.1"}}}`,
+			want:  "192.0.2.1",
+		},
+		{
+			name:  "HTTP API",
+			event: `{"version": "2.0", "headers": {"authorization": "Bearer token"}, "body": "eyJQcm9maWxlIjogInNzaCJ9", "isBase64Encoded": true, "requestContext": {"http": {"method": "POST", "sourceIp": "2001:db8::1"}}}`,
+			want:  "2001:db8::1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.True(t, isApiGatewayEvent(json.RawMessage(test.event)))
+
+			accessRequestHandler, grantStore, _ := newTestAccessRequestHandler(now, nil)
+
+			response, err := accessRequestHandler.handleApiGatewayEvent(json.RawMessage(test.event))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusAccepted, response.StatusCode)
+
+			if assert.Len(t, grantStore.Grants, 1) {
+				assert.Equal(t, test.want, grantStore.Grants[0].SourceIp)
+			}
+		})
+	}
+
+	assert.False(t, isApiGatewayEvent(json.RawMessage(`{"version": "0", "source": "aws.events", "detail-type": "Scheduled Event"}`)))
+}
+
+func TestAddGrants(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	accessProfiles := []AccessProfile{
+		{
+			GroupId:      aws.String("sg-1"),
+			IpPermission: IpPermission{FromPort: aws.Int32(22), IpProtocol: aws.String("tcp"), ToPort: aws.Int32(22)},
+			Name:         aws.String("ssh"),
+			TTL:          aws.String("1h"),
+		},
+	}
+
+	grants := []Grant{
+		{ExpiresAt: now.Add(time.Hour), GroupId: "sg-1", Profile: "ssh", Requester: "alice", SourceIp: "192.0.2.1"},
+		{ExpiresAt: now.Add(time.Hour), GroupId: "sg-1", Profile: "ssh", Requester: "bob", SourceIp: "2001:db8::1"},
+		{ExpiresAt: now.Add(-time.Hour), GroupId: "sg-1", Profile: "ssh", Requester: "carol", SourceIp: "192.0.2.3"},
+		{ExpiresAt: now.Add(time.Hour), GroupId: "sg-2", Profile: "ssh", Requester: "dave", SourceIp: "192.0.2.4"},
+		{ExpiresAt: now.Add(time.Hour), GroupId: "sg-1", Profile: "rdp", Requester: "erin", SourceIp: "192.0.2.5"},
+	}
+
+	securityGroup := SecurityGroup{GroupId: aws.String("sg-1")}
+	securityGroup.addGrants(grants, accessProfiles, now)
+
+	assert.Equal(t, []IpPermission{
+		{
+			FromPort:   aws.Int32(22),
+			IpProtocol: aws.String("tcp"),
+			IpRanges: []IpRange{
+				{
+					CidrIp:      aws.String("192.0.2.1/32"),
+					Description: aws.String("Access requested by alice through ssh"),
+					ExpiresAt:   aws.String("2021-09-01T13:00:00Z"),
+				},
+			},
+			ToPort: aws.Int32(22),
+		},
+		{
+			FromPort:   aws.Int32(22),
+			IpProtocol: aws.String("tcp"),
+			Ipv6Ranges: []Ipv6Range{
+				{
+					CidrIpv6:    aws.String("2001:db8::1/128"),
+					Description: aws.String("Access requested by bob through ssh"),
+					ExpiresAt:   aws.String("2021-09-01T13:00:00Z"),
+				},
+			},
+			ToPort: aws.Int32(22),
+		},
+	}, securityGroup.IpPermissions)
+}
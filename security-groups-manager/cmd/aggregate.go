@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"inet.af/netaddr"
+)
+
+// The maximum length of a security group rule description
+const maximumDescriptionLength = 255
+
+type describedPrefix struct {
+	Description *string
+	Prefix      netaddr.IPPrefix
+}
+
+// aggregate merges contained and adjacent IpRanges and Ipv6Ranges into the smallest set of prefixes covering them.
+// The description of a merged prefix is the sorted, deduplicated descriptions of the prefixes it covers
+func (i *IpPermission) aggregate() {
+	ipv4Prefixes := make([]describedPrefix, 0, len(i.IpRanges))
+	for _, ipRange := range i.IpRanges {
+		prefix, err := netaddr.ParseIPPrefix(aws.ToString(ipRange.CidrIp))
+		if err != nil {
+			logger.Warnf("Unable to aggregate %s: %v", aws.ToString(ipRange.CidrIp), err)
+
+			return
+		}
+
+		ipv4Prefixes = append(ipv4Prefixes, describedPrefix{
+			Description: ipRange.Description,
+			Prefix:      prefix.Masked(),
+		})
+	}
+
+	ipv6Prefixes := make([]describedPrefix, 0, len(i.Ipv6Ranges))
+	for _, ipv6Range := range i.Ipv6Ranges {
+		prefix, err := netaddr.ParseIPPrefix(aws.ToString(ipv6Range.CidrIpv6))
+		if err != nil {
+			logger.Warnf("Unable to aggregate %s: %v", aws.ToString(ipv6Range.CidrIpv6), err)
+
+			return
+		}
+
+		ipv6Prefixes = append(ipv6Prefixes, describedPrefix{
+			Description: ipv6Range.Description,
+			Prefix:      prefix.Masked(),
+		})
+	}
+
+	aggregatedIpv4Prefixes, err := aggregatePrefixes(ipv4Prefixes)
+	if err != nil {
+		logger.Warnf("Unable to aggregate IpRanges: %v", err)
+
+		return
+	}
+
+	aggregatedIpv6Prefixes, err := aggregatePrefixes(ipv6Prefixes)
+	if err != nil {
+		logger.Warnf("Unable to aggregate Ipv6Ranges: %v", err)
+
+		return
+	}
+
+	if len(i.IpRanges) > 0 {
+		i.IpRanges = make([]IpRange, 0, len(aggregatedIpv4Prefixes))
+		for _, aggregatedPrefix := range aggregatedIpv4Prefixes {
+			i.IpRanges = append(i.IpRanges, IpRange{
+				CidrIp:      aws.String(aggregatedPrefix.Prefix.String()),
+				Description: aggregatedPrefix.Description,
+			})
+		}
+	}
+
+	if len(i.Ipv6Ranges) > 0 {
+		i.Ipv6Ranges = make([]Ipv6Range, 0, len(aggregatedIpv6Prefixes))
+		for _, aggregatedPrefix := range aggregatedIpv6Prefixes {
+			i.Ipv6Ranges = append(i.Ipv6Ranges, Ipv6Range{
+				CidrIpv6:    aws.String(aggregatedPrefix.Prefix.String()),
+				Description: aggregatedPrefix.Description,
+			})
+		}
+	}
+}
+
+func aggregatePrefixes(describedPrefixes []describedPrefix) ([]describedPrefix, error) {
+	var ipSetBuilder netaddr.IPSetBuilder
+
+	for _, describedPrefix := range describedPrefixes {
+		ipSetBuilder.AddPrefix(describedPrefix.Prefix)
+	}
+
+	ipSet, err := ipSetBuilder.IPSet()
+	if err != nil {
+		return nil, err
+	}
+
+	aggregatedPrefixes := make([]describedPrefix, 0)
+
+	for _, prefix := range ipSet.Prefixes() {
+		descriptions := make([]string, 0)
+		seenDescriptions := make(map[string]bool)
+
+		for _, describedPrefix := range describedPrefixes {
+			description := aws.ToString(describedPrefix.Description)
+
+			if description == "" || seenDescriptions[description] || !prefix.Overlaps(describedPrefix.Prefix) {
+				continue
+			}
+
+			seenDescriptions[description] = true
+			descriptions = append(descriptions, description)
+		}
+
+		aggregatedPrefixes = append(aggregatedPrefixes, describedPrefix{
+			Description: combineDescriptions(descriptions),
+			Prefix:      prefix,
+		})
+	}
+
+	return aggregatedPrefixes, nil
+}
+
+func combineDescriptions(descriptions []string) *string {
+	if len(descriptions) == 0 {
+		return nil
+	}
+
+	sort.Strings(descriptions)
+
+	// The maximum length is in characters, cutting bytes could split a multi-byte character
+	combinedDescription := strings.Join(descriptions, ", ")
+	if runes := []rune(combinedDescription); len(runes) > maximumDescriptionLength {
+		combinedDescription = string(runes[:maximumDescriptionLength])
+	}
+
+	return &combinedDescription
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregate(t *testing.T) {
+	ipPermission := IpPermission{
+		Aggregate: true,
+		IpRanges: []IpRange{
+			{CidrIp: aws.String("10.0.1.0/24"), Description: aws.String("Office B")},
+			{CidrIp: aws.String("10.0.0.0/24"), Description: aws.String("Office A")},
+			{CidrIp: aws.String("10.0.0.7/32"), Description: aws.String("Office A")},
+			{CidrIp: aws.String("192.168.0.1/32")},
+			{CidrIp: aws.String("192.168.0.1/32"), Description: aws.String("Home")},
+		},
+		Ipv6Ranges: []Ipv6Range{
+			{CidrIpv6: aws.String("2001:db8::/33"), Description: aws.String("Lab")},
+			{CidrIpv6: aws.String("2001:db8:8000::/33")},
+		},
+	}
+
+	ipPermission.aggregate()
+
+	assert.Equal(t, []IpRange{
+		{CidrIp: aws.String("10.0.0.0/23"), Description: aws.String("Office A, Office B")},
+		{CidrIp: aws.String("192.168.0.1/32"), Description: aws.String("Home")},
+	}, ipPermission.IpRanges)
+	assert.Equal(t, []Ipv6Range{
+		{CidrIpv6: aws.String("2001:db8::/32"), Description: aws.String("Lab")},
+	}, ipPermission.Ipv6Ranges)
+}
+
+func TestAggregateLeavesEmptyRangesAlone(t *testing.T) {
+	ipPermission := IpPermission{
+		Aggregate: true,
+		IpRanges: []IpRange{
+			{CidrIp: aws.String("10.0.0.0/8")},
+		},
+	}
+
+	ipPermission.aggregate()
+
+	assert.Equal(t, []IpRange{{CidrIp: aws.String("10.0.0.0/8")}}, ipPermission.IpRanges)
+	assert.Nil(t, ipPermission.Ipv6Ranges)
+}
+
+func TestCombineDescriptionsTruncatesOnCharacters(t *testing.T) {
+	combinedDescription := combineDescriptions([]string{"Office A " + strings.Repeat("a", maximumDescriptionLength-10) + "é", "Office B"})
+
+	assert.True(t, utf8.ValidString(*combinedDescription))
+	assert.Equal(t, maximumDescriptionLength, utf8.RuneCountInString(*combinedDescription))
+	assert.True(t, strings.HasSuffix(*combinedDescription, "aé"))
+}
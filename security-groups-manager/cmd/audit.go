@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	configurationHashTagKey = "configuration-hash"
+	lastReconciledAtTagKey  = "last-reconciled-at"
+	lastRunIdTagKey         = "last-run-id"
+	managedByTagKey         = "managed-by"
+	resolvedHostsTagKey     = "resolved-hosts"
+)
+
+const managedByTagValue = "SecurityGroupsManager"
+
+const maximumTagValueLength = 256
+
+var reservedTagKeys = map[string]bool{
+	configurationHashTagKey: true,
+	lastReconciledAtTagKey:  true,
+	lastRunIdTagKey:         true,
+	managedByTagKey:         true,
+	resolvedHostsTagKey:     true,
+}
+
+func isReservedTagKey(key string) bool {
+	return reservedTagKeys[key]
+}
+
+func hashConfiguredSecurityGroup(configuredSecurityGroup SecurityGroup) string {
+	b, err := json.Marshal(configuredSecurityGroup)
+	if err != nil {
+		logger.Debugf("Unable to marshal configured security group %s: %v", aws.ToString(configuredSecurityGroup.GroupName), err)
+
+		return ""
+	}
+
+	hash := sha256.Sum256(b)
+
+	return hex.EncodeToString(hash[:])
+}
+
+// newAuditTags returns the tags recording how a security group was last reconciled. The run ID traces a change found in
+// CloudTrail back to the logs and reports of the run that made it
+func newAuditTags(configurationHash string, resolvedHostAddresses []string, reconciledAt time.Time, runId string) []types.Tag {
+	sortedResolvedHostAddresses := make([]string, len(resolvedHostAddresses))
+	copy(sortedResolvedHostAddresses, resolvedHostAddresses)
+	sort.Strings(sortedResolvedHostAddresses)
+
+	resolvedHosts := ""
+	for i, resolvedHostAddress := range sortedResolvedHostAddresses {
+		if i > 0 && resolvedHostAddress == sortedResolvedHostAddresses[i-1] {
+			continue
+		}
+		if len(resolvedHosts)+len(resolvedHostAddress)+1 > maximumTagValueLength {
+			break
+		}
+
+		resolvedHosts = strings.TrimSpace(resolvedHosts + " " + resolvedHostAddress)
+	}
+
+	return []types.Tag{
+		{
+			Key:   aws.String(configurationHashTagKey),
+			Value: aws.String(configurationHash),
+		},
+		{
+			Key:   aws.String(lastReconciledAtTagKey),
+			Value: aws.String(reconciledAt.UTC().Format(time.RFC3339)),
+		},
+		{
+			Key:   aws.String(lastRunIdTagKey),
+			Value: aws.String(runId),
+		},
+		{
+			Key:   aws.String(managedByTagKey),
+			Value: aws.String(managedByTagValue),
+		},
+		{
+			Key:   aws.String(resolvedHostsTagKey),
+			Value: aws.String(resolvedHosts),
+		},
+	}
+}
+
+// auditTagsDiffer reports whether the audit tags of a security group no longer record its configuration, resolved hosts
+// or manager. The reconciliation time and run ID are left out, as they differ on every run
+func auditTagsDiffer(asIsTags []types.Tag, auditTags []types.Tag) bool {
+	asIsTagValues := make(map[string]string)
+	for _, tag := range asIsTags {
+		asIsTagValues[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	for _, tag := range auditTags {
+		key := aws.ToString(tag.Key)
+		if key == lastReconciledAtTagKey || key == lastRunIdTagKey {
+			continue
+		}
+
+		if asIsTagValue, ok := asIsTagValues[key]; !ok || asIsTagValue != aws.ToString(tag.Value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuditTags(t *testing.T) {
+	reconciledAt := time.Date(2021, 9, 1, 12, 30, 0, 0, time.FixedZone("EDT", -4*60*60))
+
+	tags := newAuditTags("abc123", []string{"5.6.7.8", "1.2.3.4", "5.6.7.8"}, reconciledAt, "01ARYZ6S41ZZZZZZZZZZZZZZZZ")
+
+	assert.Equal(t, []types.Tag{
+		{Key: aws.String(configurationHashTagKey), Value: aws.String("abc123")},
+		{Key: aws.String(lastReconciledAtTagKey), Value: aws.String("2021-09-01T16:30:00Z")},
+		{Key: aws.String(lastRunIdTagKey), Value: aws.String("01ARYZ6S41ZZZZZZZZZZZZZZZZ")},
+		{Key: aws.String(managedByTagKey), Value: aws.String(managedByTagValue)},
+		{Key: aws.String(resolvedHostsTagKey), Value: aws.String("1.2.3.4 5.6.7.8")},
+	}, tags)
+}
+
+func TestNewAuditTagsTruncatesResolvedHosts(t *testing.T) {
+	resolvedHostAddresses := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		resolvedHostAddresses = append(resolvedHostAddresses, fmt.Sprintf("10.0.0.%d", i))
+	}
+
+	tags := newAuditTags("", resolvedHostAddresses, time.Now(), "")
+
+	assert.Equal(t, resolvedHostsTagKey, *tags[4].Key)
+	assert.LessOrEqual(t, len(*tags[4].Value), maximumTagValueLength)
+}
+
+func TestDiffTagsIgnoresReservedTagKeys(t *testing.T) {
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{})
+
+	asIsTags := append(newAuditTags("abc123", nil, time.Now(), "01ARYZ6S41ZZZZZZZZZZZZZZZZ"), types.Tag{Key: aws.String("Name"), Value: aws.String("old")})
+	toBeTags := []types.Tag{{Key: aws.String("Name"), Value: aws.String("new")}}
+
+	securityGroupDelta.diffTags(asIsTags, toBeTags, &securityGroupDelta.TagsToDelete)
+	securityGroupDelta.diffTags(toBeTags, asIsTags, &securityGroupDelta.TagsToCreate)
+
+	assert.Equal(t, []types.Tag{{Key: aws.String("Name"), Value: aws.String("old")}}, securityGroupDelta.TagsToDelete)
+	assert.Equal(t, toBeTags, securityGroupDelta.TagsToCreate)
+}
+
+func TestAuditTagsDiffer(t *testing.T) {
+	asIsTags := append(newAuditTags("abc123", []string{"192.0.2.1"}, time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC), "01ARYZ6S41ZZZZZZZZZZZZZZZZ"), types.Tag{Key: aws.String("Name"), Value: aws.String("web")})
+
+	assert.False(t, auditTagsDiffer(asIsTags, newAuditTags("abc123", []string{"192.0.2.1"}, time.Now(), "01BX5ZZKBKACTAV9WEVGEMMVRZ")), "The reconciliation time and run ID change on every run")
+	assert.True(t, auditTagsDiffer(asIsTags, newAuditTags("def456", []string{"192.0.2.1"}, time.Now(), "")))
+	assert.True(t, auditTagsDiffer(asIsTags, newAuditTags("abc123", []string{"192.0.2.2"}, time.Now(), "")))
+	assert.True(t, auditTagsDiffer(nil, newAuditTags("abc123", nil, time.Now(), "")))
+}
+
+func TestProcessSecurityGroupDeltasWritesAuditTagsOfInSyncSecurityGroups(t *testing.T) {
+	originalExecutionEnvironment := executionEnvironment
+	defer func() {
+		executionEnvironment = originalExecutionEnvironment
+	}()
+
+	executionEnvironment = new(ExecutionEnvironment)
+	executionEnvironment.OutputFormat = noneOutputFormat
+	executionEnvironment.WriteAuditTags = true
+
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name        string
+		asIsTags    []types.Tag
+		wantActions []string
+	}{
+		{name: "Untagged", wantActions: []string{"CreateTags"}},
+		{name: "Configuration changed", asIsTags: newAuditTags("def456", nil, now.Add(-time.Hour), ""), wantActions: []string{"CreateTags"}},
+		{name: "Audit tags up to date", asIsTags: newAuditTags("abc123", nil, now.Add(-time.Hour), ""), wantActions: []string{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client, actions := newFakeEC2Client(t)
+
+			securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-1"), GroupName: aws.String("web")})
+			securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1"), GroupName: aws.String("web"), Tags: test.asIsTags}
+			securityGroupDelta.ConfigurationHash = "abc123"
+
+			controller := NewController(client)
+			controller.Now = func() time.Time { return now }
+			controller.SecurityGroupDeltas = []SecurityGroupDelta{*securityGroupDelta}
+
+			controller.ProcessSecurityGroupDeltas(true)
+
+			assert.Equal(t, test.wantActions, *actions)
+			assert.Equal(t, inSyncStatus, controller.SecurityGroupDeltas[0].status())
+		})
+	}
+}
@@ -2,33 +2,50 @@ package main
 
 import (
 	"encoding/json"
-	"log"
-	"net"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"inet.af/netaddr"
 )
 
 type Configuration struct {
+	AccessProfiles []AccessProfile
+	Policy         *PolicyConfiguration
 	SecurityGroups []SecurityGroup
 }
 
 func NewConfiguration(marshaledConfiguration string) (*Configuration, error) {
 	configuration := new(Configuration)
 
-	debugf("Unmarshalling configuration")
+	logger.Debugf("Unmarshalling configuration")
 
 	if err := json.Unmarshal([]byte(marshaledConfiguration), configuration); err != nil {
-		log.Printf("Unable to unmarshal configuration: %v", err)
+		logger.Errorf("Unable to unmarshal configuration: %v", err)
 
 		return nil, err
 	}
 
-	debugf("Unmarshalled configuration")
+	configuration.locate([]byte(marshaledConfiguration))
+
+	logger.Debugf("Unmarshalled configuration")
 
 	return configuration, nil
 }
 
+// only returns a copy of the configuration with only the security groups with the given IDs
+func (c *Configuration) only(groupIds []string) *Configuration {
+	configuration := *c
+
+	configuration.SecurityGroups = make([]SecurityGroup, 0, len(groupIds))
+	for _, securityGroup := range c.SecurityGroups {
+		if containsString(groupIds, aws.ToString(securityGroup.GroupId)) {
+			configuration.SecurityGroups = append(configuration.SecurityGroups, securityGroup)
+		}
+	}
+
+	return &configuration
+}
+
 type SecurityGroup struct {
 	Description         *string
 	GroupId             *string
@@ -38,88 +55,131 @@ type SecurityGroup struct {
 	OwnerId             *string
 	Tags                []types.Tag
 	VpcId               *string
+
+	location *ConfigurationLocation
 }
 
-func (s *SecurityGroup) consolidateHostsAndIpRanges(ipPermissions []IpPermission) {
+func (s *SecurityGroup) consolidateHostsAndIpRanges(ipPermissions []IpPermission, hostResolver *HostResolver) []string {
+	resolvedHostAddresses := make([]string, 0)
+
 	for i := range ipPermissions {
 		configuredIpPermission := &ipPermissions[i]
 
 		for _, host := range configuredIpPermission.Hosts {
-			addresses, err := net.LookupHost(*host.FQDN)
+			addresses, err := hostResolver.resolve(host)
 			if err != nil {
-				log.Printf("Unable to lookup host: %v", err)
+				logger.Warnf("Unable to lookup host: %v", err)
 			}
 
 			for _, address := range addresses {
 				ip, err := netaddr.ParseIP(address)
 				if err != nil {
-					log.Printf("Host %s resolved to %s: %v", *host.FQDN, address, err)
+					logger.Warnf("Host %s resolved to %s: %v", host.name(), address, err)
 
 					continue
 				}
 
-				if ip.Is6() {
-					cidrIpv6 := address + "/128"
-					cidrIpv6Found := false
-
-					for _, Ipv6Range := range configuredIpPermission.Ipv6Ranges {
-						if cidrIpv6 == *Ipv6Range.CidrIpv6 {
-							cidrIpv6Found = true
-
-							break
-						}
-					}
-
-					if !cidrIpv6Found {
-						if configuredIpPermission.Ipv6Ranges == nil {
-							configuredIpPermission.Ipv6Ranges = make([]types.Ipv6Range, 0, 1)
-						}
-
-						configuredIpPermission.Ipv6Ranges = append(configuredIpPermission.Ipv6Ranges, types.Ipv6Range{
-							CidrIpv6:    &cidrIpv6,
-							Description: host.Description,
-						})
-					}
-				} else {
-					cidrIp := address + "/32"
-					cidrIpFound := false
-
-					for _, IpRange := range configuredIpPermission.IpRanges {
-						if cidrIp == *IpRange.CidrIp {
-							cidrIpFound = true
-
-							break
-						}
-					}
-
-					if !cidrIpFound {
-						if configuredIpPermission.IpRanges == nil {
-							configuredIpPermission.IpRanges = make([]types.IpRange, 0, 1)
-						}
-
-						configuredIpPermission.IpRanges = append(configuredIpPermission.IpRanges, types.IpRange{
-							CidrIp:      &cidrIp,
-							Description: host.Description,
-						})
-					}
-				}
+				resolvedHostAddresses = append(resolvedHostAddresses, ip.String())
+
+				configuredIpPermission.addPrefix(netaddr.IPPrefixFrom(ip, ip.BitLen()), host.Description)
+			}
+		}
+
+		for _, feed := range configuredIpPermission.Feeds {
+			prefixes, err := feed.expand()
+			if err != nil {
+				logger.Errorf("Unable to expand feed: %v", err)
+
+				continue
+			}
+
+			for _, prefix := range prefixes {
+				configuredIpPermission.addPrefix(prefix, feed.Description)
 			}
 		}
+
+		if configuredIpPermission.Aggregate {
+			configuredIpPermission.aggregate()
+		}
 	}
+
+	return resolvedHostAddresses
 }
 
 type IpPermission struct {
-	FromPort         *int32
-	Hosts            []Host
-	IpProtocol       *string
-	IpRanges         []types.IpRange
-	Ipv6Ranges       []types.Ipv6Range
-	PrefixListIds    []types.PrefixListId
-	ToPort           *int32
-	UserIdGroupPairs []types.UserIdGroupPair
+	ActiveWindow        *string
+	Aggregate           bool
+	ExpiresAt           *string
+	Feeds               []Feed
+	FromPort            *int32
+	Hosts               []Host
+	IpProtocol          *string
+	IpRanges            []IpRange
+	Ipv6Ranges          []Ipv6Range
+	PrefixListIds       []types.PrefixListId
+	SuppressPolicyRules []string
+	ToPort              *int32
+	UserIdGroupPairs    []types.UserIdGroupPair
+
+	location *ConfigurationLocation
+}
+
+// addPrefix adds prefix to the IpRanges or Ipv6Ranges, unless it's already present
+func (i *IpPermission) addPrefix(prefix netaddr.IPPrefix, description *string) {
+	cidr := prefix.String()
+
+	if prefix.IP().Is6() {
+		for _, ipv6Range := range i.Ipv6Ranges {
+			if cidr == *ipv6Range.CidrIpv6 {
+				return
+			}
+		}
+
+		if i.Ipv6Ranges == nil {
+			i.Ipv6Ranges = make([]Ipv6Range, 0, 1)
+		}
+
+		i.Ipv6Ranges = append(i.Ipv6Ranges, Ipv6Range{
+			CidrIpv6:    &cidr,
+			Description: description,
+		})
+	} else {
+		for _, ipRange := range i.IpRanges {
+			if cidr == *ipRange.CidrIp {
+				return
+			}
+		}
+
+		if i.IpRanges == nil {
+			i.IpRanges = make([]IpRange, 0, 1)
+		}
+
+		i.IpRanges = append(i.IpRanges, IpRange{
+			CidrIp:      &cidr,
+			Description: description,
+		})
+	}
+}
+
+type IpRange struct {
+	ActiveWindow *string
+	CidrIp       *string
+	Description  *string
+	ExpiresAt    *string
+}
+
+type Ipv6Range struct {
+	ActiveWindow *string
+	CidrIpv6     *string
+	Description  *string
+	ExpiresAt    *string
 }
 
 type Host struct {
-	FQDN        *string
-	Description *string
+	ActiveWindow *string
+	FQDN         *string
+	Description  *string
+	ExpiresAt    *string
+	JSONPath     *string
+	URL          *string
 }
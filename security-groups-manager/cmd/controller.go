@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
@@ -12,9 +14,24 @@ import (
 )
 
 type Controller struct {
+	AsIsSecurityGroupRulesMutex    sync.Mutex
+	AsIsSecurityGroupRules         map[string][]Rule
 	Client                         *ec2.Client
+	ConfiguredSecurityGroupsMutex  sync.Mutex
+	ConfiguredSecurityGroups       map[string]SecurityGroup
+	ConfigurationHashes            map[string]string
+	Grants                         []Grant
+	HostAddresses                  map[string][]string
+	HostLookupFailures             int
+	Logger                         *Logger
+	Now                            func() time.Time
+	PolicyEvaluators               []PolicyEvaluator
+	PolicyViolations               map[string][]PolicyViolation
+	ResolvedHostAddresses          map[string][]string
+	RunId                          string
 	SecurityGroupIdRegionNameMutex sync.Mutex
 	SecurityGroupIdRegionName      map[string]string
+	StateStore                     StateStore
 	AsIsSecurityGroups             []types.SecurityGroup
 	ToBeSecurityGroups             []types.SecurityGroup
 	SecurityGroupDeltas            []SecurityGroupDelta
@@ -23,7 +40,17 @@ type Controller struct {
 func NewController(client *ec2.Client) *Controller {
 	controller := new(Controller)
 
+	controller.AsIsSecurityGroupRules = make(map[string][]Rule)
 	controller.Client = client
+	controller.ConfiguredSecurityGroups = make(map[string]SecurityGroup)
+	controller.ConfigurationHashes = make(map[string]string)
+	controller.Grants = make([]Grant, 0)
+	controller.HostAddresses = make(map[string][]string)
+	controller.Logger = logger
+	controller.Now = time.Now
+	controller.PolicyEvaluators = make([]PolicyEvaluator, 0)
+	controller.PolicyViolations = make(map[string][]PolicyViolation)
+	controller.ResolvedHostAddresses = make(map[string][]string)
 	controller.SecurityGroupIdRegionName = make(map[string]string)
 	controller.AsIsSecurityGroups = make([]types.SecurityGroup, 0)
 	controller.ToBeSecurityGroups = make([]types.SecurityGroup, 0)
@@ -33,13 +60,22 @@ func NewController(client *ec2.Client) *Controller {
 }
 
 func (c *Controller) CalculateSecurityGroupDeltas() {
-	log.Printf("Calculating security group deltas")
+	c.Logger.Infof("Calculating security group deltas")
 
 	securityGroupDeltaChannel := make(chan SecurityGroupDelta)
 
 	for _, toBeSecurityGroup := range c.ToBeSecurityGroups {
 		go func(toBeSecurityGroup types.SecurityGroup) {
 			securityGroupDelta := NewSecurityGroupDelta(&toBeSecurityGroup)
+			securityGroupDelta.Logger = c.Logger
+			securityGroupDelta.RunId = c.RunId
+
+			c.ConfiguredSecurityGroupsMutex.Lock()
+			securityGroupDelta.ConfigurationHash = c.ConfigurationHashes[*toBeSecurityGroup.GroupId]
+			// The policy evaluators append to the violations, which mustn't write to the slice shared with the other runs
+			securityGroupDelta.PolicyViolations = append(securityGroupDelta.PolicyViolations, c.PolicyViolations[*toBeSecurityGroup.GroupId]...)
+			securityGroupDelta.ResolvedHostAddresses = c.ResolvedHostAddresses[*toBeSecurityGroup.GroupId]
+			c.ConfiguredSecurityGroupsMutex.Unlock()
 
 			for _, asIsSecurityGroup := range c.AsIsSecurityGroups {
 				if *toBeSecurityGroup.VpcId == *asIsSecurityGroup.VpcId && *toBeSecurityGroup.GroupId == *asIsSecurityGroup.GroupId {
@@ -49,7 +85,13 @@ func (c *Controller) CalculateSecurityGroupDeltas() {
 					securityGroupDelta.RegionName = c.SecurityGroupIdRegionName[*securityGroupDelta.AsIsSecurityGroup.GroupId]
 					c.SecurityGroupIdRegionNameMutex.Unlock()
 
+					c.AsIsSecurityGroupRulesMutex.Lock()
+					securityGroupDelta.AsIsRules = c.AsIsSecurityGroupRules[*securityGroupDelta.AsIsSecurityGroup.GroupId]
+					c.AsIsSecurityGroupRulesMutex.Unlock()
+
 					securityGroupDelta.calculate()
+					securityGroupDelta.preflight(executionEnvironment.RulesPerSecurityGroupQuota)
+					securityGroupDelta.evaluatePolicies(c.PolicyEvaluators)
 
 					break
 				}
@@ -65,7 +107,17 @@ func (c *Controller) CalculateSecurityGroupDeltas() {
 		c.SecurityGroupDeltas = append(c.SecurityGroupDeltas, securityGroupDelta)
 	}
 
-	log.Printf("Calculated security group deltas")
+	sortSecurityGroupDeltas(c.SecurityGroupDeltas)
+
+	c.Logger.Infof("Calculated security group deltas")
+}
+
+func (c *Controller) GuardSecurityGroupDeltas(guard *Guard) {
+	c.Logger.Infof("Guarding security group deltas")
+
+	guard.check(c.SecurityGroupDeltas, c.Logger)
+
+	c.Logger.Infof("Guarded security group deltas")
 }
 
 func (c *Controller) InitAsIsSecurityGroups() error {
@@ -73,7 +125,8 @@ func (c *Controller) InitAsIsSecurityGroups() error {
 		AllRegions: aws.Bool(true),
 	})
 	if err != nil {
-		log.Printf("Unable to describe regions: %v", err)
+		c.Logger.with("operation", "DescribeRegions").with("result", "failed").Errorf("Unable to describe regions: %v", err)
+		prometheusRegistry.add(apiErrorsMetricName, 1, "operation", "DescribeRegions", "error_code", errorCode(err))
 
 		return err
 	}
@@ -87,7 +140,8 @@ func (c *Controller) InitAsIsSecurityGroups() error {
 					options.Region = regionName
 				})
 				if err != nil {
-					log.Printf("Unable to describe security groups in region %s: %v", regionName, err)
+					c.Logger.with("region", regionName).with("operation", "DescribeSecurityGroups").with("result", "failed").Errorf("Unable to describe security groups: %v", err)
+					prometheusRegistry.add(apiErrorsMetricName, 1, "operation", "DescribeSecurityGroups", "error_code", errorCode(err))
 
 					asIsSecurityGroupsChannel <- nil
 
@@ -100,6 +154,8 @@ func (c *Controller) InitAsIsSecurityGroups() error {
 					c.SecurityGroupIdRegionNameMutex.Unlock()
 				}
 
+				c.initAsIsSecurityGroupRules(regionName, describeSecurityGroupsOutput.SecurityGroups, nil)
+
 				asIsSecurityGroupsChannel <- describeSecurityGroupsOutput.SecurityGroups
 			}(*region.RegionName)
 		}
@@ -118,26 +174,160 @@ func (c *Controller) InitAsIsSecurityGroups() error {
 	return nil
 }
 
+// InitAsIsSecurityGroupsIn describes only the given security groups, keyed by ID, in their region instead of every
+// security group of every region. The security groups are described with a group-id filter rather than by ID, so that
+// a security group that was deleted is reported as not found instead of failing the others
+func (c *Controller) InitAsIsSecurityGroupsIn(securityGroupRegionNames map[string]string) error {
+	regionNameGroupIds := make(map[string][]string)
+	for groupId, regionName := range securityGroupRegionNames {
+		regionNameGroupIds[regionName] = append(regionNameGroupIds[regionName], groupId)
+	}
+
+	for regionName, groupIds := range regionNameGroupIds {
+		sort.Strings(groupIds)
+
+		filters := []types.Filter{
+			{Name: aws.String("group-id"), Values: groupIds},
+		}
+
+		describeSecurityGroupsInput := &ec2.DescribeSecurityGroupsInput{
+			Filters: filters,
+		}
+
+		securityGroups := make([]types.SecurityGroup, 0, len(groupIds))
+
+		for {
+			describeSecurityGroupsOutput, err := c.Client.DescribeSecurityGroups(context.TODO(), describeSecurityGroupsInput, func(options *ec2.Options) {
+				options.Region = regionName
+			})
+			if err != nil {
+				c.Logger.with("region", regionName).with("operation", "DescribeSecurityGroups").with("result", "failed").Errorf("Unable to describe security groups: %v", err)
+				prometheusRegistry.add(apiErrorsMetricName, 1, "operation", "DescribeSecurityGroups", "error_code", errorCode(err))
+
+				return err
+			}
+
+			securityGroups = append(securityGroups, describeSecurityGroupsOutput.SecurityGroups...)
+
+			if describeSecurityGroupsOutput.NextToken == nil {
+				break
+			}
+
+			describeSecurityGroupsInput.NextToken = describeSecurityGroupsOutput.NextToken
+		}
+
+		for _, securityGroup := range securityGroups {
+			c.SecurityGroupIdRegionName[*securityGroup.GroupId] = regionName
+		}
+
+		c.initAsIsSecurityGroupRules(regionName, securityGroups, filters)
+
+		c.AsIsSecurityGroups = append(c.AsIsSecurityGroups, securityGroups...)
+	}
+
+	return nil
+}
+
+func (c *Controller) initAsIsSecurityGroupRules(regionName string, securityGroups []types.SecurityGroup, filters []types.Filter) {
+	asIsSecurityGroupRules := make(map[string][]Rule, len(securityGroups))
+	for _, securityGroup := range securityGroups {
+		asIsSecurityGroupRules[*securityGroup.GroupId] = make([]Rule, 0)
+	}
+
+	describeSecurityGroupRulesInput := &ec2.DescribeSecurityGroupRulesInput{
+		Filters: filters,
+	}
+
+	for {
+		describeSecurityGroupRulesOutput, err := c.Client.DescribeSecurityGroupRules(context.TODO(), describeSecurityGroupRulesInput, func(options *ec2.Options) {
+			options.Region = regionName
+		})
+		if err != nil {
+			c.Logger.with("region", regionName).with("operation", "DescribeSecurityGroupRules").with("result", "failed").Errorf("Unable to describe security group rules: %v", err)
+			prometheusRegistry.add(apiErrorsMetricName, 1, "operation", "DescribeSecurityGroupRules", "error_code", errorCode(err))
+
+			return
+		}
+
+		for _, securityGroupRule := range describeSecurityGroupRulesOutput.SecurityGroupRules {
+			groupId := aws.ToString(securityGroupRule.GroupId)
+
+			asIsSecurityGroupRules[groupId] = append(asIsSecurityGroupRules[groupId], newRuleFromSecurityGroupRule(securityGroupRule))
+		}
+
+		if describeSecurityGroupRulesOutput.NextToken == nil {
+			break
+		}
+
+		describeSecurityGroupRulesInput.NextToken = describeSecurityGroupRulesOutput.NextToken
+	}
+
+	c.AsIsSecurityGroupRulesMutex.Lock()
+	for groupId, rules := range asIsSecurityGroupRules {
+		c.AsIsSecurityGroupRules[groupId] = rules
+	}
+	c.AsIsSecurityGroupRulesMutex.Unlock()
+}
+
+// InitGrants loads the grants recorded by access requests, they're merged into the configured security groups by
+// InitToBeSecurityGroups
+func (c *Controller) InitGrants(grantStore GrantStore) error {
+	if grantStore == nil {
+		return nil
+	}
+
+	grants, err := grantStore.List()
+	if err != nil {
+		c.Logger.Errorf("Unable to list grants: %v", err)
+
+		return err
+	}
+
+	c.Grants = grants
+
+	return nil
+}
+
 func (c *Controller) InitToBeSecurityGroups(configuration *Configuration) {
+	policy := NewPolicy(configuration.Policy)
+
+	c.PolicyEvaluators = loadPolicyEvaluators(configuration.Policy)
+
+	hostResolver := NewHostResolver(c.StateStore, c.Now)
+
 	toBeSecurityGroupChannel := make(chan *types.SecurityGroup)
 
 	for _, configuredSecurityGroup := range configuration.SecurityGroups {
 		go func(configuredSecurityGroup SecurityGroup) {
-			configuredSecurityGroup.consolidateHostsAndIpRanges(configuredSecurityGroup.IpPermissions)
-			configuredSecurityGroup.consolidateHostsAndIpRanges(configuredSecurityGroup.IpPermissionsEgress)
+			configurationHash := hashConfiguredSecurityGroup(configuredSecurityGroup)
+
+			configuredSecurityGroup.addGrants(c.Grants, configuration.AccessProfiles, c.Now())
+			configuredSecurityGroup.removeInactiveSources(c.Now())
+
+			resolvedHostAddresses := configuredSecurityGroup.consolidateHostsAndIpRanges(configuredSecurityGroup.IpPermissions, hostResolver)
+			resolvedHostAddresses = append(resolvedHostAddresses, configuredSecurityGroup.consolidateHostsAndIpRanges(configuredSecurityGroup.IpPermissionsEgress, hostResolver)...)
+
+			policyViolations := policy.lint(configuredSecurityGroup)
+
+			c.ConfiguredSecurityGroupsMutex.Lock()
+			c.ConfiguredSecurityGroups[aws.ToString(configuredSecurityGroup.GroupId)] = configuredSecurityGroup
+			c.ConfigurationHashes[aws.ToString(configuredSecurityGroup.GroupId)] = configurationHash
+			c.PolicyViolations[aws.ToString(configuredSecurityGroup.GroupId)] = policyViolations
+			c.ResolvedHostAddresses[aws.ToString(configuredSecurityGroup.GroupId)] = resolvedHostAddresses
+			c.ConfiguredSecurityGroupsMutex.Unlock()
 
 			var toBeSecurityGroup types.SecurityGroup
 
 			b, err := json.Marshal(configuredSecurityGroup)
 			if err != nil {
-				log.Printf("Unable to marshal configured security group %s: %v", *configuredSecurityGroup.GroupName, err)
+				c.Logger.Errorf("Unable to marshal configured security group %s: %v", *configuredSecurityGroup.GroupName, err)
 
 				toBeSecurityGroupChannel <- nil
 
 				return
 			}
 			if err := json.Unmarshal(b, &toBeSecurityGroup); err != nil {
-				log.Printf("Unable to unmarshal security group %s: %v", *configuredSecurityGroup.GroupName, err)
+				c.Logger.Errorf("Unable to unmarshal security group %s: %v", *configuredSecurityGroup.GroupName, err)
 
 				toBeSecurityGroupChannel <- nil
 
@@ -154,36 +344,166 @@ func (c *Controller) InitToBeSecurityGroups(configuration *Configuration) {
 			c.ToBeSecurityGroups = append(c.ToBeSecurityGroups, *toBeSecurityGroup)
 		}
 	}
+
+	sortSecurityGroups(c.ToBeSecurityGroups)
+
+	c.HostAddresses = hostResolver.Addresses
+	c.HostLookupFailures = hostResolver.LookupFailures
 }
 
-func (c *Controller) ProcessSecurityGroupDeltas() {
-	log.Printf("Processing security group deltas")
+func (c *Controller) ProcessSecurityGroupDeltas(doApply bool) {
+	c.Logger.Infof("Processing security group deltas")
 
 	securityGroupDeltaApplyChannel := make(chan SecurityGroupDelta)
 
 	for _, securityGroupDelta := range c.SecurityGroupDeltas {
 		go func(securityGroupDelta SecurityGroupDelta) {
-			if securityGroupDelta.AsIsSecurityGroup != nil && (len (securityGroupDelta.IpPermissionsToAuthorize) > 0 || len(securityGroupDelta.IpPermissionsToRevoke) > 0 || len(securityGroupDelta.IpPermissionsToUpdate) > 0 ||
-				len(securityGroupDelta.IpPermissionsEgressToAuthorize) > 0 || len(securityGroupDelta.IpPermissionsEgressToRevoke) > 0 || len(securityGroupDelta.IpPermissionsEgressToUpdate) > 0 ||
-				len(securityGroupDelta.TagsToCreate) > 0 || len(securityGroupDelta.TagsToDelete) > 0) {
-				securityGroupDelta.apply(c.Client)
+			if doApply && securityGroupDelta.AsIsSecurityGroup != nil && (securityGroupDelta.hasChanges() || securityGroupDelta.hasAuditTagChanges()) && securityGroupDelta.canApply() {
+				securityGroupDelta.apply(c.Client, c.Now())
+
+				if c.StateStore != nil && !securityGroupDelta.failed() {
+					if err := c.StateStore.PutAppliedState(AppliedState{
+						AppliedAt:         c.Now(),
+						GroupId:           aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupId),
+						RegionName:        securityGroupDelta.RegionName,
+						ToBeSecurityGroup: securityGroupDelta.ToBeSecurityGroup,
+					}); err != nil {
+						securityGroupDelta.logger().Errorf("Unable to record applied state: %v", err)
+					}
+				}
 			}
 
 			securityGroupDeltaApplyChannel <- securityGroupDelta
 		}(securityGroupDelta)
 	}
 
+	processedSecurityGroupDeltas := make([]SecurityGroupDelta, 0, len(c.SecurityGroupDeltas))
+
 	for range c.SecurityGroupDeltas {
-		securityGroupDelta := <-securityGroupDeltaApplyChannel
+		processedSecurityGroupDeltas = append(processedSecurityGroupDeltas, <-securityGroupDeltaApplyChannel)
+	}
+
+	sortSecurityGroupDeltas(processedSecurityGroupDeltas)
+
+	c.SecurityGroupDeltas = processedSecurityGroupDeltas
+
+	for i := range c.SecurityGroupDeltas {
+		securityGroupDelta := &c.SecurityGroupDeltas[i]
+
+		securityGroupDelta.logger().with("operation", "Process").with("result", securityGroupDelta.status()).Infof("Processed %s", aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupName))
+	}
+
+	switch executionEnvironment.OutputFormat {
+	case jsonOutputFormat:
+		report, err := NewReport(c.RunId, c.SecurityGroupDeltas).marshal()
+		if err != nil {
+			c.Logger.Errorf("Unable to marshal report: %v", err)
+		} else {
+			fmt.Println(report)
+		}
+	case sarifOutputFormat:
+		sarifLog := NewSARIFLog(executionEnvironment.SARIFArtifactUri, c.RunId)
+		for i := range c.SecurityGroupDeltas {
+			securityGroupDelta := &c.SecurityGroupDeltas[i]
+
+			sarifLog.addSecurityGroupDelta(securityGroupDelta, c.ConfiguredSecurityGroups[aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupId)])
+		}
+
+		report, err := sarifLog.marshal()
+		if err != nil {
+			c.Logger.Errorf("Unable to marshal report: %v", err)
+		} else {
+			fmt.Println(report)
+		}
+	case diffOutputFormat, htmlOutputFormat, markdownOutputFormat, plainOutputFormat, tableOutputFormat:
+		renderer := newRenderer(executionEnvironment.OutputFormat)
+
+		for i := range c.SecurityGroupDeltas {
+			securityGroupDelta := &c.SecurityGroupDeltas[i]
+
+			if securityGroupDelta.status() != inSyncStatus || len(securityGroupDelta.PolicyViolations) > 0 {
+				fmt.Println(renderer.Render(securityGroupDelta))
+			}
+		}
+	}
 
-		if securityGroupDelta.AsIsSecurityGroup == nil || len (securityGroupDelta.IpPermissionsToAuthorize) > 0 || len(securityGroupDelta.IpPermissionsToRevoke) > 0 || len(securityGroupDelta.IpPermissionsToUpdate) > 0 ||
-				len(securityGroupDelta.IpPermissionsEgressToAuthorize) > 0 || len(securityGroupDelta.IpPermissionsEgressToRevoke) > 0 || len(securityGroupDelta.IpPermissionsEgressToUpdate) > 0 ||
-				len(securityGroupDelta.TagsToCreate) > 0 || len(securityGroupDelta.TagsToDelete) > 0 {
-			log.Println("\n" + securityGroupDelta.tabulate())
+	c.Logger.Infof("Processed security group deltas")
+}
+
+// Notify notifies about the security groups that drifted or failed to apply
+func (c *Controller) Notify(notificationDispatcher *NotificationDispatcher, command string) {
+	if notificationDispatcher == nil {
+		return
+	}
+
+	notificationDispatcher.dispatch(command, c.RunId, c.SecurityGroupDeltas, c.StateStore)
+}
+
+// RecordRunOutcome records the status and results of every security group delta in the state store
+func (c *Controller) RecordRunOutcome(command string, startedAt time.Time) {
+	if c.StateStore == nil {
+		return
+	}
+
+	if err := c.StateStore.PutRunOutcome(*NewRunOutcome(command, c.RunId, startedAt, c.Now(), c.SecurityGroupDeltas)); err != nil {
+		c.Logger.Errorf("Unable to record run outcome: %v", err)
+	}
+}
+
+// ValidateToBeSecurityGroups outputs the policy violations of every configured security group and returns an error if
+// any of them has an error severity
+func (c *Controller) ValidateToBeSecurityGroups() error {
+	c.Logger.Infof("Validating security groups")
+
+	validationReport := NewValidationReport(c.RunId, c.ToBeSecurityGroups, c.PolicyViolations)
+
+	for _, securityGroupValidation := range validationReport.SecurityGroups {
+		validationLogger := c.Logger.with("group_id", securityGroupValidation.GroupId).with("operation", "Validate")
+
+		if len(securityGroupValidation.PolicyViolations) == 0 {
+			validationLogger.with("result", "passed").Infof("%s has no policy violations", securityGroupValidation.GroupName)
+
+			continue
+		}
+
+		for _, policyViolation := range securityGroupValidation.PolicyViolations {
+			if policyViolation.Severity == errorSeverity {
+				validationLogger.with("result", "failed").Errorf("%s: %s", securityGroupValidation.GroupName, policyViolation)
+			} else {
+				validationLogger.with("result", "warned").Warnf("%s: %s", securityGroupValidation.GroupName, policyViolation)
+			}
+		}
+	}
+
+	switch executionEnvironment.OutputFormat {
+	case jsonOutputFormat:
+		report, err := validationReport.marshal()
+		if err != nil {
+			c.Logger.Errorf("Unable to marshal report: %v", err)
 		} else {
-			log.Printf("%s / %s is up to date", *securityGroupDelta.AsIsSecurityGroup.GroupId, *securityGroupDelta.AsIsSecurityGroup.GroupName)
+			fmt.Println(report)
+		}
+	case sarifOutputFormat:
+		sarifLog := NewSARIFLog(executionEnvironment.SARIFArtifactUri, c.RunId)
+		for _, securityGroupValidation := range validationReport.SecurityGroups {
+			sarifLog.addPolicyViolations(fmt.Sprintf("%s (%s)", securityGroupValidation.GroupName, securityGroupValidation.GroupId), securityGroupValidation.PolicyViolations, c.ConfiguredSecurityGroups[securityGroupValidation.GroupId])
+		}
+
+		report, err := sarifLog.marshal()
+		if err != nil {
+			c.Logger.Errorf("Unable to marshal report: %v", err)
+		} else {
+			fmt.Println(report)
 		}
 	}
 
-	log.Printf("Processed security group deltas")
+	c.Logger.Infof("Validated security groups")
+
+	for _, securityGroupValidation := range validationReport.SecurityGroups {
+		if hasErrorPolicyViolations(securityGroupValidation.PolicyViolations) {
+			return fmt.Errorf("policy violations found")
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	reconcileIntervalEnvironmentVariableName = "RECONCILE_INTERVAL"
+	reconcileJitterEnvironmentVariableName   = "RECONCILE_JITTER"
+	serveAddressEnvironmentVariableName      = "SERVE_ADDRESS"
+)
+
+const (
+	defaultReconcileInterval = 5 * time.Minute
+	defaultReconcileJitter   = 30 * time.Second
+	defaultServeAddress      = ":8080"
+)
+
+// How often the configuration file is checked for changes
+const configurationPollInterval = 10 * time.Second
+
+// How long in-flight HTTP requests are given to complete on shutdown
+const shutdownTimeout = 30 * time.Second
+
+// Daemon reconciles on an interval with jitter until its context is done. A reconciliation in flight when the context
+// is done is always completed, so apply calls are never interrupted. When ConfigurationPath is set, the configuration
+// file is polled and, once it changed and is valid, handed to Reload and reconciled immediately
+type Daemon struct {
+	Configuration     []byte
+	ConfigurationPath string
+	Interval          time.Duration
+	Jitter            time.Duration
+	LastRunError      error
+	LastRunFinishedAt time.Time
+	Mutex             sync.Mutex
+	PollInterval      time.Duration
+	Random            *rand.Rand
+	Reconcile         func() error
+	Reload            func(configuration *Configuration)
+	ShuttingDown      bool
+}
+
+func NewDaemon(interval time.Duration, jitter time.Duration, configurationPath string, reconcile func() error, reload func(configuration *Configuration)) *Daemon {
+	daemon := new(Daemon)
+
+	daemon.ConfigurationPath = configurationPath
+	daemon.Interval = interval
+	daemon.Jitter = jitter
+	daemon.PollInterval = configurationPollInterval
+	daemon.Random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	daemon.Reconcile = reconcile
+	daemon.Reload = reload
+
+	return daemon
+}
+
+func (d *Daemon) Run(ctx context.Context) {
+	if d.ConfigurationPath != "" {
+		d.Configuration, _ = ioutil.ReadFile(d.ConfigurationPath)
+	}
+
+	var poll <-chan time.Time
+	if d.ConfigurationPath != "" {
+		pollTicker := time.NewTicker(d.PollInterval)
+		defer pollTicker.Stop()
+
+		poll = pollTicker.C
+	}
+
+	for {
+		d.run()
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		timer := time.NewTimer(d.nextInterval())
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				d.shutDown()
+
+				return
+			case <-timer.C:
+				break wait
+			case <-poll:
+				if d.reloadConfiguration() {
+					timer.Stop()
+
+					break wait
+				}
+			}
+		}
+	}
+
+	d.shutDown()
+}
+
+func (d *Daemon) run() {
+	err := d.Reconcile()
+	if err != nil {
+		logger.Errorf("Unable to reconcile: %v", err)
+	}
+
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+
+	d.LastRunError = err
+	d.LastRunFinishedAt = time.Now()
+}
+
+func (d *Daemon) shutDown() {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+
+	d.ShuttingDown = true
+}
+
+func (d *Daemon) nextInterval() time.Duration {
+	if d.Jitter <= 0 {
+		return d.Interval
+	}
+
+	return d.Interval + time.Duration(d.Random.Int63n(int64(d.Jitter)))
+}
+
+// reloadConfiguration returns true when the configuration file changed and the new configuration was handed to Reload.
+// An invalid configuration is logged once and the previous configuration is kept
+func (d *Daemon) reloadConfiguration() bool {
+	b, err := ioutil.ReadFile(d.ConfigurationPath)
+	if err != nil {
+		logger.Errorf("Unable to read configuration file %s: %v", d.ConfigurationPath, err)
+
+		return false
+	}
+
+	if bytes.Equal(b, d.Configuration) {
+		return false
+	}
+	d.Configuration = b
+
+	configuration, err := NewConfiguration(string(b))
+	if err != nil {
+		logger.Errorf("Unable to reload configuration file %s, keeping the previous configuration: %v", d.ConfigurationPath, err)
+
+		return false
+	}
+
+	logger.Infof("Reloaded configuration file %s", d.ConfigurationPath)
+
+	d.Reload(configuration)
+
+	return true
+}
+
+// ServeHealthz responds 200 until the daemon is shutting down
+func (d *Daemon) ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+
+	if d.ShuttingDown {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// ServeReadyz responds 200 once the last reconciliation succeeded
+func (d *Daemon) ServeReadyz(w http.ResponseWriter, r *http.Request) {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+
+	switch {
+	case d.ShuttingDown:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case d.LastRunFinishedAt.IsZero():
+		http.Error(w, "not reconciled yet", http.StatusServiceUnavailable)
+	case d.LastRunError != nil:
+		http.Error(w, fmt.Sprintf("last reconciliation failed: %v", d.LastRunError), http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ok")
+	}
+}
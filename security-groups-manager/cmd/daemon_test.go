@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonNextInterval(t *testing.T) {
+	daemon := NewDaemon(time.Minute, 10*time.Second, "", nil, nil)
+
+	for i := 0; i < 100; i++ {
+		interval := daemon.nextInterval()
+		assert.GreaterOrEqual(t, int64(interval), int64(time.Minute))
+		assert.Less(t, int64(interval), int64(time.Minute+10*time.Second))
+	}
+
+	daemon.Jitter = 0
+	assert.Equal(t, time.Minute, daemon.nextInterval())
+}
+
+func TestDaemonReloadConfiguration(t *testing.T) {
+	configurationPath := filepath.Join(t.TempDir(), "configuration.json")
+	assert.NoError(t, os.WriteFile(configurationPath, []byte(`{"SecurityGroups":[]}`), 0600))
+
+	var reloadedConfiguration *Configuration
+	daemon := NewDaemon(time.Minute, 0, configurationPath, nil, func(configuration *Configuration) {
+		reloadedConfiguration = configuration
+	})
+	daemon.Configuration = []byte(`{"SecurityGroups":[]}`)
+
+	assert.False(t, daemon.reloadConfiguration(), "Unchanged")
+	assert.Nil(t, reloadedConfiguration)
+
+	assert.NoError(t, os.WriteFile(configurationPath, []byte(`{"SecurityGroups":[`), 0600))
+	assert.False(t, daemon.reloadConfiguration(), "Invalid")
+	assert.Nil(t, reloadedConfiguration)
+
+	assert.NoError(t, os.WriteFile(configurationPath, []byte(`{"SecurityGroups":[{"GroupId":"sg-1"}]}`), 0600))
+	assert.True(t, daemon.reloadConfiguration(), "Changed")
+	if assert.NotNil(t, reloadedConfiguration) && assert.Len(t, reloadedConfiguration.SecurityGroups, 1) {
+		assert.Equal(t, "sg-1", aws.ToString(reloadedConfiguration.SecurityGroups[0].GroupId))
+	}
+}
+
+func TestDaemonRun(t *testing.T) {
+	configurationPath := filepath.Join(t.TempDir(), "configuration.json")
+	assert.NoError(t, os.WriteFile(configurationPath, []byte(`{"SecurityGroups":[]}`), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := make(chan int, 10)
+	reloads := 0
+
+	daemon := NewDaemon(time.Hour, 0, configurationPath, nil, func(configuration *Configuration) {
+		reloads++
+	})
+	daemon.PollInterval = 10 * time.Millisecond
+	daemon.Reconcile = func() error {
+		runs <- len(runs)
+
+		if len(runs) == 2 {
+			// Cancelled while reconciling, the reconciliation still completes
+			cancel()
+
+			return fmt.Errorf("throttled")
+		}
+
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		daemon.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return len(runs) == 1 }, time.Second, 5*time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	daemon.ServeReadyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// The interval is an hour, only a configuration change triggers the second reconciliation
+	assert.NoError(t, os.WriteFile(configurationPath, []byte(`{"SecurityGroups":[{"GroupId":"sg-1"}]}`), 0600))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Daemon didn't stop")
+	}
+
+	assert.Equal(t, 2, len(runs))
+	assert.Equal(t, 1, reloads)
+	assert.EqualError(t, daemon.LastRunError, "throttled")
+
+	recorder = httptest.NewRecorder()
+	daemon.ServeHealthz(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
+
+func TestDaemonServeReadyz(t *testing.T) {
+	daemon := NewDaemon(time.Minute, 0, "", nil, nil)
+
+	tests := []struct {
+		name           string
+		lastRunError   error
+		finishedAt     time.Time
+		wantStatusCode int
+	}{
+		{name: "Not reconciled yet", wantStatusCode: http.StatusServiceUnavailable},
+		{name: "Failed", lastRunError: fmt.Errorf("throttled"), finishedAt: time.Now(), wantStatusCode: http.StatusServiceUnavailable},
+		{name: "Succeeded", finishedAt: time.Now(), wantStatusCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			daemon.LastRunError = test.lastRunError
+			daemon.LastRunFinishedAt = test.finishedAt
+
+			recorder := httptest.NewRecorder()
+			daemon.ServeReadyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, test.wantStatusCode, recorder.Code)
+		})
+	}
+}
+
+// newFakeEC2Client returns an EC2 client whose requests are served by an HTTP server that records the actions called.
+// The region has a single security group sg-1 without rules
+func newFakeEC2Client(t *testing.T) (*ec2.Client, *[]string) {
+	var actionsMutex sync.Mutex
+	actions := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+
+		action := r.Form.Get("Action")
+
+		actionsMutex.Lock()
+		actions = append(actions, action)
+		actionsMutex.Unlock()
+
+		w.Header().Set("Content-Type", "text/xml")
+
+		switch action {
+		case "DescribeRegions":
+			fmt.Fprint(w, `<DescribeRegionsResponse><regionInfo><item><regionName>eu-west-1</regionName><optInStatus>opt-in-not-required</optInStatus></item></regionInfo></DescribeRegionsResponse>`)
+		case "DescribeSecurityGroups":
+			fmt.Fprint(w, `<DescribeSecurityGroupsResponse><securityGroupInfo><item><groupId>sg-1</groupId><groupName>bastion</groupName><vpcId>vpc-1</vpcId></item></securityGroupInfo></DescribeSecurityGroupsResponse>`)
+		case "DescribeSecurityGroupRules":
+			fmt.Fprint(w, `<DescribeSecurityGroupRulesResponse><securityGroupRuleSet/></DescribeSecurityGroupRulesResponse>`)
+		default:
+			fmt.Fprintf(w, `<%sResponse><return>true</return></%sResponse>`, action, action)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return ec2.New(ec2.Options{
+		Credentials:      staticCredentialsProvider{},
+		EndpointResolver: ec2.EndpointResolverFromURL(server.URL),
+		HTTPClient:       server.Client(),
+		Region:           "eu-west-1",
+		Retryer:          aws.NopRetryer{},
+	}), &actions
+}
+
+func TestDaemonServeMuxReconcilesAccessRequestsWithTheDaemonCommand(t *testing.T) {
+	originalExecutionEnvironment := executionEnvironment
+	defer func() {
+		executionEnvironment = originalExecutionEnvironment
+	}()
+
+	for _, test := range []struct {
+		command     string
+		wantActions []string
+	}{
+		{command: planCommand, wantActions: []string{"DescribeRegions", "DescribeSecurityGroups", "DescribeSecurityGroupRules"}},
+		{command: applyCommand, wantActions: []string{"DescribeRegions", "DescribeSecurityGroups", "DescribeSecurityGroupRules", "AuthorizeSecurityGroupIngress"}},
+	} {
+		t.Run(test.command, func(t *testing.T) {
+			configuration, err := NewConfiguration(`{
+				"AccessProfiles": [{"GroupId": "sg-1", "IpPermission": {"FromPort": 22, "IpProtocol": "tcp", "ToPort": 22}, "Name": "ssh", "TTL": "1h"}],
+				"SecurityGroups": [{"GroupId": "sg-1", "GroupName": "bastion", "VpcId": "vpc-1"}]
+			}`)
+			require.NoError(t, err)
+
+			client, actions := newFakeEC2Client(t)
+
+			executionEnvironment = new(ExecutionEnvironment)
+			executionEnvironment.AccessRequestToken = "token"
+			executionEnvironment.Client = client
+			executionEnvironment.Configuration = configuration
+			executionEnvironment.GrantStore = new(memoryGrantStore)
+			executionEnvironment.Guard = new(Guard)
+			executionEnvironment.IsLongRunning = true
+			executionEnvironment.OutputFormat = noneOutputFormat
+			executionEnvironment.RulesPerSecurityGroupQuota = defaultRulesPerSecurityGroupQuota
+
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"Profile": "ssh", "Requester": "alice"}`))
+			request.Header.Set("Authorization", "Bearer token")
+			request.RemoteAddr = "192.0.2.1:51234"
+
+			recorder := httptest.NewRecorder()
+			newDaemonServeMux(NewDaemon(time.Minute, 0, "", nil, nil), test.command).ServeHTTP(recorder, request)
+
+			assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+			backgroundReconciliations.Wait()
+			assert.Equal(t, test.wantActions, *actions)
+		})
+	}
+}
@@ -3,53 +3,83 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
-	"github.com/jedib0t/go-pretty/v6/table"
-	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+const (
+	blockedStatus      = "blocked"
+	changedStatus      = "changed"
+	guardTrippedStatus = "guard-tripped"
+	inSyncStatus       = "in-sync"
+	notFoundStatus     = "not-found"
+	refusedStatus      = "refused"
 )
 
 type SecurityGroupDelta struct {
-	AsIsSecurityGroup                    *types.SecurityGroup
-	IpPermissionsToAuthorize             []types.IpPermission
-	IpPermissionsToAuthorizeResult       string
-	IpPermissionsToRevoke                []types.IpPermission
-	IpPermissionsToRevokeResult          string
-	IpPermissionsToUpdate                []types.IpPermission
-	IpPermissionsToUpdateResult          string
-	IpPermissionsEgressToAuthorize       []types.IpPermission
-	IpPermissionsEgressToAuthorizeResult string
-	IpPermissionsEgressToRevoke          []types.IpPermission
-	IpPermissionsEgressToRevokeResult    string
-	IpPermissionsEgressToUpdate          []types.IpPermission
-	IpPermissionsEgressToUpdateResult    string
-	RegionName                           string
-	TagsToCreate                         []types.Tag
-	TagsToCreateResult                   string
-	TagsToDelete                         []types.Tag
-	TagsToDeleteResult                   string
-	ToBeSecurityGroup                    *types.SecurityGroup
+	AsIsRules                     []Rule
+	AsIsSecurityGroup             *types.SecurityGroup
+	AuditTagsResult               string
+	ConfigurationHash             string
+	EgressRulesToAuthorize        []Rule
+	EgressRulesToAuthorizeResult  string
+	EgressRulesToRevoke           []Rule
+	EgressRulesToRevokeResult     string
+	EgressRulesToUpdate           []Rule
+	EgressRulesToUpdateResult     string
+	GuardViolations               []string
+	IngressRulesToAuthorize       []Rule
+	IngressRulesToAuthorizeResult string
+	IngressRulesToRevoke          []Rule
+	IngressRulesToRevokeResult    string
+	IngressRulesToUpdate          []Rule
+	IngressRulesToUpdateResult    string
+	Logger                        *Logger
+	PolicyViolations              []PolicyViolation
+	QuotaViolations               []string
+	RegionName                    string
+	ResolvedHostAddresses         []string
+	RulesToModify                 []RuleModification
+	RulesToModifyResult           string
+	RunId                         string
+	TagsToCreate                  []types.Tag
+	TagsToCreateResult            string
+	TagsToDelete                  []types.Tag
+	TagsToDeleteResult            string
+	ToBeSecurityGroup             *types.SecurityGroup
 }
 
 func NewSecurityGroupDelta(toBeSecurityGroup *types.SecurityGroup) *SecurityGroupDelta {
 	securityGroupDelta := new(SecurityGroupDelta)
 
+	securityGroupDelta.AsIsRules = nil
 	securityGroupDelta.AsIsSecurityGroup = nil
-	securityGroupDelta.IpPermissionsToAuthorize = make([]types.IpPermission, 0)
-	securityGroupDelta.IpPermissionsToAuthorizeResult = ""
-	securityGroupDelta.IpPermissionsToRevoke = make([]types.IpPermission, 0)
-	securityGroupDelta.IpPermissionsToRevokeResult = ""
-	securityGroupDelta.IpPermissionsToUpdate = make([]types.IpPermission, 0)
-	securityGroupDelta.IpPermissionsToUpdateResult = ""
-	securityGroupDelta.IpPermissionsEgressToAuthorize = make([]types.IpPermission, 0)
-	securityGroupDelta.IpPermissionsEgressToAuthorizeResult = ""
-	securityGroupDelta.IpPermissionsEgressToRevoke = make([]types.IpPermission, 0)
-	securityGroupDelta.IpPermissionsEgressToRevokeResult = ""
-	securityGroupDelta.IpPermissionsEgressToUpdate = make([]types.IpPermission, 0)
-	securityGroupDelta.IpPermissionsEgressToUpdateResult = ""
+	securityGroupDelta.AuditTagsResult = ""
+	securityGroupDelta.ConfigurationHash = ""
+	securityGroupDelta.EgressRulesToAuthorize = make([]Rule, 0)
+	securityGroupDelta.EgressRulesToAuthorizeResult = ""
+	securityGroupDelta.EgressRulesToRevoke = make([]Rule, 0)
+	securityGroupDelta.EgressRulesToRevokeResult = ""
+	securityGroupDelta.EgressRulesToUpdate = make([]Rule, 0)
+	securityGroupDelta.EgressRulesToUpdateResult = ""
+	securityGroupDelta.GuardViolations = make([]string, 0)
+	securityGroupDelta.IngressRulesToAuthorize = make([]Rule, 0)
+	securityGroupDelta.IngressRulesToAuthorizeResult = ""
+	securityGroupDelta.IngressRulesToRevoke = make([]Rule, 0)
+	securityGroupDelta.IngressRulesToRevokeResult = ""
+	securityGroupDelta.IngressRulesToUpdate = make([]Rule, 0)
+	securityGroupDelta.IngressRulesToUpdateResult = ""
+	securityGroupDelta.Logger = logger
+	securityGroupDelta.PolicyViolations = make([]PolicyViolation, 0)
+	securityGroupDelta.QuotaViolations = make([]string, 0)
+	securityGroupDelta.ResolvedHostAddresses = make([]string, 0)
+	securityGroupDelta.RulesToModify = make([]RuleModification, 0)
+	securityGroupDelta.RulesToModifyResult = ""
+	securityGroupDelta.RunId = ""
 	securityGroupDelta.TagsToCreate = make([]types.Tag, 0)
 	securityGroupDelta.TagsToCreateResult = ""
 	securityGroupDelta.TagsToDelete = make([]types.Tag, 0)
@@ -59,132 +89,172 @@ func NewSecurityGroupDelta(toBeSecurityGroup *types.SecurityGroup) *SecurityGrou
 	return securityGroupDelta
 }
 
-func (s *SecurityGroupDelta) apply(client *ec2.Client) {
-	log.Printf("Applying remediations")
+func (s *SecurityGroupDelta) apply(client *ec2.Client, now time.Time) {
+	s.logger().Infof("Applying remediations")
+
+	if len(s.RulesToModify) > 0 {
+		securityGroupRuleUpdates := make([]types.SecurityGroupRuleUpdate, 0, len(s.RulesToModify))
+		for _, ruleModification := range s.RulesToModify {
+			securityGroupRuleUpdates = append(securityGroupRuleUpdates, types.SecurityGroupRuleUpdate{
+				SecurityGroupRule:   ruleModification.To.securityGroupRuleRequest(),
+				SecurityGroupRuleId: aws.String(ruleModification.RuleId),
+			})
+		}
 
-	if len(s.IpPermissionsToRevoke) > 0 {
-		if _, err := client.RevokeSecurityGroupIngress(context.TODO(), &ec2.RevokeSecurityGroupIngressInput{
+		_, err := client.ModifySecurityGroupRules(context.TODO(), &ec2.ModifySecurityGroupRulesInput{
+			GroupId:            s.AsIsSecurityGroup.GroupId,
+			SecurityGroupRules: securityGroupRuleUpdates,
+		}, func(options *ec2.Options) {
+			options.Region = s.RegionName
+		})
+		s.RulesToModifyResult = s.result("ModifySecurityGroupRules", "modify rules", err)
+	}
+
+	if len(s.IngressRulesToRevoke) > 0 {
+		_, err := client.RevokeSecurityGroupIngress(context.TODO(), &ec2.RevokeSecurityGroupIngressInput{
 			GroupId:       s.AsIsSecurityGroup.GroupId,
-			IpPermissions: s.IpPermissionsToRevoke,
+			IpPermissions: ipPermissionsFromRules(s.IngressRulesToRevoke),
 		}, func(options *ec2.Options) {
 			options.Region = s.RegionName
-		}); err != nil {
-			s.IpPermissionsToRevokeResult = fmt.Sprintf("Failed to revoke inbound rules: %v", err)
-		} else {
-			s.IpPermissionsToRevokeResult = "Succeeded to revoke inbound rules"
-		}
+		})
+		s.IngressRulesToRevokeResult = s.result("RevokeSecurityGroupIngress", "revoke inbound rules", err)
 	}
 
-	if len(s.IpPermissionsToAuthorize) > 0 {
-		if _, err := client.AuthorizeSecurityGroupIngress(context.TODO(), &ec2.AuthorizeSecurityGroupIngressInput{
+	if len(s.IngressRulesToAuthorize) > 0 {
+		_, err := client.AuthorizeSecurityGroupIngress(context.TODO(), &ec2.AuthorizeSecurityGroupIngressInput{
 			GroupId:       s.ToBeSecurityGroup.GroupId,
-			IpPermissions: s.IpPermissionsToAuthorize,
+			IpPermissions: ipPermissionsFromRules(s.IngressRulesToAuthorize),
 		}, func(options *ec2.Options) {
 			options.Region = s.RegionName
-		}); err != nil {
-			s.IpPermissionsToAuthorizeResult = fmt.Sprintf("Failed to authorize inbound rules: %v", err)
-		} else {
-			s.IpPermissionsToAuthorizeResult = "Succeeded to authorize inbound rules"
-		}
+		})
+		s.IngressRulesToAuthorizeResult = s.result("AuthorizeSecurityGroupIngress", "authorize inbound rules", err)
 	}
 
-	if len(s.IpPermissionsToUpdate) > 0 {
-		if _, err := client.UpdateSecurityGroupRuleDescriptionsIngress(context.TODO(), &ec2.UpdateSecurityGroupRuleDescriptionsIngressInput{
+	if len(s.IngressRulesToUpdate) > 0 {
+		_, err := client.UpdateSecurityGroupRuleDescriptionsIngress(context.TODO(), &ec2.UpdateSecurityGroupRuleDescriptionsIngressInput{
 			GroupId:       s.ToBeSecurityGroup.GroupId,
-			IpPermissions: s.IpPermissionsToUpdate,
+			IpPermissions: ipPermissionsFromRules(s.IngressRulesToUpdate),
 		}, func(options *ec2.Options) {
 			options.Region = s.RegionName
-		}); err != nil {
-			s.IpPermissionsToUpdateResult = fmt.Sprintf("Failed to update inbound rules: %v", err)
-		} else {
-			s.IpPermissionsToUpdateResult = "Succeeded to update inbound rules"
-		}
+		})
+		s.IngressRulesToUpdateResult = s.result("UpdateSecurityGroupRuleDescriptionsIngress", "update inbound rules", err)
 	}
 
-	if len(s.IpPermissionsEgressToRevoke) > 0 {
-		if _, err := client.RevokeSecurityGroupEgress(context.TODO(), &ec2.RevokeSecurityGroupEgressInput{
+	if len(s.EgressRulesToRevoke) > 0 {
+		_, err := client.RevokeSecurityGroupEgress(context.TODO(), &ec2.RevokeSecurityGroupEgressInput{
 			GroupId:       s.AsIsSecurityGroup.GroupId,
-			IpPermissions: s.IpPermissionsEgressToRevoke,
+			IpPermissions: ipPermissionsFromRules(s.EgressRulesToRevoke),
 		}, func(options *ec2.Options) {
 			options.Region = s.RegionName
-		}); err != nil {
-			s.IpPermissionsEgressToRevokeResult = fmt.Sprintf("Failed to revoke outbound rules: %v", err)
-		} else {
-			s.IpPermissionsEgressToRevokeResult = "Succeeded to revoke outbound rules"
-		}
+		})
+		s.EgressRulesToRevokeResult = s.result("RevokeSecurityGroupEgress", "revoke outbound rules", err)
 	}
 
-	if len(s.IpPermissionsEgressToAuthorize) > 0 {
-		if _, err := client.AuthorizeSecurityGroupEgress(context.TODO(), &ec2.AuthorizeSecurityGroupEgressInput{
+	if len(s.EgressRulesToAuthorize) > 0 {
+		_, err := client.AuthorizeSecurityGroupEgress(context.TODO(), &ec2.AuthorizeSecurityGroupEgressInput{
 			GroupId:       s.ToBeSecurityGroup.GroupId,
-			IpPermissions: s.IpPermissionsEgressToAuthorize,
+			IpPermissions: ipPermissionsFromRules(s.EgressRulesToAuthorize),
 		}, func(options *ec2.Options) {
 			options.Region = s.RegionName
-		}); err != nil {
-			s.IpPermissionsEgressToAuthorizeResult = fmt.Sprintf("Failed to authorize outbound rules: %v", err)
-		} else {
-			s.IpPermissionsEgressToAuthorizeResult = "Succeeded to authorize outbound rules"
-		}
+		})
+		s.EgressRulesToAuthorizeResult = s.result("AuthorizeSecurityGroupEgress", "authorize outbound rules", err)
 	}
 
-	if len(s.IpPermissionsEgressToUpdate) > 0 {
-		if _, err := client.UpdateSecurityGroupRuleDescriptionsEgress(context.TODO(), &ec2.UpdateSecurityGroupRuleDescriptionsEgressInput{
+	if len(s.EgressRulesToUpdate) > 0 {
+		_, err := client.UpdateSecurityGroupRuleDescriptionsEgress(context.TODO(), &ec2.UpdateSecurityGroupRuleDescriptionsEgressInput{
 			GroupId:       s.ToBeSecurityGroup.GroupId,
-			IpPermissions: s.IpPermissionsEgressToUpdate,
+			IpPermissions: ipPermissionsFromRules(s.EgressRulesToUpdate),
 		}, func(options *ec2.Options) {
 			options.Region = s.RegionName
-		}); err != nil {
-			s.IpPermissionsEgressToUpdateResult = fmt.Sprintf("Failed to update outbound rules: %v", err)
-		} else {
-			s.IpPermissionsEgressToUpdateResult = "Succeeded to update outbound rules"
-		}
+		})
+		s.EgressRulesToUpdateResult = s.result("UpdateSecurityGroupRuleDescriptionsEgress", "update outbound rules", err)
 	}
 
 	if len(s.TagsToDelete) > 0 {
-		if _, err := client.DeleteTags(context.TODO(), &ec2.DeleteTagsInput{
+		_, err := client.DeleteTags(context.TODO(), &ec2.DeleteTagsInput{
 			Resources: []string{
 				*s.AsIsSecurityGroup.GroupId,
 			},
 			Tags: s.TagsToDelete,
 		}, func(options *ec2.Options) {
 			options.Region = s.RegionName
-		}); err != nil {
-			s.TagsToDeleteResult = fmt.Sprintf("Failed to delete tags: %v", err)
-		} else {
-			s.TagsToDeleteResult = "Succeeded to delete tags"
-		}
+		})
+		s.TagsToDeleteResult = s.result("DeleteTags", "delete tags", err)
 	}
 
 	if len(s.TagsToCreate) > 0 {
-		if _, err := client.CreateTags(context.TODO(), &ec2.CreateTagsInput{
+		_, err := client.CreateTags(context.TODO(), &ec2.CreateTagsInput{
 			Resources: []string{
 				*s.AsIsSecurityGroup.GroupId,
 			},
 			Tags: s.TagsToCreate,
 		}, func(options *ec2.Options) {
 			options.Region = s.RegionName
-		}); err != nil {
-			s.TagsToCreateResult = fmt.Sprintf("Failed to create tags: %v", err)
-		} else {
-			s.TagsToCreateResult = "Succeeded to create tags"
+		})
+		s.TagsToCreateResult = s.result("CreateTags", "create tags", err)
+	}
+
+	if executionEnvironment.WriteAuditTags && !s.failed() {
+		_, err := client.CreateTags(context.TODO(), &ec2.CreateTagsInput{
+			Resources: []string{
+				*s.AsIsSecurityGroup.GroupId,
+			},
+			Tags: newAuditTags(s.ConfigurationHash, s.ResolvedHostAddresses, now, s.RunId),
+		}, func(options *ec2.Options) {
+			options.Region = s.RegionName
+		})
+		s.AuditTagsResult = s.result("CreateTags", "write audit tags", err)
+	}
+
+	s.logger().Infof("Applied remediations")
+}
+
+func (s *SecurityGroupDelta) asIsRules(direction string) []Rule {
+	if s.AsIsRules == nil {
+		ownerId := aws.ToString(s.AsIsSecurityGroup.OwnerId)
+
+		if direction == ingressDirection {
+			return flattenIpPermissions(ingressDirection, s.AsIsSecurityGroup.IpPermissions, ownerId)
 		}
+
+		return flattenIpPermissions(egressDirection, s.AsIsSecurityGroup.IpPermissionsEgress, ownerId)
 	}
 
-	log.Printf("Applied remediations")
+	rules := make([]Rule, 0, len(s.AsIsRules))
+	for _, rule := range s.AsIsRules {
+		if rule.Direction == direction {
+			rules = append(rules, rule)
+		}
+	}
+
+	sortRules(rules)
+
+	return rules
 }
 
 func (s *SecurityGroupDelta) calculate() {
-	asIsSecurityGroupIpPermissions := s.AsIsSecurityGroup.IpPermissions
-	toBeSecurityGroupIpPermissions := s.ToBeSecurityGroup.IpPermissions
+	normalizeSecurityGroup(s.AsIsSecurityGroup)
+	normalizeSecurityGroup(s.ToBeSecurityGroup)
 
-	s.diffIpPermissions(asIsSecurityGroupIpPermissions, toBeSecurityGroupIpPermissions, &s.IpPermissionsToRevoke, nil)
-	s.diffIpPermissions(toBeSecurityGroupIpPermissions, asIsSecurityGroupIpPermissions, &s.IpPermissionsToAuthorize, &s.IpPermissionsToUpdate)
+	ownerId := aws.ToString(s.AsIsSecurityGroup.OwnerId)
 
-	asIsSecurityGroupIpPermissionsEgress := s.AsIsSecurityGroup.IpPermissionsEgress
-	toBeSecurityGroupIpPermissionsEgress := s.ToBeSecurityGroup.IpPermissionsEgress
+	asIsIngressRules := s.asIsRules(ingressDirection)
+	ingressRulesToRevoke, ingressRulesToAuthorize, ingressRulesToUpdate := diffRules(
+		asIsIngressRules,
+		flattenIpPermissions(ingressDirection, s.ToBeSecurityGroup.IpPermissions, ownerId),
+	)
+	ingressRulesToModify, ingressRulesToRevoke, ingressRulesToAuthorize, ingressRulesToUpdate := splitRuleModifications(asIsIngressRules, ingressRulesToRevoke, ingressRulesToAuthorize, ingressRulesToUpdate)
 
-	s.diffIpPermissions(asIsSecurityGroupIpPermissionsEgress, toBeSecurityGroupIpPermissionsEgress, &s.IpPermissionsEgressToRevoke, nil)
-	s.diffIpPermissions(toBeSecurityGroupIpPermissionsEgress, asIsSecurityGroupIpPermissionsEgress, &s.IpPermissionsEgressToAuthorize, &s.IpPermissionsEgressToUpdate)
+	asIsEgressRules := s.asIsRules(egressDirection)
+	egressRulesToRevoke, egressRulesToAuthorize, egressRulesToUpdate := diffRules(
+		asIsEgressRules,
+		flattenIpPermissions(egressDirection, s.ToBeSecurityGroup.IpPermissionsEgress, ownerId),
+	)
+	egressRulesToModify, egressRulesToRevoke, egressRulesToAuthorize, egressRulesToUpdate := splitRuleModifications(asIsEgressRules, egressRulesToRevoke, egressRulesToAuthorize, egressRulesToUpdate)
+
+	s.IngressRulesToRevoke, s.IngressRulesToAuthorize, s.IngressRulesToUpdate = ingressRulesToRevoke, ingressRulesToAuthorize, ingressRulesToUpdate
+	s.EgressRulesToRevoke, s.EgressRulesToAuthorize, s.EgressRulesToUpdate = egressRulesToRevoke, egressRulesToAuthorize, egressRulesToUpdate
+	s.RulesToModify = append(ingressRulesToModify, egressRulesToModify...)
 
 	asIsSecurityGroupTags := s.AsIsSecurityGroup.Tags
 	toBeSecurityGroupTags := s.ToBeSecurityGroup.Tags
@@ -193,174 +263,17 @@ func (s *SecurityGroupDelta) calculate() {
 	s.diffTags(toBeSecurityGroupTags, asIsSecurityGroupTags, &s.TagsToCreate)
 }
 
-func (s *SecurityGroupDelta) diffIpPermissions(thisIpPermissions []types.IpPermission, otherIpPermissions []types.IpPermission, ipPermissions *[]types.IpPermission, ipPermissionsToUpdate *[]types.IpPermission) {
-	for _, thisIpPermission := range thisIpPermissions {
-		ipPermissionFound := false
-
-		for _, otherIpPermission := range otherIpPermissions {
-			if determinePortRange(thisIpPermission) == determinePortRange(otherIpPermission) && determineProtocol(thisIpPermission) == determineProtocol(otherIpPermission) {
-				ipPermissionFound = true
-
-				for _, thisIpRange := range thisIpPermission.IpRanges {
-					ipRangeCidrIpFound := false
-
-					for _, otherIpRange := range otherIpPermission.IpRanges {
-						if *thisIpRange.CidrIp == *otherIpRange.CidrIp {
-							ipRangeCidrIpFound = true
-
-							if ipPermissionsToUpdate != nil {
-								if (thisIpRange.Description != nil && otherIpRange.Description != nil && *thisIpRange.Description != *otherIpRange.Description) ||
-									(thisIpRange.Description != otherIpRange.Description && (thisIpRange.Description == nil || otherIpRange.Description == nil)) {
-									*ipPermissionsToUpdate = append(*ipPermissionsToUpdate, types.IpPermission{
-										FromPort:   thisIpPermission.FromPort,
-										IpProtocol: thisIpPermission.IpProtocol,
-										IpRanges: []types.IpRange{
-											thisIpRange,
-										},
-										ToPort: thisIpPermission.ToPort,
-									})
-								}
-							}
-
-							break
-						}
-					}
-
-					if !ipRangeCidrIpFound {
-						*ipPermissions = append(*ipPermissions, types.IpPermission{
-							FromPort:   thisIpPermission.FromPort,
-							IpProtocol: thisIpPermission.IpProtocol,
-							IpRanges: []types.IpRange{
-								thisIpRange,
-							},
-							ToPort: thisIpPermission.ToPort,
-						})
-					}
-				}
-
-				for _, thisIpv6Range := range thisIpPermission.Ipv6Ranges {
-					ipv6RangeCidrIpFound := false
-
-					for _, otherIpv6Range := range otherIpPermission.Ipv6Ranges {
-						if *thisIpv6Range.CidrIpv6 == *otherIpv6Range.CidrIpv6 {
-							ipv6RangeCidrIpFound = true
-
-							if *ipPermissionsToUpdate != nil {
-								if (thisIpv6Range.Description != nil && otherIpv6Range.Description != nil && *thisIpv6Range.Description != *otherIpv6Range.Description) ||
-									(thisIpv6Range.Description != otherIpv6Range.Description && (thisIpv6Range.Description == nil || otherIpv6Range.Description == nil)) {
-									*ipPermissionsToUpdate = append(*ipPermissionsToUpdate, types.IpPermission{
-										FromPort:   thisIpPermission.FromPort,
-										IpProtocol: thisIpPermission.IpProtocol,
-										Ipv6Ranges: []types.Ipv6Range{
-											thisIpv6Range,
-										},
-										ToPort: thisIpPermission.ToPort,
-									})
-								}
-							}
-
-							break
-						}
-					}
-
-					if !ipv6RangeCidrIpFound {
-						*ipPermissions = append(*ipPermissions, types.IpPermission{
-							FromPort:   thisIpPermission.FromPort,
-							IpProtocol: thisIpPermission.IpProtocol,
-							Ipv6Ranges: []types.Ipv6Range{
-								thisIpv6Range,
-							},
-							ToPort: thisIpPermission.ToPort,
-						})
-					}
-				}
-
-				for _, thisPrefixListId := range thisIpPermission.PrefixListIds {
-					prefixListIdFound := false
-
-					for _, otherPrefixListId := range otherIpPermission.PrefixListIds {
-						if *thisPrefixListId.PrefixListId == *otherPrefixListId.PrefixListId {
-							prefixListIdFound = true
-
-							if ipPermissionsToUpdate != nil && *thisPrefixListId.Description != *otherPrefixListId.Description {
-								*ipPermissionsToUpdate = append(*ipPermissionsToUpdate, types.IpPermission{
-									FromPort:   thisIpPermission.FromPort,
-									IpProtocol: thisIpPermission.IpProtocol,
-									PrefixListIds: []types.PrefixListId{
-										thisPrefixListId,
-									},
-									ToPort: thisIpPermission.ToPort,
-								})
-							}
-
-							break
-						}
-					}
-
-					if !prefixListIdFound {
-						*ipPermissions = append(*ipPermissions, types.IpPermission{
-							FromPort:   thisIpPermission.FromPort,
-							IpProtocol: thisIpPermission.IpProtocol,
-							PrefixListIds: []types.PrefixListId{
-								thisPrefixListId,
-							},
-							ToPort: thisIpPermission.ToPort,
-						})
-					}
-				}
-
-				for _, thisUserIdGroupPair := range thisIpPermission.UserIdGroupPairs {
-					userIdGroupPairFound := false
-
-					for _, otherUserIdGroupPair := range otherIpPermission.UserIdGroupPairs {
-						if *thisUserIdGroupPair.UserId == *otherUserIdGroupPair.UserId && *thisUserIdGroupPair.GroupId == *otherUserIdGroupPair.GroupId {
-							userIdGroupPairFound = true
-
-							if *ipPermissionsToUpdate != nil && *thisUserIdGroupPair.Description != *otherUserIdGroupPair.Description {
-								*ipPermissionsToUpdate = append(*ipPermissionsToUpdate, types.IpPermission{
-									FromPort:   thisIpPermission.FromPort,
-									IpProtocol: thisIpPermission.IpProtocol,
-									ToPort:     thisIpPermission.ToPort,
-									UserIdGroupPairs: []types.UserIdGroupPair{
-										thisUserIdGroupPair,
-									},
-								})
-							}
-
-							break
-						}
-					}
-
-					if !userIdGroupPairFound {
-						*ipPermissions = append(*ipPermissions, types.IpPermission{
-							FromPort:   thisIpPermission.FromPort,
-							IpProtocol: thisIpPermission.IpProtocol,
-							ToPort:     thisIpPermission.ToPort,
-							UserIdGroupPairs: []types.UserIdGroupPair{
-								thisUserIdGroupPair,
-							},
-						})
-					}
-				}
-			}
-		}
-
-		if !ipPermissionFound {
-			*ipPermissions = append(*ipPermissions, types.IpPermission{
-				FromPort:         thisIpPermission.FromPort,
-				IpProtocol:       thisIpPermission.IpProtocol,
-				IpRanges:         thisIpPermission.IpRanges,
-				Ipv6Ranges:       thisIpPermission.Ipv6Ranges,
-				PrefixListIds:    thisIpPermission.PrefixListIds,
-				ToPort:           thisIpPermission.ToPort,
-				UserIdGroupPairs: thisIpPermission.UserIdGroupPairs,
-			})
-		}
-	}
+// canApply reports whether the delta passed every pre-flight check, the policy and the blast-radius guard
+func (s *SecurityGroupDelta) canApply() bool {
+	return len(s.QuotaViolations) == 0 && !hasErrorPolicyViolations(s.PolicyViolations) && len(s.GuardViolations) == 0
 }
 
 func (s *SecurityGroupDelta) diffTags(thisTags []types.Tag, otherTags []types.Tag, tags *[]types.Tag) {
 	for _, thisTag := range thisTags {
+		if isReservedTagKey(*thisTag.Key) {
+			continue
+		}
+
 		tagFound := false
 
 		for _, otherTag := range otherTags {
@@ -380,154 +293,313 @@ func (s *SecurityGroupDelta) diffTags(thisTags []types.Tag, otherTags []types.Ta
 	}
 }
 
-func (s *SecurityGroupDelta) tabulate() string {
-	securityGroupDeltaTable := table.NewWriter()
-
-	if s.AsIsSecurityGroup != nil {
-		securityGroupDeltaTable.AppendHeader(table.Row{"As is", "To be", "Remediation", "Result"})
-		securityGroupDeltaTable.SetColumnConfigs([]table.ColumnConfig{
-			{
-				Number:      1,
-				AlignHeader: text.AlignCenter,
-				Align:       text.AlignCenter,
-				VAlign:      text.VAlignMiddle,
-			},
-			{
-				Number:      2,
-				AlignHeader: text.AlignCenter,
-				Align:       text.AlignCenter,
-				VAlign:      text.VAlignMiddle,
-			},
-			{
-				Number:      3,
-				AlignHeader: text.AlignCenter,
-				Align:       text.AlignCenter,
-				VAlign:      text.VAlignMiddle,
-			},
-			{
-				Number:      4,
-				AlignHeader: text.AlignCenter,
-				Align:       text.AlignCenter,
-				VAlign:      text.VAlignMiddle,
-			},
-		})
-		securityGroupDeltaTable.Style().Box = table.StyleBoxRounded
-		securityGroupDeltaTable.Style().Format = table.FormatOptions{
-			Header: text.FormatDefault,
-		}
-		securityGroupDeltaTable.Style().Options.SeparateRows = true
+// evaluatePolicies appends the denials of every policy evaluator to the policy violations. A policy that fails to
+// evaluate is treated as a denial
+func (s *SecurityGroupDelta) evaluatePolicies(policyEvaluators []PolicyEvaluator) {
+	if len(policyEvaluators) == 0 {
+		return
+	}
 
-		securityGroupDeltaTable.AppendRow(table.Row{
-			tabulateSecurityGroup(*s.AsIsSecurityGroup),
-			tabulateSecurityGroup(*s.ToBeSecurityGroup),
-			"",
-			"",
-		})
+	policyInput := NewPolicyInput(s)
 
-		ipPermissionsRemediation := make([]string, 0, 3)
-		ipPermissionsRemediationResult := make([]string, 0, 3)
+	for _, policyEvaluator := range policyEvaluators {
+		policyViolations, err := policyEvaluator.Evaluate(*policyInput)
+		if err != nil {
+			s.PolicyViolations = append(s.PolicyViolations, PolicyViolation{
+				Message:  fmt.Sprintf("Unable to evaluate policy: %v", err),
+				Rule:     policyEvaluator.Name(),
+				Severity: errorSeverity,
+			})
 
-		if len(s.IpPermissionsToRevoke) > 0 {
-			ipPermissionsRemediation = append(ipPermissionsRemediation, tabulateIpPermissions(s.IpPermissionsToRevoke, *s.AsIsSecurityGroup, "Inbound rules to revoke"))
-			ipPermissionsRemediationResult = append(ipPermissionsRemediationResult, s.IpPermissionsToRevokeResult)
+			continue
 		}
-		if len(s.IpPermissionsToAuthorize) > 0 {
-			ipPermissionsRemediation = append(ipPermissionsRemediation, tabulateIpPermissions(s.IpPermissionsToAuthorize, *s.AsIsSecurityGroup, "Inbound rules to authorize"))
-			ipPermissionsRemediationResult = append(ipPermissionsRemediationResult, s.IpPermissionsToAuthorizeResult)
-		}
-		if len(s.IpPermissionsToUpdate) > 0 {
-			ipPermissionsRemediation = append(ipPermissionsRemediation, tabulateIpPermissions(s.IpPermissionsToUpdate, *s.AsIsSecurityGroup, "Inbound rules to update"))
-			ipPermissionsRemediationResult = append(ipPermissionsRemediationResult, s.IpPermissionsToUpdateResult)
+
+		s.PolicyViolations = append(s.PolicyViolations, policyViolations...)
+	}
+}
+
+func (s *SecurityGroupDelta) failed() bool {
+	for _, result := range s.results() {
+		if strings.HasPrefix(result, "Failed") {
+			return true
 		}
+	}
 
-		securityGroupDeltaTable.AppendRow(table.Row{
-			tabulateIpPermissions(s.AsIsSecurityGroup.IpPermissions, *s.AsIsSecurityGroup, "Inbound rules"),
-			tabulateIpPermissions(s.ToBeSecurityGroup.IpPermissions, *s.ToBeSecurityGroup, "Inbound rules"),
-			strings.Join(ipPermissionsRemediation, "\n"),
-			strings.Join(ipPermissionsRemediationResult, "\n"),
-		})
+	return false
+}
 
-		ipPermissionsEgressRemediation := make([]string, 0, 3)
-		ipPermissionsEgressRemediationResult := make([]string, 0, 3)
+// hasAuditTagChanges reports whether the audit tags have to be written even though the security group is in sync
+func (s *SecurityGroupDelta) hasAuditTagChanges() bool {
+	if !executionEnvironment.WriteAuditTags || s.AsIsSecurityGroup == nil {
+		return false
+	}
 
-		if len(s.IpPermissionsEgressToRevoke) > 0 {
-			ipPermissionsEgressRemediation = append(ipPermissionsEgressRemediation, tabulateIpPermissions(s.IpPermissionsEgressToRevoke, *s.AsIsSecurityGroup, "Outbound rules to revoke"))
-			ipPermissionsEgressRemediationResult = append(ipPermissionsEgressRemediationResult, s.IpPermissionsEgressToRevokeResult)
-		}
-		if len(s.IpPermissionsEgressToAuthorize) > 0 {
-			ipPermissionsEgressRemediation = append(ipPermissionsEgressRemediation, tabulateIpPermissions(s.IpPermissionsEgressToAuthorize, *s.AsIsSecurityGroup, "Outbound rules to authorize"))
-			ipPermissionsEgressRemediationResult = append(ipPermissionsEgressRemediationResult, s.IpPermissionsEgressToAuthorizeResult)
-		}
-		if len(s.IpPermissionsEgressToUpdate) > 0 {
-			ipPermissionsEgressRemediation = append(ipPermissionsEgressRemediation, tabulateIpPermissions(s.IpPermissionsEgressToUpdate, *s.AsIsSecurityGroup, "Outbound rules to update"))
-			ipPermissionsEgressRemediationResult = append(ipPermissionsEgressRemediationResult, s.IpPermissionsEgressToUpdateResult)
+	return auditTagsDiffer(s.AsIsSecurityGroup.Tags, newAuditTags(s.ConfigurationHash, s.ResolvedHostAddresses, time.Time{}, s.RunId))
+}
+
+func (s *SecurityGroupDelta) hasChanges() bool {
+	return len(s.RulesToModify) > 0 || len(s.IngressRulesToAuthorize) > 0 || len(s.IngressRulesToRevoke) > 0 || len(s.IngressRulesToUpdate) > 0 ||
+		len(s.EgressRulesToAuthorize) > 0 || len(s.EgressRulesToRevoke) > 0 || len(s.EgressRulesToUpdate) > 0 ||
+		len(s.TagsToCreate) > 0 || len(s.TagsToDelete) > 0
+}
+
+func (s *SecurityGroupDelta) status() string {
+	switch {
+	case s.AsIsSecurityGroup == nil:
+		return notFoundStatus
+	case len(s.QuotaViolations) > 0:
+		return refusedStatus
+	case hasErrorPolicyViolations(s.PolicyViolations):
+		return blockedStatus
+	case len(s.GuardViolations) > 0:
+		return guardTrippedStatus
+	case s.hasChanges():
+		return changedStatus
+	default:
+		return inSyncStatus
+	}
+}
+
+func (s *SecurityGroupDelta) results() []string {
+	results := make([]string, 0)
+
+	for _, result := range []string{
+		s.RulesToModifyResult,
+		s.IngressRulesToRevokeResult,
+		s.IngressRulesToAuthorizeResult,
+		s.IngressRulesToUpdateResult,
+		s.EgressRulesToRevokeResult,
+		s.EgressRulesToAuthorizeResult,
+		s.EgressRulesToUpdateResult,
+		s.TagsToDeleteResult,
+		s.TagsToCreateResult,
+		s.AuditTagsResult,
+	} {
+		if result != "" {
+			results = append(results, result)
 		}
+	}
 
-		securityGroupDeltaTable.AppendRow(table.Row{
-			tabulateIpPermissions(s.AsIsSecurityGroup.IpPermissionsEgress, *s.AsIsSecurityGroup, "Outbound rules"),
-			tabulateIpPermissions(s.ToBeSecurityGroup.IpPermissionsEgress, *s.ToBeSecurityGroup, "Outbound rules"),
-			strings.Join(ipPermissionsEgressRemediation, "\n"),
-			strings.Join(ipPermissionsEgressRemediationResult, "\n"),
-		})
+	return results
+}
+
+func (s *SecurityGroupDelta) logger() *Logger {
+	return s.Logger.with("group_id", aws.ToString(s.ToBeSecurityGroup.GroupId)).with("region", s.RegionName)
+}
+
+// result logs the outcome of an operation and returns it as the result recorded in the delta
+func (s *SecurityGroupDelta) result(operation string, action string, err error) string {
+	operationLogger := s.logger().with("operation", operation)
 
-		tagsRemediation := make([]string, 0, 3)
-		tagsRemediationResult := make([]string, 0, 3)
+	if err != nil {
+		operationLogger.with("result", "failed").Errorf("Failed to %s: %v", action, err)
+		prometheusRegistry.add(apiErrorsMetricName, 1, "operation", operation, "error_code", errorCode(err))
 
-		if len(s.TagsToDelete) > 0 {
-			tagsRemediation = append(tagsRemediation, tabulateTags(s.TagsToDelete, "Tags to delete"))
-			tagsRemediationResult = append(tagsRemediationResult, s.TagsToDeleteResult)
+		return fmt.Sprintf("Failed to %s: %v", action, err)
+	}
+
+	operationLogger.with("result", "succeeded").Infof("Succeeded to %s", action)
+
+	return fmt.Sprintf("Succeeded to %s", action)
+}
+
+func (s *SecurityGroupDelta) rulesToModify(direction string) []RuleModification {
+	ruleModifications := make([]RuleModification, 0, len(s.RulesToModify))
+	for _, ruleModification := range s.RulesToModify {
+		if ruleModification.From.Direction == direction {
+			ruleModifications = append(ruleModifications, ruleModification)
 		}
-		if len(s.TagsToCreate) > 0 {
-			tagsRemediation = append(tagsRemediation, tabulateTags(s.TagsToCreate, "Tags to create"))
-			tagsRemediationResult = append(tagsRemediationResult, s.TagsToCreateResult)
+	}
+
+	return ruleModifications
+}
+
+// report returns the content of the report of the delta, shared by every Renderer
+func (s *SecurityGroupDelta) report() DeltaReport {
+	title := s.title()
+
+	if s.AsIsSecurityGroup == nil {
+		return DeltaReport{
+			Header: []string{"As is", "To be"},
+			Rows: []ReportRow{
+				{
+					Cells: []ReportCell{
+						textCell(fmt.Sprintf("No matching security group found with ID: %s in VPC: %s", *s.ToBeSecurityGroup.GroupId, *s.ToBeSecurityGroup.VpcId)),
+						tablesCell(newSecurityGroupTable(*s.ToBeSecurityGroup)),
+					},
+					Title: "Security group",
+				},
+				{
+					Cells: []ReportCell{
+						textCell(),
+						tablesCell(newIpPermissionsTable(s.ToBeSecurityGroup.IpPermissions, *s.ToBeSecurityGroup, "Inbound rules")),
+					},
+					Title: "Inbound rules",
+				},
+				{
+					Cells: []ReportCell{
+						textCell(),
+						tablesCell(newIpPermissionsTable(s.ToBeSecurityGroup.IpPermissionsEgress, *s.ToBeSecurityGroup, "Outbound rules")),
+					},
+					Title: "Outbound rules",
+				},
+				{
+					Cells: []ReportCell{
+						textCell(),
+						tablesCell(newTagsTable(s.ToBeSecurityGroup.Tags, "Tags")),
+					},
+					Title: "Tags",
+				},
+			},
+			Title: title,
 		}
+	}
 
-		securityGroupDeltaTable.AppendRow(table.Row{
-			tabulateTags(s.AsIsSecurityGroup.Tags, "Tags"),
-			tabulateTags(s.ToBeSecurityGroup.Tags, "Tags"),
-			strings.Join(tagsRemediation, "\n"),
-			strings.Join(tagsRemediationResult, "\n"),
-		})
-	} else {
-		securityGroupDeltaTable.AppendHeader(table.Row{"As is", "To be"})
-		securityGroupDeltaTable.SetColumnConfigs([]table.ColumnConfig{
+	deltaReport := DeltaReport{
+		Header: []string{"As is", "To be", "Remediation", "Result"},
+		Rows: []ReportRow{
 			{
-				Number:      1,
-				AlignHeader: text.AlignCenter,
-				Align:       text.AlignCenter,
+				Cells: []ReportCell{
+					tablesCell(newSecurityGroupTable(*s.AsIsSecurityGroup)),
+					tablesCell(newSecurityGroupTable(*s.ToBeSecurityGroup)),
+					textCell(),
+					textCell(),
+				},
+				Title: "Security group",
 			},
-			{
-				Number:      2,
-				AlignHeader: text.AlignCenter,
-				Align:       text.AlignCenter,
+			s.rulesReportRow(ingressDirection),
+			s.rulesReportRow(egressDirection),
+		},
+		Title: title,
+	}
+
+	tagsRemediation := tablesCell()
+	tagsRemediationResult := textCell()
+
+	if len(s.TagsToDelete) > 0 {
+		tagsRemediation.Tables = append(tagsRemediation.Tables, newTagsTable(s.TagsToDelete, "Tags to delete"))
+		tagsRemediationResult.Lines = append(tagsRemediationResult.Lines, s.TagsToDeleteResult)
+	}
+	if len(s.TagsToCreate) > 0 {
+		tagsRemediation.Tables = append(tagsRemediation.Tables, newTagsTable(s.TagsToCreate, "Tags to create"))
+		tagsRemediationResult.Lines = append(tagsRemediationResult.Lines, s.TagsToCreateResult)
+	}
+	if s.AuditTagsResult != "" {
+		tagsRemediationResult.Lines = append(tagsRemediationResult.Lines, s.AuditTagsResult)
+	}
+
+	deltaReport.Rows = append(deltaReport.Rows, ReportRow{
+		Cells: []ReportCell{
+			tablesCell(newTagsTable(s.AsIsSecurityGroup.Tags, "Tags")),
+			tablesCell(newTagsTable(s.ToBeSecurityGroup.Tags, "Tags")),
+			tagsRemediation,
+			tagsRemediationResult,
+		},
+		Title: "Tags",
+	})
+
+	if len(s.QuotaViolations) > 0 {
+		deltaReport.Rows = append(deltaReport.Rows, ReportRow{
+			Cells: []ReportCell{
+				textCell(),
+				textCell(),
+				textCell("Refused by quota pre-flight check"),
+				textCell(s.QuotaViolations...),
 			},
+			Title: "Quotas",
 		})
-		securityGroupDeltaTable.Style().Box = table.StyleBoxRounded
-		securityGroupDeltaTable.Style().Format = table.FormatOptions{
-			Header: text.FormatDefault,
+	}
+
+	if len(s.PolicyViolations) > 0 {
+		policyViolations := make([]string, 0, len(s.PolicyViolations))
+		for _, policyViolation := range s.PolicyViolations {
+			policyViolations = append(policyViolations, policyViolation.String())
 		}
-		securityGroupDeltaTable.Style().Options.SeparateRows = true
 
-		securityGroupDeltaTable.AppendRow(table.Row{
-			fmt.Sprintf("No matching security group found with ID: %s in VPC: %s", *s.ToBeSecurityGroup.GroupId, *s.ToBeSecurityGroup.VpcId),
-			tabulateSecurityGroup(*s.ToBeSecurityGroup),
-		})
+		remediation := "Policy violations"
+		if hasErrorPolicyViolations(s.PolicyViolations) {
+			remediation = "Blocked by policy violations"
+		}
 
-		securityGroupDeltaTable.AppendRow(table.Row{
-			"",
-			tabulateIpPermissions(s.ToBeSecurityGroup.IpPermissions, *s.ToBeSecurityGroup, "Inbound rules"),
+		deltaReport.Rows = append(deltaReport.Rows, ReportRow{
+			Cells: []ReportCell{
+				textCell(),
+				textCell(),
+				textCell(remediation),
+				textCell(policyViolations...),
+			},
+			Title: "Policies",
 		})
+	}
 
-		securityGroupDeltaTable.AppendRow(table.Row{
-			"",
-			tabulateIpPermissions(s.ToBeSecurityGroup.IpPermissionsEgress, *s.ToBeSecurityGroup, "Outbound rules"),
+	if len(s.GuardViolations) > 0 {
+		deltaReport.Rows = append(deltaReport.Rows, ReportRow{
+			Cells: []ReportCell{
+				textCell(),
+				textCell(),
+				textCell("Skipped by blast-radius guard"),
+				textCell(s.GuardViolations...),
+			},
+			Title: "Blast-radius guard",
 		})
+	}
 
-		securityGroupDeltaTable.AppendRow(table.Row{
-			"",
-			tabulateTags(s.ToBeSecurityGroup.Tags, "Tags"),
-		})
+	return deltaReport
+}
+
+// title returns the name of the security group followed by its ID and region, such as "web (sg-1, eu-west-1)"
+func (s *SecurityGroupDelta) title() string {
+	if s.RegionName == "" {
+		return fmt.Sprintf("%s (%s)", aws.ToString(s.ToBeSecurityGroup.GroupName), aws.ToString(s.ToBeSecurityGroup.GroupId))
+	}
+
+	return fmt.Sprintf("%s (%s, %s)", aws.ToString(s.ToBeSecurityGroup.GroupName), aws.ToString(s.ToBeSecurityGroup.GroupId), s.RegionName)
+}
+
+// rulesReportRow returns the row of the report listing the rules of the direction and their remediation
+func (s *SecurityGroupDelta) rulesReportRow(direction string) ReportRow {
+	directionTitle := "Inbound"
+	ipPermissions := s.ToBeSecurityGroup.IpPermissions
+	rulesToAuthorize, rulesToAuthorizeResult := s.IngressRulesToAuthorize, s.IngressRulesToAuthorizeResult
+	rulesToRevoke, rulesToRevokeResult := s.IngressRulesToRevoke, s.IngressRulesToRevokeResult
+	rulesToUpdate, rulesToUpdateResult := s.IngressRulesToUpdate, s.IngressRulesToUpdateResult
+
+	if direction == egressDirection {
+		directionTitle = "Outbound"
+		ipPermissions = s.ToBeSecurityGroup.IpPermissionsEgress
+		rulesToAuthorize, rulesToAuthorizeResult = s.EgressRulesToAuthorize, s.EgressRulesToAuthorizeResult
+		rulesToRevoke, rulesToRevokeResult = s.EgressRulesToRevoke, s.EgressRulesToRevokeResult
+		rulesToUpdate, rulesToUpdateResult = s.EgressRulesToUpdate, s.EgressRulesToUpdateResult
 	}
 
-	return securityGroupDeltaTable.Render()
+	remediation := tablesCell()
+	remediationResult := textCell()
+
+	if rulesToModify := s.rulesToModify(direction); len(rulesToModify) > 0 {
+		remediation.Tables = append(remediation.Tables, newRuleModificationsTable(rulesToModify, *s.AsIsSecurityGroup, directionTitle+" rules to modify"))
+		remediationResult.Lines = append(remediationResult.Lines, s.RulesToModifyResult)
+	}
+
+	if len(rulesToRevoke) > 0 {
+		remediation.Tables = append(remediation.Tables, newRulesTable(rulesToRevoke, *s.AsIsSecurityGroup, directionTitle+" rules to revoke"))
+		remediationResult.Lines = append(remediationResult.Lines, rulesToRevokeResult)
+	}
+	if len(rulesToAuthorize) > 0 {
+		remediation.Tables = append(remediation.Tables, newRulesTable(rulesToAuthorize, *s.AsIsSecurityGroup, directionTitle+" rules to authorize"))
+		remediationResult.Lines = append(remediationResult.Lines, rulesToAuthorizeResult)
+	}
+	if len(rulesToUpdate) > 0 {
+		remediation.Tables = append(remediation.Tables, newRulesTable(rulesToUpdate, *s.AsIsSecurityGroup, directionTitle+" rules to update"))
+		remediationResult.Lines = append(remediationResult.Lines, rulesToUpdateResult)
+	}
+
+	return ReportRow{
+		Cells: []ReportCell{
+			tablesCell(newRulesTable(s.asIsRules(direction), *s.AsIsSecurityGroup, directionTitle+" rules")),
+			tablesCell(newIpPermissionsTable(ipPermissions, *s.ToBeSecurityGroup, directionTitle+" rules")),
+			remediation,
+			remediationResult,
+		},
+		Title: directionTitle + " rules",
+	}
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+const noColorEnvironmentVariableName = "NO_COLOR"
+
+const (
+	addedDiffMarker   = "+"
+	failedDiffMarker  = "!"
+	removedDiffMarker = "-"
+	updatedDiffMarker = "~"
+)
+
+var diffMarkerColors = map[string]text.Color{
+	addedDiffMarker:   text.FgGreen,
+	failedDiffMarker:  text.FgRed,
+	removedDiffMarker: text.FgRed,
+	updatedDiffMarker: text.FgYellow,
+}
+
+// DiffRenderer renders only the rules and tags to authorize, revoke or update, one per line prefixed with +, - or ~,
+// followed by failed remediations and violations prefixed with !
+type DiffRenderer struct {
+	Color bool
+}
+
+func NewDiffRenderer(color bool) *DiffRenderer {
+	diffRenderer := new(DiffRenderer)
+
+	diffRenderer.Color = color
+
+	return diffRenderer
+}
+
+func (d *DiffRenderer) Render(securityGroupDelta *SecurityGroupDelta) string {
+	header := fmt.Sprintf("%s [%s]", securityGroupDelta.title(), securityGroupDelta.status())
+	if d.Color {
+		header = text.Bold.Sprint(header)
+	}
+
+	lines := []string{header}
+	for _, diffLine := range diffSecurityGroupDelta(securityGroupDelta) {
+		line := diffLine[0] + " " + diffLine[1]
+		if d.Color {
+			line = diffMarkerColors[diffLine[0]].Sprint(line)
+		}
+
+		lines = append(lines, "  "+line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// diffSecurityGroupDelta returns the marker and the text of every line of the diff of the delta
+func diffSecurityGroupDelta(securityGroupDelta *SecurityGroupDelta) [][2]string {
+	diffLines := make([][2]string, 0)
+
+	if securityGroupDelta.AsIsSecurityGroup == nil {
+		return append(diffLines, [2]string{failedDiffMarker, fmt.Sprintf("No matching security group found with ID: %s in VPC: %s",
+			aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupId), aws.ToString(securityGroupDelta.ToBeSecurityGroup.VpcId))})
+	}
+
+	for _, rules := range []struct {
+		marker string
+		rules  []Rule
+	}{
+		{removedDiffMarker, securityGroupDelta.IngressRulesToRevoke},
+		{addedDiffMarker, securityGroupDelta.IngressRulesToAuthorize},
+		{updatedDiffMarker, securityGroupDelta.IngressRulesToUpdate},
+		{removedDiffMarker, securityGroupDelta.EgressRulesToRevoke},
+		{addedDiffMarker, securityGroupDelta.EgressRulesToAuthorize},
+		{updatedDiffMarker, securityGroupDelta.EgressRulesToUpdate},
+	} {
+		sortedRules := make([]Rule, len(rules.rules))
+		copy(sortedRules, rules.rules)
+		sortRules(sortedRules)
+
+		for _, rule := range sortedRules {
+			summary := summarizeRule(rule)
+			if rule.RuleId != "" {
+				summary += " [" + rule.RuleId + "]"
+			}
+
+			diffLines = append(diffLines, [2]string{rules.marker, summary})
+		}
+	}
+
+	for _, ruleModification := range securityGroupDelta.RulesToModify {
+		diffLines = append(diffLines, [2]string{updatedDiffMarker, summarizeRuleModification(ruleModification)})
+	}
+
+	diffLines = append(diffLines, diffTags(securityGroupDelta.TagsToDelete, securityGroupDelta.TagsToCreate)...)
+
+	for _, result := range securityGroupDelta.results() {
+		if strings.HasPrefix(result, "Failed") {
+			diffLines = append(diffLines, [2]string{failedDiffMarker, result})
+		}
+	}
+
+	for _, quotaViolation := range securityGroupDelta.QuotaViolations {
+		diffLines = append(diffLines, [2]string{failedDiffMarker, "Refused by quota pre-flight check: " + quotaViolation})
+	}
+
+	for _, policyViolation := range securityGroupDelta.PolicyViolations {
+		diffLines = append(diffLines, [2]string{failedDiffMarker, policyViolation.String()})
+	}
+
+	for _, guardViolation := range securityGroupDelta.GuardViolations {
+		diffLines = append(diffLines, [2]string{failedDiffMarker, "Skipped by blast-radius guard: " + guardViolation})
+	}
+
+	return diffLines
+}
+
+// diffTags pairs the tags to delete and create sharing a key into a single updated tag
+func diffTags(tagsToDelete []types.Tag, tagsToCreate []types.Tag) [][2]string {
+	diffLines := make([][2]string, 0, len(tagsToDelete)+len(tagsToCreate))
+
+	createdValues := make(map[string]string, len(tagsToCreate))
+	for _, tag := range tagsToCreate {
+		createdValues[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	deletedValues := make(map[string]string, len(tagsToDelete))
+	for _, tag := range sortTags(tagsToDelete) {
+		key := aws.ToString(tag.Key)
+		deletedValues[key] = aws.ToString(tag.Value)
+
+		if createdValue, ok := createdValues[key]; ok {
+			diffLines = append(diffLines, [2]string{updatedDiffMarker, fmt.Sprintf("tag %s=%s -> %s", key, aws.ToString(tag.Value), createdValue)})
+		} else {
+			diffLines = append(diffLines, [2]string{removedDiffMarker, fmt.Sprintf("tag %s=%s", key, aws.ToString(tag.Value))})
+		}
+	}
+
+	for _, tag := range sortTags(tagsToCreate) {
+		if _, ok := deletedValues[aws.ToString(tag.Key)]; !ok {
+			diffLines = append(diffLines, [2]string{addedDiffMarker, fmt.Sprintf("tag %s=%s", aws.ToString(tag.Key), aws.ToString(tag.Value))})
+		}
+	}
+
+	return diffLines
+}
+
+// summarizeRuleModification returns the rule before the modification followed by what the modification changes, such
+// as "inbound TCP 22 from 10.0.0.1/32 [sgr-1]: description "" -> "Office""
+func summarizeRuleModification(ruleModification RuleModification) string {
+	rule := ruleModification.From
+	rule.Description = ""
+
+	changes := make([]string, 0, 2)
+	if ruleModification.From.Source != ruleModification.To.Source {
+		changes = append(changes, fmt.Sprintf("source %s -> %s", ruleModification.From.Source, ruleModification.To.Source))
+	}
+	if ruleModification.From.Description != ruleModification.To.Description {
+		changes = append(changes, fmt.Sprintf("description %q -> %q", ruleModification.From.Description, ruleModification.To.Description))
+	}
+
+	return fmt.Sprintf("%s [%s]: %s", summarizeRule(rule), ruleModification.RuleId, strings.Join(changes, ", "))
+}
+
+// isColorTerminal returns true when f is a terminal and colors weren't turned off with NO_COLOR
+func isColorTerminal(f *os.File) bool {
+	if _, ok := os.LookupEnv(noColorEnvironmentVariableName); ok {
+		return false
+	}
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fileInfo.Mode()&os.ModeCharDevice != 0
+}
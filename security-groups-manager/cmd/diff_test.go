@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffRenderer(t *testing.T) {
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-1"), GroupName: aws.String("web"), VpcId: aws.String("vpc-1")})
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1")}
+	securityGroupDelta.RegionName = "eu-west-1"
+	securityGroupDelta.IngressRulesToAuthorize = []Rule{
+		{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: ipv4CidrSourceKind, Source: "192.0.2.2/32", Description: "Office"},
+		{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: ipv4CidrSourceKind, Source: "192.0.2.1/32"},
+	}
+	securityGroupDelta.IngressRulesToRevoke = []Rule{{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 22, ToPort: 22, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.1/32", RuleId: "sgr-1"}}
+	securityGroupDelta.EgressRulesToUpdate = []Rule{{Direction: egressDirection, IpProtocol: "-1", FromPort: -1, ToPort: -1, SourceKind: ipv4CidrSourceKind, Source: "0.0.0.0/0", Description: "All"}}
+	securityGroupDelta.EgressRulesToRevokeResult = ""
+	securityGroupDelta.IngressRulesToRevokeResult = "Failed to revoke inbound rules: throttled"
+	securityGroupDelta.RulesToModify = []RuleModification{{
+		From:   Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 80, ToPort: 80, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.2/32", Description: "Old"},
+		RuleId: "sgr-2",
+		To:     Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 80, ToPort: 80, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.3/32", Description: "New"},
+	}}
+	securityGroupDelta.TagsToCreate = []types.Tag{{Key: aws.String("Team"), Value: aws.String("platform")}, {Key: aws.String("Env"), Value: aws.String("prod")}}
+	securityGroupDelta.TagsToDelete = []types.Tag{{Key: aws.String("Team"), Value: aws.String("web")}, {Key: aws.String("Old"), Value: aws.String("x")}}
+	securityGroupDelta.PolicyViolations = []PolicyViolation{{Rule: "no-ssh", Severity: warningSeverity, Message: "SSH open"}}
+
+	assert.Equal(t, `web (sg-1, eu-west-1) [changed]
+  - inbound TCP 22 from 10.0.0.1/32 [sgr-1]
+  + inbound TCP 443 from 192.0.2.1/32
+  + inbound TCP 443 from 192.0.2.2/32 (Office)
+  ~ outbound All All to 0.0.0.0/0 (All)
+  ~ inbound TCP 80 from 10.0.0.2/32 [sgr-2]: source 10.0.0.2/32 -> 10.0.0.3/32, description "Old" -> "New"
+  - tag Old=x
+  ~ tag Team=web -> platform
+  + tag Env=prod
+  ! Failed to revoke inbound rules: throttled
+  ! [warning] no-ssh: SSH open`, NewDiffRenderer(false).Render(securityGroupDelta))
+
+	colored := NewDiffRenderer(true).Render(securityGroupDelta)
+	assert.Contains(t, colored, text.Bold.Sprint("web (sg-1, eu-west-1) [changed]"))
+	assert.Contains(t, colored, "  "+text.FgGreen.Sprint("+ tag Env=prod"))
+
+	notFoundSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-2"), GroupName: aws.String("db"), VpcId: aws.String("vpc-1")})
+	assert.Equal(t, "db (sg-2) [not-found]\n  ! No matching security group found with ID: sg-2 in VPC: vpc-1", NewDiffRenderer(false).Render(notFoundSecurityGroupDelta))
+}
+
+func TestIsColorTerminal(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "report"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	assert.False(t, isColorTerminal(f), "A file isn't a terminal")
+}
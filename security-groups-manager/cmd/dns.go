@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	dnsResponseLength  = 1232
+	dnsTimeout         = 2 * time.Second
+	dnsResolvConfPath  = "/etc/resolv.conf"
+	dnsFallbackAddress = "127.0.0.1"
+	dnsDefaultNdots    = 1
+)
+
+// The TTL used when no nameserver answers with one
+const dnsFallbackTTL = time.Minute
+
+var errDNSTruncated = fmt.Errorf("truncated DNS response")
+
+// lookupHost resolves an FQDN with the system resolver, which honours the search domains and ndots of
+// /etc/resolv.conf and retries truncated answers over TCP. Reconciliations and the host watcher both resolve hosts with
+// it, so that they always agree on the addresses of a host
+var lookupHost = net.LookupHost
+
+// resolvConf holds the settings of /etc/resolv.conf used to look up TTLs. Nameservers are addresses including the port
+type resolvConf struct {
+	Ndots       int
+	Nameservers []string
+	Search      []string
+}
+
+// lookupHostWithTTL resolves an FQDN with lookupHost and returns its addresses with the lowest TTL of the A and AAAA
+// answers, CNAMEs included, of the nameservers in /etc/resolv.conf. The TTL only schedules the next lookup, when no
+// nameserver answers with one dnsFallbackTTL is used
+func lookupHostWithTTL(fqdn string) ([]string, time.Duration, error) {
+	addresses, err := lookupHost(fqdn)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return addresses, lookupTTL(fqdn), nil
+}
+
+// lookupTTL queries the names lookupHost would try for fqdn in the same order, returning the TTL of the first that has
+// addresses
+func lookupTTL(fqdn string) time.Duration {
+	resolvConf := readResolvConf(dnsResolvConfPath)
+
+	for _, name := range resolvConf.queryNames(fqdn) {
+		for _, nameserver := range resolvConf.Nameservers {
+			addresses, ttl, err := queryNameserver(nameserver, name)
+			if err != nil {
+				logger.Debugf("Unable to query nameserver %s for the TTL of %s: %v", nameserver, name, err)
+
+				continue
+			}
+
+			if len(addresses) > 0 {
+				return ttl
+			}
+
+			break
+		}
+	}
+
+	return dnsFallbackTTL
+}
+
+func readResolvConf(path string) resolvConf {
+	resolvConf := resolvConf{
+		Ndots:       dnsDefaultNdots,
+		Nameservers: make([]string, 0),
+		Search:      make([]string, 0),
+	}
+
+	file, err := os.Open(path)
+	if err == nil {
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+
+			switch fields[0] {
+			case "nameserver":
+				resolvConf.Nameservers = append(resolvConf.Nameservers, net.JoinHostPort(fields[1], "53"))
+			case "domain":
+				resolvConf.Search = []string{fields[1]}
+			case "search":
+				resolvConf.Search = fields[1:]
+			case "options":
+				for _, option := range fields[1:] {
+					if !strings.HasPrefix(option, "ndots:") {
+						continue
+					}
+
+					if ndots, err := strconv.Atoi(strings.TrimPrefix(option, "ndots:")); err == nil {
+						resolvConf.Ndots = ndots
+					}
+				}
+			}
+		}
+	}
+
+	if len(resolvConf.Nameservers) == 0 {
+		resolvConf.Nameservers = []string{net.JoinHostPort(dnsFallbackAddress, "53")}
+	}
+
+	return resolvConf
+}
+
+// queryNames returns the fully qualified names to query for name, in the order the system resolver tries them. A name
+// with at least ndots dots is tried as is before being appended to the search domains
+func (r resolvConf) queryNames(name string) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+
+	names := make([]string, 0, len(r.Search)+1)
+	for _, search := range r.Search {
+		names = append(names, name+"."+strings.TrimSuffix(search, ".")+".")
+	}
+
+	if strings.Count(name, ".") >= r.Ndots {
+		return append([]string{name + "."}, names...)
+	}
+
+	return append(names, name+".")
+}
+
+func queryNameserver(nameserver string, name string) ([]string, time.Duration, error) {
+	addresses := make([]string, 0)
+	var ttl uint32
+	answered := false
+
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		typeAddresses, typeTTL, err := queryNameserverType(nameserver, name, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if typeTTL != nil && (!answered || *typeTTL < ttl) {
+			ttl = *typeTTL
+			answered = true
+		}
+
+		addresses = append(addresses, typeAddresses...)
+	}
+
+	return addresses, time.Duration(ttl) * time.Second, nil
+}
+
+// queryNameserverType queries over UDP, then over TCP when the answer didn't fit in a UDP response
+func queryNameserverType(nameserver string, name string, qtype dnsmessage.Type) ([]string, *uint32, error) {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		return nil, nil, err
+	}
+	id := binary.BigEndian.Uint16(b)
+
+	query, err := buildDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := exchangeDNSQuery("udp", nameserver, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addresses, ttl, err := parseDNSResponse(response, id, qtype)
+	if err != errDNSTruncated {
+		return addresses, ttl, err
+	}
+
+	response, err = exchangeDNSQuery("tcp", nameserver, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parseDNSResponse(response, id, qtype)
+}
+
+// exchangeDNSQuery sends query to nameserver and returns its response. Over TCP messages are prefixed with their length
+func exchangeDNSQuery(network string, nameserver string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, nameserver, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(dnsTimeout)); err != nil {
+		return nil, err
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+
+		response := make([]byte, dnsResponseLength)
+		n, err := conn.Read(response)
+		if err != nil {
+			return nil, err
+		}
+
+		return response[:n], nil
+	}
+
+	if _, err := conn.Write(append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)); err != nil {
+		return nil, err
+	}
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// buildDNSQuery returns a recursive query for the records of type qtype of the fully qualified name
+func buildDNSQuery(id uint16, name string, qtype dnsmessage.Type) ([]byte, error) {
+	questionName, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	message := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               id,
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{
+			{
+				Class: dnsmessage.ClassINET,
+				Name:  questionName,
+				Type:  qtype,
+			},
+		},
+	}
+
+	return message.Pack()
+}
+
+// parseDNSResponse returns the addresses of type qtype found in the answer section and the lowest TTL of the answers,
+// or nil when there's no answer. A name that doesn't exist has no addresses
+func parseDNSResponse(response []byte, id uint16, qtype dnsmessage.Type) ([]string, *uint32, error) {
+	var parser dnsmessage.Parser
+
+	header, err := parser.Start(response)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if header.ID != id {
+		return nil, nil, fmt.Errorf("DNS response ID mismatch")
+	}
+	if !header.Response {
+		return nil, nil, fmt.Errorf("DNS response is a query")
+	}
+	if header.Truncated {
+		return nil, nil, errDNSTruncated
+	}
+
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return []string{}, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("DNS response code %d", header.RCode)
+	}
+
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, nil, err
+	}
+
+	addresses := make([]string, 0)
+	var ttl *uint32
+
+	for {
+		answerHeader, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case answerHeader.Type == qtype && qtype == dnsmessage.TypeA:
+			resource, err := parser.AResource()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			addresses = append(addresses, net.IP(resource.A[:]).String())
+		case answerHeader.Type == qtype && qtype == dnsmessage.TypeAAAA:
+			resource, err := parser.AAAAResource()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			addresses = append(addresses, net.IP(resource.AAAA[:]).String())
+		default:
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, nil, err
+			}
+
+			if answerHeader.Type != dnsmessage.TypeCNAME {
+				continue
+			}
+		}
+
+		if ttl == nil || answerHeader.TTL < *ttl {
+			answerTTL := answerHeader.TTL
+			ttl = &answerTTL
+		}
+	}
+
+	return addresses, ttl, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// answerTestDNSQuery answers query with answers for the queried name
+func answerTestDNSQuery(query []byte, header dnsmessage.Header, answers []dnsmessage.Resource) ([]byte, error) {
+	var message dnsmessage.Message
+	if err := message.Unpack(query); err != nil {
+		return nil, err
+	}
+
+	header.ID = message.Header.ID
+	header.Response = true
+	message.Header = header
+
+	for i := range answers {
+		answers[i].Header.Class = dnsmessage.ClassINET
+		answers[i].Header.Name = message.Questions[0].Name
+	}
+	message.Answers = answers
+
+	return message.Pack()
+}
+
+func buildTestDNSResponse(t *testing.T, query []byte, header dnsmessage.Header, answers []dnsmessage.Resource) []byte {
+	response, err := answerTestDNSQuery(query, header, answers)
+	require.NoError(t, err)
+
+	return response
+}
+
+func TestBuildDNSQuery(t *testing.T) {
+	query, err := buildDNSQuery(0x1234, "my.host.", dnsmessage.TypeAAAA)
+	assert.NoError(t, err)
+
+	var message dnsmessage.Message
+	if assert.NoError(t, message.Unpack(query)) {
+		assert.Equal(t, dnsmessage.Header{ID: 0x1234, RecursionDesired: true}, message.Header)
+		assert.Equal(t, []dnsmessage.Question{{Name: dnsmessage.MustNewName("my.host."), Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET}}, message.Questions)
+	}
+
+	_, err = buildDNSQuery(0x1234, "my..host.", dnsmessage.TypeA)
+	assert.Error(t, err)
+}
+
+func TestParseDNSResponse(t *testing.T) {
+	query, err := buildDNSQuery(0x1234, "my.host.", dnsmessage.TypeA)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		response      []byte
+		wantAddresses []string
+		wantTTL       *uint32
+		wantErr       bool
+	}{
+		{
+			name: "CNAME",
+			response: buildTestDNSResponse(t, query, dnsmessage.Header{RecursionAvailable: true}, []dnsmessage.Resource{
+				{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeCNAME, TTL: 300}, Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("other.host.")}},
+				{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, TTL: 60}, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+				{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, TTL: 120}, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}},
+				{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeTXT, TTL: 30}, Body: &dnsmessage.TXTResource{TXT: []string{"ignored"}}},
+			}),
+			wantAddresses: []string{"192.0.2.1", "192.0.2.2"},
+			wantTTL:       uint32Pointer(60),
+		},
+		{
+			name:          "No answer",
+			response:      buildTestDNSResponse(t, query, dnsmessage.Header{}, nil),
+			wantAddresses: []string{},
+		},
+		{
+			name:          "NXDOMAIN",
+			response:      buildTestDNSResponse(t, query, dnsmessage.Header{RCode: dnsmessage.RCodeNameError}, nil),
+			wantAddresses: []string{},
+		},
+		{
+			name:     "SERVFAIL",
+			response: buildTestDNSResponse(t, query, dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}, nil),
+			wantErr:  true,
+		},
+		{
+			name:     "Truncated",
+			response: buildTestDNSResponse(t, query, dnsmessage.Header{Truncated: true}, nil),
+			wantErr:  true,
+		},
+		{
+			name:     "ID mismatch",
+			response: append([]byte{0x43, 0x21}, buildTestDNSResponse(t, query, dnsmessage.Header{}, nil)[2:]...),
+			wantErr:  true,
+		},
+		{
+			name:     "Query",
+			response: query,
+			wantErr:  true,
+		},
+		{
+			name: "Answer out of bounds",
+			response: func() []byte {
+				response := buildTestDNSResponse(t, query, dnsmessage.Header{}, []dnsmessage.Resource{
+					{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, TTL: 60}, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+				})
+
+				return response[:len(response)-2]
+			}(),
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addresses, ttl, err := parseDNSResponse(test.response, 0x1234, dnsmessage.TypeA)
+			if test.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.wantAddresses, addresses)
+			assert.Equal(t, test.wantTTL, ttl)
+		})
+	}
+}
+
+func TestQueryNameserverRetriesTruncatedResponsesOverTCP(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcpListener.Close()
+
+	udpConn, err := net.ListenPacket("udp", tcpListener.Addr().String())
+	require.NoError(t, err)
+	defer udpConn.Close()
+
+	// Every UDP response is truncated
+	go func() {
+		query := make([]byte, 512)
+		for {
+			n, address, err := udpConn.ReadFrom(query)
+			if err != nil {
+				return
+			}
+
+			if response, err := answerTestDNSQuery(query[:n], dnsmessage.Header{Truncated: true}, nil); err == nil {
+				udpConn.WriteTo(response, address)
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := tcpListener.Accept()
+			if err != nil {
+				return
+			}
+
+			length := make([]byte, 2)
+			if _, err := io.ReadFull(conn, length); err != nil {
+				conn.Close()
+
+				continue
+			}
+
+			query := make([]byte, binary.BigEndian.Uint16(length))
+			if _, err := io.ReadFull(conn, query); err != nil {
+				conn.Close()
+
+				continue
+			}
+
+			var message dnsmessage.Message
+			if err := message.Unpack(query); err != nil {
+				conn.Close()
+
+				continue
+			}
+
+			var answers []dnsmessage.Resource
+			if message.Questions[0].Type == dnsmessage.TypeA {
+				answers = []dnsmessage.Resource{
+					{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, TTL: 90}, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+				}
+			}
+
+			if response, err := answerTestDNSQuery(query, dnsmessage.Header{}, answers); err == nil {
+				conn.Write(append([]byte{byte(len(response) >> 8), byte(len(response))}, response...))
+			}
+			conn.Close()
+		}
+	}()
+
+	addresses, ttl, err := queryNameserver(tcpListener.Addr().String(), "my.host.")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.0.2.1"}, addresses)
+	assert.Equal(t, 90*time.Second, ttl)
+}
+
+func TestReadResolvConf(t *testing.T) {
+	resolvConfPath := filepath.Join(t.TempDir(), "resolv.conf")
+	assert.NoError(t, os.WriteFile(resolvConfPath, []byte("# Generated\nsearch ec2.internal corp.example.\nnameserver 10.0.0.2\nnameserver fd00::2\noptions timeout:2 ndots:2\n"), 0600))
+
+	assert.Equal(t, resolvConf{
+		Ndots:       2,
+		Nameservers: []string{"10.0.0.2:53", "[fd00::2]:53"},
+		Search:      []string{"ec2.internal", "corp.example."},
+	}, readResolvConf(resolvConfPath))
+
+	assert.Equal(t, resolvConf{
+		Ndots:       dnsDefaultNdots,
+		Nameservers: []string{"127.0.0.1:53"},
+		Search:      []string{},
+	}, readResolvConf(filepath.Join(t.TempDir(), "missing")))
+}
+
+func TestResolvConfQueryNames(t *testing.T) {
+	resolvConf := resolvConf{Ndots: 1, Search: []string{"ec2.internal", "corp.example."}}
+
+	assert.Equal(t, []string{"bastion.ec2.internal.", "bastion.corp.example.", "bastion."}, resolvConf.queryNames("bastion"), "Relative names are tried with the search domains first")
+	assert.Equal(t, []string{"my.host.", "my.host.ec2.internal.", "my.host.corp.example."}, resolvConf.queryNames("my.host"), "Names with ndots dots are tried as is first")
+	assert.Equal(t, []string{"my.host."}, resolvConf.queryNames("my.host."), "Fully qualified names are only tried as is")
+
+	resolvConf.Ndots = 2
+	assert.Equal(t, []string{"my.host.ec2.internal.", "my.host.corp.example.", "my.host."}, resolvConf.queryNames("my.host"))
+}
+
+func uint32Pointer(value uint32) *uint32 {
+	return &value
+}
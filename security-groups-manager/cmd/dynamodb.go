@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	appliedStatePartitionKeyPrefix   = "applied-state#"
+	hostResolutionPartitionKeyPrefix = "host-resolution#"
+	notificationPartitionKeyPrefix   = "notification#"
+	latestSortKey                    = "latest"
+	runOutcomePartitionKey           = "run-outcome"
+)
+
+// History items expire after dynamoDBHistoryRetention when TTL is enabled on the table's ExpiresAt attribute
+const dynamoDBHistoryRetention = 30 * 24 * time.Hour
+
+const dynamoDBRequestTimeout = 10 * time.Second
+
+// DynamoDB rejects items larger than 400 KB. The data of an item is kept below this to leave room for its other
+// attributes
+const maximumDynamoDBItemDataSize = 350 * 1024
+
+type dynamoDBItem struct {
+	Data         string
+	ExpiresAt    int64
+	PartitionKey string
+	SortKey      string
+}
+
+// dynamoDBClient is the subset of the DynamoDB API used by DynamoDBStateStore. Query returns the items of a partition
+// in descending sort key order
+type dynamoDBClient interface {
+	GetItem(tableName string, partitionKey string, sortKey string) (*dynamoDBItem, error)
+	PutItem(tableName string, item dynamoDBItem) error
+	Query(tableName string, partitionKey string, limit int) ([]dynamoDBItem, error)
+}
+
+// DynamoDBStateStore stores the state in a DynamoDB table with a string partition key named PK and a string sort key
+// named SK. History items are sorted by the time they were recorded
+type DynamoDBStateStore struct {
+	Client    dynamoDBClient
+	TableName string
+}
+
+func NewDynamoDBStateStore(client dynamoDBClient, tableName string) *DynamoDBStateStore {
+	dynamoDBStateStore := new(DynamoDBStateStore)
+
+	dynamoDBStateStore.Client = client
+	dynamoDBStateStore.TableName = tableName
+
+	return dynamoDBStateStore
+}
+
+func (d *DynamoDBStateStore) GetAppliedState(groupId string) (*AppliedState, error) {
+	item, err := d.Client.GetItem(d.TableName, appliedStatePartitionKeyPrefix+groupId, latestSortKey)
+	if err != nil || item == nil {
+		return nil, err
+	}
+
+	var appliedState AppliedState
+	if err := json.Unmarshal([]byte(item.Data), &appliedState); err != nil {
+		return nil, err
+	}
+
+	return &appliedState, nil
+}
+
+func (d *DynamoDBStateStore) GetNotificationRecord(fingerprint string) (*NotificationRecord, error) {
+	item, err := d.Client.GetItem(d.TableName, notificationPartitionKeyPrefix+fingerprint, latestSortKey)
+	if err != nil || item == nil {
+		return nil, err
+	}
+
+	var notificationRecord NotificationRecord
+	if err := json.Unmarshal([]byte(item.Data), &notificationRecord); err != nil {
+		return nil, err
+	}
+
+	return &notificationRecord, nil
+}
+
+func (d *DynamoDBStateStore) ListHostResolutions(host string) ([]HostResolution, error) {
+	items, err := d.Client.Query(d.TableName, hostResolutionPartitionKeyPrefix+host, maximumHostResolutions)
+	if err != nil {
+		return nil, err
+	}
+
+	hostResolutions := make([]HostResolution, len(items))
+	for i, item := range items {
+		if err := json.Unmarshal([]byte(item.Data), &hostResolutions[len(items)-1-i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return hostResolutions, nil
+}
+
+func (d *DynamoDBStateStore) ListRunOutcomes() ([]RunOutcome, error) {
+	items, err := d.Client.Query(d.TableName, runOutcomePartitionKey, maximumRunOutcomes)
+	if err != nil {
+		return nil, err
+	}
+
+	runOutcomes := make([]RunOutcome, len(items))
+	for i, item := range items {
+		if err := json.Unmarshal([]byte(item.Data), &runOutcomes[len(items)-1-i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return runOutcomes, nil
+}
+
+func (d *DynamoDBStateStore) PutAppliedState(appliedState AppliedState) error {
+	return d.put(appliedStatePartitionKeyPrefix+appliedState.GroupId, latestSortKey, appliedState, 0)
+}
+
+func (d *DynamoDBStateStore) PutHostResolution(hostResolution HostResolution) error {
+	return d.put(hostResolutionPartitionKeyPrefix+hostResolution.Host, historySortKey(hostResolution.ResolvedAt), hostResolution,
+		hostResolution.ResolvedAt.Add(dynamoDBHistoryRetention).Unix())
+}
+
+func (d *DynamoDBStateStore) PutNotificationRecord(notificationRecord NotificationRecord) error {
+	return d.put(notificationPartitionKeyPrefix+notificationRecord.Fingerprint, latestSortKey, notificationRecord,
+		notificationRecord.NotifiedAt.Add(notificationRecordRetention).Unix())
+}
+
+func (d *DynamoDBStateStore) PutRunOutcome(runOutcome RunOutcome) error {
+	return d.put(runOutcomePartitionKey, historySortKey(runOutcome.StartedAt), truncateRunOutcome(runOutcome, maximumDynamoDBItemDataSize),
+		runOutcome.StartedAt.Add(dynamoDBHistoryRetention).Unix())
+}
+
+func (d *DynamoDBStateStore) put(partitionKey string, sortKey string, v interface{}, expiresAt int64) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return d.Client.PutItem(d.TableName, dynamoDBItem{
+		Data:         string(b),
+		ExpiresAt:    expiresAt,
+		PartitionKey: partitionKey,
+		SortKey:      sortKey,
+	})
+}
+
+// truncateRunOutcome drops the outcomes of the last security groups until the run outcome marshals to at most
+// maximumSize bytes, counting them as omitted
+func truncateRunOutcome(runOutcome RunOutcome, maximumSize int) RunOutcome {
+	b, err := json.Marshal(runOutcome)
+	if err != nil || len(b) <= maximumSize {
+		return runOutcome
+	}
+
+	size := len(b)
+	securityGroups := runOutcome.SecurityGroups
+	for len(securityGroups) > 0 && size > maximumSize {
+		securityGroupOutcome, _ := json.Marshal(securityGroups[len(securityGroups)-1])
+		size -= len(securityGroupOutcome) + len(",")
+		securityGroups = securityGroups[:len(securityGroups)-1]
+	}
+
+	runOutcome.OmittedSecurityGroups += len(runOutcome.SecurityGroups) - len(securityGroups)
+	runOutcome.SecurityGroups = securityGroups
+
+	return runOutcome
+}
+
+// historySortKey formats t so that sort keys order chronologically
+func historySortKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// sdkDynamoDBClient adapts the DynamoDB client of the AWS SDK to dynamoDBClient
+type sdkDynamoDBClient struct {
+	Client *dynamodb.Client
+}
+
+func newSdkDynamoDBClient(client *dynamodb.Client) *sdkDynamoDBClient {
+	sdkDynamoDBClient := new(sdkDynamoDBClient)
+
+	sdkDynamoDBClient.Client = client
+
+	return sdkDynamoDBClient
+}
+
+func (s *sdkDynamoDBClient) GetItem(tableName string, partitionKey string, sortKey string) (*dynamoDBItem, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), dynamoDBRequestTimeout)
+	defer cancel()
+
+	getItemOutput, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: partitionKey},
+			"SK": &types.AttributeValueMemberS{Value: sortKey},
+		},
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if getItemOutput.Item == nil {
+		return nil, nil
+	}
+
+	item := unmarshalDynamoDBItem(getItemOutput.Item)
+
+	return &item, nil
+}
+
+func (s *sdkDynamoDBClient) PutItem(tableName string, item dynamoDBItem) error {
+	ctx, cancel := context.WithTimeout(context.TODO(), dynamoDBRequestTimeout)
+	defer cancel()
+
+	attributeValues := map[string]types.AttributeValue{
+		"Data": &types.AttributeValueMemberS{Value: item.Data},
+		"PK":   &types.AttributeValueMemberS{Value: item.PartitionKey},
+		"SK":   &types.AttributeValueMemberS{Value: item.SortKey},
+	}
+	if item.ExpiresAt > 0 {
+		attributeValues["ExpiresAt"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(item.ExpiresAt, 10)}
+	}
+
+	_, err := s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:      attributeValues,
+		TableName: aws.String(tableName),
+	})
+
+	return err
+}
+
+func (s *sdkDynamoDBClient) Query(tableName string, partitionKey string, limit int) ([]dynamoDBItem, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), dynamoDBRequestTimeout)
+	defer cancel()
+
+	queryOutput, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: partitionKey},
+		},
+		KeyConditionExpression: aws.String("PK = :pk"),
+		Limit:                  aws.Int32(int32(limit)),
+		ScanIndexForward:       aws.Bool(false),
+		TableName:              aws.String(tableName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]dynamoDBItem, 0, len(queryOutput.Items))
+	for _, attributeValues := range queryOutput.Items {
+		items = append(items, unmarshalDynamoDBItem(attributeValues))
+	}
+
+	return items, nil
+}
+
+func unmarshalDynamoDBItem(attributeValues map[string]types.AttributeValue) dynamoDBItem {
+	var item dynamoDBItem
+
+	if data, ok := attributeValues["Data"].(*types.AttributeValueMemberS); ok {
+		item.Data = data.Value
+	}
+	if partitionKey, ok := attributeValues["PK"].(*types.AttributeValueMemberS); ok {
+		item.PartitionKey = partitionKey.Value
+	}
+	if sortKey, ok := attributeValues["SK"].(*types.AttributeValueMemberS); ok {
+		item.SortKey = sortKey.Value
+	}
+	if expiresAt, ok := attributeValues["ExpiresAt"].(*types.AttributeValueMemberN); ok {
+		item.ExpiresAt, _ = strconv.ParseInt(expiresAt.Value, 10, 64)
+	}
+
+	return item
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryDynamoDBClient struct {
+	Items map[string]map[string]dynamoDBItem
+}
+
+func newMemoryDynamoDBClient() *memoryDynamoDBClient {
+	return &memoryDynamoDBClient{Items: make(map[string]map[string]dynamoDBItem)}
+}
+
+func (m *memoryDynamoDBClient) GetItem(tableName string, partitionKey string, sortKey string) (*dynamoDBItem, error) {
+	item, ok := m.Items[partitionKey][sortKey]
+	if !ok {
+		return nil, nil
+	}
+
+	return &item, nil
+}
+
+func (m *memoryDynamoDBClient) PutItem(tableName string, item dynamoDBItem) error {
+	if m.Items[item.PartitionKey] == nil {
+		m.Items[item.PartitionKey] = make(map[string]dynamoDBItem)
+	}
+	m.Items[item.PartitionKey][item.SortKey] = item
+
+	return nil
+}
+
+func (m *memoryDynamoDBClient) Query(tableName string, partitionKey string, limit int) ([]dynamoDBItem, error) {
+	items := make([]dynamoDBItem, 0)
+	for _, item := range m.Items[partitionKey] {
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].SortKey > items[j].SortKey
+	})
+
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items, nil
+}
+
+func TestDynamoDBStateStore(t *testing.T) {
+	testStateStore(t, NewDynamoDBStateStore(newMemoryDynamoDBClient(), "state"))
+}
+
+func TestDynamoDBStateStoreTruncatesLargeRunOutcomes(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	securityGroupOutcomes := make([]SecurityGroupOutcome, 1000)
+	for i := range securityGroupOutcomes {
+		securityGroupOutcomes[i] = SecurityGroupOutcome{
+			GroupId: fmt.Sprintf("sg-%d", i),
+			Results: []string{strings.Repeat("Failed to authorize ingress rules: ", 20)},
+			Status:  changedStatus,
+		}
+	}
+
+	client := newMemoryDynamoDBClient()
+	stateStore := NewDynamoDBStateStore(client, "state")
+
+	assert.NoError(t, stateStore.PutRunOutcome(RunOutcome{Command: applyCommand, FinishedAt: now, SecurityGroups: securityGroupOutcomes, StartedAt: now}))
+
+	items, err := client.Query("state", runOutcomePartitionKey, maximumRunOutcomes)
+	assert.NoError(t, err)
+	if assert.Len(t, items, 1) {
+		assert.LessOrEqual(t, len(items[0].Data), maximumDynamoDBItemDataSize)
+	}
+
+	runOutcomes, err := stateStore.ListRunOutcomes()
+	assert.NoError(t, err)
+	if assert.Len(t, runOutcomes, 1) {
+		assert.Greater(t, runOutcomes[0].OmittedSecurityGroups, 0)
+		assert.Equal(t, len(securityGroupOutcomes), len(runOutcomes[0].SecurityGroups)+runOutcomes[0].OmittedSecurityGroups)
+		assert.Equal(t, securityGroupOutcomes[:len(runOutcomes[0].SecurityGroups)], runOutcomes[0].SecurityGroups)
+	}
+}
+
+type staticCredentialsProvider struct{}
+
+func (s staticCredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, nil
+}
+
+func TestSdkDynamoDBClient(t *testing.T) {
+	var targets []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/dynamodb/aws4_request")
+
+		var input map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+		if input["TableName"] != "state" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException", "message": "Requested resource not found"}`))
+
+			return
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "DynamoDB_20120810.GetItem":
+			w.Write([]byte(`{}`))
+		case "DynamoDB_20120810.PutItem":
+			assert.Equal(t, map[string]interface{}{"N": "1630497600"}, input["Item"].(map[string]interface{})["ExpiresAt"])
+
+			w.Write([]byte(`{}`))
+		case "DynamoDB_20120810.Query":
+			assert.Equal(t, false, input["ScanIndexForward"])
+
+			w.Write([]byte(`{"Items": [{"PK": {"S": "run-outcome"}, "SK": {"S": "2021-09-01T12:00:00.000000000Z"}, "Data": {"S": "{}"}, "ExpiresAt": {"N": "1630497600"}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newSdkDynamoDBClient(dynamodb.New(dynamodb.Options{
+		Credentials:                     staticCredentialsProvider{},
+		DisableValidateResponseChecksum: true,
+		EndpointResolver:                dynamodb.EndpointResolverFromURL(server.URL),
+		HTTPClient:                      server.Client(),
+		Region:                          "eu-west-1",
+		Retryer:                         aws.NopRetryer{},
+	}))
+
+	item, err := client.GetItem("state", "applied-state#sg-1", latestSortKey)
+	assert.NoError(t, err)
+	assert.Nil(t, item)
+
+	assert.NoError(t, client.PutItem("state", dynamoDBItem{Data: "{}", ExpiresAt: 1630497600, PartitionKey: runOutcomePartitionKey, SortKey: "2021-09-01T12:00:00.000000000Z"}))
+
+	items, err := client.Query("state", runOutcomePartitionKey, maximumRunOutcomes)
+	assert.NoError(t, err)
+	assert.Equal(t, []dynamoDBItem{{Data: "{}", ExpiresAt: 1630497600, PartitionKey: runOutcomePartitionKey, SortKey: "2021-09-01T12:00:00.000000000Z"}}, items)
+
+	_, err = client.GetItem("missing", "applied-state#sg-1", latestSortKey)
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"DynamoDB_20120810.GetItem", "DynamoDB_20120810.PutItem", "DynamoDB_20120810.Query", "DynamoDB_20120810.GetItem"}, targets)
+}
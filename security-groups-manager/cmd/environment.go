@@ -2,44 +2,94 @@ package main
 
 import (
 	"context"
-	"log"
+	"io/ioutil"
 	"os"
 	"strconv"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
 )
 
 const awsLambdaFunctionNameEnvironmentVariableName = "AWS_LAMBDA_FUNCTION_NAME"
 const configurationEnvironmentVariableName = "CONFIGURATION"
+const configurationPathEnvironmentVariableName = "CONFIGURATION_PATH"
 const debugEnvironmentVariableName = "DEBUG"
+const outputFormatEnvironmentVariableName = "OUTPUT_FORMAT"
+const writeAuditTagsEnvironmentVariableName = "WRITE_AUDIT_TAGS"
+
+const diffOutputFormat = "diff"
+const htmlOutputFormat = "html"
+const jsonOutputFormat = "json"
+const markdownOutputFormat = "markdown"
+const noneOutputFormat = "none"
+const plainOutputFormat = "plain"
+const sarifOutputFormat = "sarif"
+const tableOutputFormat = "table"
 
 type ExecutionEnvironment struct {
-	Client        *ec2.Client
-	Configuration *Configuration
-	DoDebug       bool
-	IsLambda      bool
+	AccessRequestSecret        string
+	AccessRequestToken         string
+	Client                     *ec2.Client
+	Configuration              *Configuration
+	EmitMetrics                bool
+	GrantStore                 GrantStore
+	Guard                      *Guard
+	IsLambda                   bool
+	IsLongRunning              bool
+	LambdaClient               *lambda.Client
+	MetricsNamespace           string
+	NotificationDispatcher     *NotificationDispatcher
+	OutputFormat               string
+	RulesPerSecurityGroupQuota int
+	SARIFArtifactUri           string
+	StateStore                 StateStore
+	WriteAuditTags             bool
 }
 
 func NewExecutionEnvironment(isLambda bool) (*ExecutionEnvironment, error) {
 	var err error
 
+	logger = initLogger()
+
 	executionEnvironment := new(ExecutionEnvironment)
 
-	executionEnvironment.Client, err = initClient()
+	executionEnvironment.AccessRequestSecret = lookupOptionalEnvironmentVariable(accessRequestSecretEnvironmentVariableName, "")
+	executionEnvironment.AccessRequestToken = lookupOptionalEnvironmentVariable(accessRequestTokenEnvironmentVariableName, "")
+	awsConfiguration, err := initAwsConfiguration()
 	if err != nil {
 		return nil, err
 	}
+	executionEnvironment.Client = ec2.NewFromConfig(awsConfiguration)
 	executionEnvironment.Configuration, err = initConfiguration()
 	if err != nil {
 		return nil, err
 	}
-	executionEnvironment.DoDebug = initDoDebug()
+	executionEnvironment.EmitMetrics = lookupOptionalBoolEnvironmentVariable(emitMetricsEnvironmentVariableName, false)
+	executionEnvironment.GrantStore = initGrantStore()
+	executionEnvironment.Guard = NewGuard()
 	executionEnvironment.IsLambda = isLambda
+	if isLambda {
+		executionEnvironment.LambdaClient = lambda.NewFromConfig(awsConfiguration)
+	}
+	executionEnvironment.MetricsNamespace = lookupOptionalEnvironmentVariable(metricsNamespaceEnvironmentVariableName, defaultMetricsNamespace)
+	executionEnvironment.NotificationDispatcher = initNotificationDispatcher(awsConfiguration)
+	executionEnvironment.OutputFormat = initOutputFormat()
+	executionEnvironment.RulesPerSecurityGroupQuota = lookupOptionalIntEnvironmentVariable(rulesPerSecurityGroupQuotaEnvironmentVariableName, defaultRulesPerSecurityGroupQuota)
+	executionEnvironment.SARIFArtifactUri = initSARIFArtifactUri()
+	executionEnvironment.StateStore = initStateStore(awsConfiguration)
+	executionEnvironment.WriteAuditTags = lookupOptionalBoolEnvironmentVariable(writeAuditTagsEnvironmentVariableName, false)
 
 	return executionEnvironment, nil
 }
 
+// accessRequestsEnabled reports whether a grant store and a way to authenticate access requests are configured
+func (e *ExecutionEnvironment) accessRequestsEnabled() bool {
+	return e.GrantStore != nil && (e.AccessRequestSecret != "" || e.AccessRequestToken != "")
+}
+
 func init() {
 	if _, ok := os.LookupEnv(awsLambdaFunctionNameEnvironmentVariableName); ok {
 		var err error
@@ -51,39 +101,101 @@ func init() {
 	}
 }
 
-func initClient() (*ec2.Client, error) {
+func initAwsConfiguration() (aws.Config, error) {
 	awsConfiguration, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
-		log.Printf("Unable to load SDK config: %v", err)
+		logger.Errorf("Unable to load SDK config: %v", err)
 
-		return nil, err
+		return aws.Config{}, err
 	}
 
-	return ec2.NewFromConfig(awsConfiguration), nil
+	return awsConfiguration, nil
 }
 
+// initConfiguration reads the configuration from the file at CONFIGURATION_PATH when set, or from CONFIGURATION
 func initConfiguration() (*Configuration, error) {
+	if configurationPath := lookupOptionalEnvironmentVariable(configurationPathEnvironmentVariableName, ""); configurationPath != "" {
+		b, err := ioutil.ReadFile(configurationPath)
+		if err != nil {
+			logger.Errorf("Unable to read configuration file %s: %v", configurationPath, err)
+
+			return nil, err
+		}
+
+		return NewConfiguration(string(b))
+	}
+
 	configurationEnvironmentVariableValue := lookupEnvironmentVariable(configurationEnvironmentVariableName)
 
 	return NewConfiguration(configurationEnvironmentVariableValue)
 }
 
-func initDoDebug() bool {
-	debugEnvironmentVariableValue := lookupEnvironmentVariable(debugEnvironmentVariableName)
+func initOutputFormat() string {
+	outputFormatEnvironmentVariableValue := lookupOptionalEnvironmentVariable(outputFormatEnvironmentVariableName, tableOutputFormat)
 
-	doDebug, err := strconv.ParseBool(debugEnvironmentVariableValue)
-	if err != nil {
-		log.Printf("Unable to parse %s environment variable: %v", debugEnvironmentVariableName, err)
-	}
+	switch outputFormatEnvironmentVariableValue {
+	case diffOutputFormat, htmlOutputFormat, jsonOutputFormat, markdownOutputFormat, noneOutputFormat, plainOutputFormat, sarifOutputFormat, tableOutputFormat:
+		return outputFormatEnvironmentVariableValue
+	default:
+		logger.Warnf("Unable to parse %s environment variable: unsupported output format %s", outputFormatEnvironmentVariableName, outputFormatEnvironmentVariableValue)
 
-	return doDebug
+		return tableOutputFormat
+	}
 }
 
 func lookupEnvironmentVariable(environmentVariableName string) string {
 	environmentVariableValue, ok := os.LookupEnv(environmentVariableName)
 	if !ok {
-		log.Printf("Unable to lookup %s environment variable", environmentVariableName)
+		logger.Warnf("Unable to lookup %s environment variable", environmentVariableName)
+	}
+
+	return environmentVariableValue
+}
+
+func lookupOptionalEnvironmentVariable(environmentVariableName string, defaultValue string) string {
+	environmentVariableValue, ok := os.LookupEnv(environmentVariableName)
+	if !ok || environmentVariableValue == "" {
+		return defaultValue
 	}
 
 	return environmentVariableValue
 }
+
+func lookupOptionalBoolEnvironmentVariable(environmentVariableName string, defaultValue bool) bool {
+	environmentVariableValue := lookupOptionalEnvironmentVariable(environmentVariableName, strconv.FormatBool(defaultValue))
+
+	value, err := strconv.ParseBool(environmentVariableValue)
+	if err != nil {
+		logger.Warnf("Unable to parse %s environment variable: %v", environmentVariableName, err)
+
+		return defaultValue
+	}
+
+	return value
+}
+
+func lookupOptionalIntEnvironmentVariable(environmentVariableName string, defaultValue int) int {
+	environmentVariableValue := lookupOptionalEnvironmentVariable(environmentVariableName, strconv.Itoa(defaultValue))
+
+	value, err := strconv.Atoi(environmentVariableValue)
+	if err != nil {
+		logger.Warnf("Unable to parse %s environment variable: %v", environmentVariableName, err)
+
+		return defaultValue
+	}
+
+	return value
+}
+
+func lookupOptionalDurationEnvironmentVariable(environmentVariableName string, defaultValue time.Duration) time.Duration {
+	environmentVariableValue := lookupOptionalEnvironmentVariable(environmentVariableName, defaultValue.String())
+
+	value, err := time.ParseDuration(environmentVariableValue)
+	if err != nil {
+		logger.Warnf("Unable to parse %s environment variable: %v", environmentVariableName, err)
+
+		return defaultValue
+	}
+
+	return value
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	celtypes "github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"inet.af/netaddr"
+)
+
+const celPolicyFileExtension = ".cel"
+
+// PolicyEvaluator evaluates a policy against a security group delta and returns a violation for every denial
+type PolicyEvaluator interface {
+	Evaluate(policyInput PolicyInput) ([]PolicyViolation, error)
+	Name() string
+}
+
+// PolicyInput is the structured view of a security group delta that policies are evaluated against. Rules of both
+// directions are combined and can be told apart using their Direction. ToBeRules are the rules the security group will
+// have once the delta is applied, however they get there, so guardrails over them can't be bypassed by a rule that is
+// modified in place rather than authorized
+type PolicyInput struct {
+	AsIsRules             []Rule
+	AsIsSecurityGroup     *types.SecurityGroup
+	GroupId               string
+	GroupName             string
+	RegionName            string
+	ResolvedHostAddresses []string
+	RulesToAuthorize      []Rule
+	RulesToModify         []RuleModification
+	RulesToRevoke         []Rule
+	RulesToUpdate         []Rule
+	ToBeRules             []Rule
+	ToBeSecurityGroup     *types.SecurityGroup
+	VpcId                 string
+}
+
+func NewPolicyInput(securityGroupDelta *SecurityGroupDelta) *PolicyInput {
+	policyInput := new(PolicyInput)
+
+	ownerId := aws.ToString(securityGroupDelta.AsIsSecurityGroup.OwnerId)
+
+	policyInput.AsIsRules = append(securityGroupDelta.asIsRules(ingressDirection), securityGroupDelta.asIsRules(egressDirection)...)
+	policyInput.AsIsSecurityGroup = securityGroupDelta.AsIsSecurityGroup
+	policyInput.GroupId = aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupId)
+	policyInput.GroupName = aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupName)
+	policyInput.RegionName = securityGroupDelta.RegionName
+	policyInput.ResolvedHostAddresses = securityGroupDelta.ResolvedHostAddresses
+	policyInput.RulesToAuthorize = append(append(make([]Rule, 0), securityGroupDelta.IngressRulesToAuthorize...), securityGroupDelta.EgressRulesToAuthorize...)
+	policyInput.RulesToModify = securityGroupDelta.RulesToModify
+	policyInput.RulesToRevoke = append(append(make([]Rule, 0), securityGroupDelta.IngressRulesToRevoke...), securityGroupDelta.EgressRulesToRevoke...)
+	policyInput.RulesToUpdate = append(append(make([]Rule, 0), securityGroupDelta.IngressRulesToUpdate...), securityGroupDelta.EgressRulesToUpdate...)
+	policyInput.ToBeRules = append(
+		flattenIpPermissions(ingressDirection, securityGroupDelta.ToBeSecurityGroup.IpPermissions, ownerId),
+		flattenIpPermissions(egressDirection, securityGroupDelta.ToBeSecurityGroup.IpPermissionsEgress, ownerId)...,
+	)
+	policyInput.ToBeSecurityGroup = securityGroupDelta.ToBeSecurityGroup
+	policyInput.VpcId = aws.ToString(securityGroupDelta.ToBeSecurityGroup.VpcId)
+
+	return policyInput
+}
+
+// loadPolicyEvaluators loads the policy files of the policy configuration. A policy file that can't be loaded is
+// replaced by an evaluator that denies every delta, so a broken policy never lets a change through
+func loadPolicyEvaluators(policyConfiguration *PolicyConfiguration) []PolicyEvaluator {
+	policyEvaluators := make([]PolicyEvaluator, 0)
+
+	if policyConfiguration == nil {
+		return policyEvaluators
+	}
+
+	for _, policyFile := range policyConfiguration.Files {
+		var policyEvaluator PolicyEvaluator
+		var err error
+
+		switch filepath.Ext(policyFile) {
+		case celPolicyFileExtension:
+			policyEvaluator, err = newCELPolicyEvaluator(policyFile)
+		default:
+			err = fmt.Errorf("unsupported policy file extension %s", filepath.Ext(policyFile))
+		}
+
+		if err != nil {
+			logger.Errorf("Unable to load policy %s: %v", policyFile, err)
+
+			policyEvaluator = &failedPolicyEvaluator{
+				err:  err,
+				name: policyFile,
+			}
+		}
+
+		policyEvaluators = append(policyEvaluators, policyEvaluator)
+	}
+
+	return policyEvaluators
+}
+
+type failedPolicyEvaluator struct {
+	err  error
+	name string
+}
+
+func (f *failedPolicyEvaluator) Evaluate(policyInput PolicyInput) ([]PolicyViolation, error) {
+	return nil, fmt.Errorf("unable to load policy: %v", f.err)
+}
+
+func (f *failedPolicyEvaluator) Name() string {
+	return f.name
+}
+
+// celPolicyEvaluator evaluates a policy written as a Common Expression Language expression over the PolicyInput,
+// available as input. The expression evaluates to the list of its denial messages, empty when nothing is denied
+type celPolicyEvaluator struct {
+	name    string
+	program cel.Program
+}
+
+var celPolicyDeclarations = cel.Declarations(
+	decls.NewVar("input", decls.NewMapType(decls.String, decls.Dyn)),
+	decls.NewFunction("cidrOverlaps", decls.NewOverload("cidrOverlaps_string_string", []*exprpb.Type{decls.String, decls.String}, decls.Bool)),
+	decls.NewFunction("cidrWithin", decls.NewOverload("cidrWithin_string_string", []*exprpb.Type{decls.String, decls.String}, decls.Bool)),
+	decls.NewFunction("isHostAddress", decls.NewOverload("isHostAddress_list_string", []*exprpb.Type{decls.NewListType(decls.Dyn), decls.String}, decls.Bool)),
+)
+
+var celPolicyFunctions = cel.Functions(
+	&functions.Overload{
+		Operator: "cidrOverlaps",
+		Binary: func(cidr ref.Val, otherCidr ref.Val) ref.Val {
+			prefix, err := netaddr.ParseIPPrefix(fmt.Sprint(cidr.Value()))
+			if err != nil {
+				return celtypes.False
+			}
+			otherPrefix, err := netaddr.ParseIPPrefix(fmt.Sprint(otherCidr.Value()))
+			if err != nil {
+				return celtypes.False
+			}
+
+			return celtypes.Bool(prefix.Overlaps(otherPrefix))
+		},
+	},
+	&functions.Overload{
+		Operator: "cidrWithin",
+		Binary: func(cidr ref.Val, parentCidr ref.Val) ref.Val {
+			prefix, err := netaddr.ParseIPPrefix(fmt.Sprint(cidr.Value()))
+			if err != nil {
+				return celtypes.False
+			}
+			parentPrefix, err := netaddr.ParseIPPrefix(fmt.Sprint(parentCidr.Value()))
+			if err != nil {
+				return celtypes.False
+			}
+
+			return celtypes.Bool(parentPrefix.Bits() <= prefix.Bits() && parentPrefix.Contains(prefix.IP()))
+		},
+	},
+	&functions.Overload{
+		Operator: "isHostAddress",
+		Binary: func(resolvedHostAddresses ref.Val, cidr ref.Val) ref.Val {
+			prefix, err := netaddr.ParseIPPrefix(fmt.Sprint(cidr.Value()))
+			if err != nil || prefix.Bits() != prefix.IP().BitLen() {
+				return celtypes.False
+			}
+
+			lister, ok := resolvedHostAddresses.(traits.Lister)
+			if !ok {
+				return celtypes.False
+			}
+
+			for iterator := lister.Iterator(); iterator.HasNext() == celtypes.True; {
+				if fmt.Sprint(iterator.Next().Value()) == prefix.IP().String() {
+					return celtypes.True
+				}
+			}
+
+			return celtypes.False
+		},
+	},
+)
+
+func newCELPolicyEvaluator(policyFile string) (*celPolicyEvaluator, error) {
+	b, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := cel.NewEnv(celPolicyDeclarations)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(string(b))
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+
+	program, err := env.Program(ast, celPolicyFunctions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &celPolicyEvaluator{
+		name:    strings.TrimSuffix(filepath.Base(policyFile), filepath.Ext(policyFile)),
+		program: program,
+	}, nil
+}
+
+func (c *celPolicyEvaluator) Evaluate(policyInput PolicyInput) ([]PolicyViolation, error) {
+	input, err := policyInput.celValue()
+	if err != nil {
+		return nil, err
+	}
+
+	output, _, err := c.program.Eval(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := output.ConvertToNative(reflect.TypeOf([]string{}))
+	if err != nil {
+		return nil, fmt.Errorf("policy must evaluate to a list of denial messages: %v", err)
+	}
+
+	policyViolations := make([]PolicyViolation, 0)
+
+	for _, message := range messages.([]string) {
+		if message = strings.TrimSpace(message); message != "" {
+			policyViolations = append(policyViolations, PolicyViolation{
+				Message:  message,
+				Rule:     c.name,
+				Severity: errorSeverity,
+			})
+		}
+	}
+
+	return policyViolations, nil
+}
+
+func (c *celPolicyEvaluator) Name() string {
+	return c.name
+}
+
+// celValue returns the PolicyInput as nested maps and lists, with whole numbers as integers so that policies can
+// compare ports with integer literals
+func (p PolicyInput) celValue() (map[string]interface{}, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var value map[string]interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return convertJSONNumbers(value).(map[string]interface{}), nil
+}
+
+func convertJSONNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, element := range value {
+			value[key] = convertJSONNumbers(element)
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = convertJSONNumbers(element)
+		}
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+
+		return f
+	}
+
+	return value
+}
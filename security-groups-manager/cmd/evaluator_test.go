@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadSamplePolicyEvaluators(t *testing.T) []PolicyEvaluator {
+	policyEvaluators := loadPolicyEvaluators(&PolicyConfiguration{
+		Files: []string{
+			filepath.Join("..", "testdata", "policies", "prod-internal-cidrs.cel"),
+			filepath.Join("..", "testdata", "policies", "egress-internet-https-only.cel"),
+		},
+	})
+	require.Len(t, policyEvaluators, 2)
+	for _, policyEvaluator := range policyEvaluators {
+		require.IsType(t, new(celPolicyEvaluator), policyEvaluator)
+	}
+
+	return policyEvaluators
+}
+
+func TestCELPolicyEvaluators(t *testing.T) {
+	policyEvaluators := loadSamplePolicyEvaluators(t)
+
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{
+		GroupId: aws.String("sg-1"),
+		IpPermissions: []types.IpPermission{
+			{
+				FromPort:   aws.Int32(22),
+				IpProtocol: aws.String("tcp"),
+				IpRanges: []types.IpRange{
+					{CidrIp: aws.String("10.1.0.0/16")},
+					{CidrIp: aws.String("203.0.113.7/32")},
+					{CidrIp: aws.String("198.51.100.0/24")},
+				},
+				ToPort: aws.Int32(22),
+			},
+		},
+		IpPermissionsEgress: []types.IpPermission{
+			{FromPort: aws.Int32(443), IpProtocol: aws.String("tcp"), IpRanges: []types.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}, ToPort: aws.Int32(443)},
+			{FromPort: aws.Int32(80), IpProtocol: aws.String("tcp"), IpRanges: []types.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}, ToPort: aws.Int32(80)},
+		},
+		VpcId: aws.String("vpc-prod"),
+	})
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1"), OwnerId: aws.String("123456789012")}
+	securityGroupDelta.AsIsRules = make([]Rule, 0)
+	securityGroupDelta.ResolvedHostAddresses = []string{"203.0.113.7"}
+
+	securityGroupDelta.calculate()
+	securityGroupDelta.evaluatePolicies(policyEvaluators)
+
+	assert.Equal(t, []PolicyViolation{
+		{Message: "198.51.100.0/24 is outside 10.0.0.0/8", Rule: "prod-internal-cidrs", Severity: errorSeverity},
+		{Message: "Outbound tcp 80-80 to 0.0.0.0/0 is not allowed", Rule: "egress-internet-https-only", Severity: errorSeverity},
+	}, securityGroupDelta.PolicyViolations)
+	assert.False(t, securityGroupDelta.canApply())
+}
+
+func TestCELPolicyEvaluatorsDenyRulesModifiedInPlace(t *testing.T) {
+	policyEvaluators := loadSamplePolicyEvaluators(t)
+
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{
+		GroupId: aws.String("sg-1"),
+		IpPermissions: []types.IpPermission{
+			{FromPort: aws.Int32(22), IpProtocol: aws.String("tcp"), IpRanges: []types.IpRange{{CidrIp: aws.String("198.51.100.0/24")}}, ToPort: aws.Int32(22)},
+		},
+		VpcId: aws.String("vpc-prod"),
+	})
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1"), OwnerId: aws.String("123456789012")}
+	securityGroupDelta.AsIsRules = []Rule{
+		{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 22, ToPort: 22, SourceKind: ipv4CidrSourceKind, Source: "10.1.0.0/16", RuleId: "sgr-1"},
+	}
+
+	securityGroupDelta.calculate()
+	require.Len(t, securityGroupDelta.RulesToModify, 1)
+	require.Empty(t, securityGroupDelta.IngressRulesToAuthorize)
+
+	securityGroupDelta.evaluatePolicies(policyEvaluators)
+
+	assert.Equal(t, []PolicyViolation{
+		{Message: "198.51.100.0/24 is outside 10.0.0.0/8", Rule: "prod-internal-cidrs", Severity: errorSeverity},
+	}, securityGroupDelta.PolicyViolations)
+	assert.False(t, securityGroupDelta.canApply())
+}
+
+func TestCELPolicyEvaluatorRejectsInvalidPolicies(t *testing.T) {
+	for name, policy := range map[string]string{
+		"Syntax":  "input.ToBeRules.filter(r,",
+		"Unknown": "unknownFunction(input)",
+	} {
+		t.Run(name, func(t *testing.T) {
+			policyFile := filepath.Join(t.TempDir(), "policy.cel")
+			require.NoError(t, ioutil.WriteFile(policyFile, []byte(policy), 0644))
+
+			_, err := newCELPolicyEvaluator(policyFile)
+			assert.Error(t, err)
+		})
+	}
+
+	policyFile := filepath.Join(t.TempDir(), "policy.cel")
+	require.NoError(t, ioutil.WriteFile(policyFile, []byte("input.GroupId"), 0644))
+
+	policyEvaluator, err := newCELPolicyEvaluator(policyFile)
+	require.NoError(t, err)
+
+	_, err = policyEvaluator.Evaluate(PolicyInput{GroupId: "sg-1"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "policy must evaluate to a list of denial messages")
+	}
+}
+
+func TestFailedPolicyEvaluatorDenies(t *testing.T) {
+	policyEvaluators := loadPolicyEvaluators(&PolicyConfiguration{
+		Files: []string{"missing.cel", "policy.rego"},
+	})
+
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{})
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{}
+
+	securityGroupDelta.evaluatePolicies(policyEvaluators)
+
+	require.Len(t, securityGroupDelta.PolicyViolations, 2)
+	assert.Equal(t, "missing.cel", securityGroupDelta.PolicyViolations[0].Rule)
+	assert.Equal(t, "policy.rego", securityGroupDelta.PolicyViolations[1].Rule)
+	assert.False(t, securityGroupDelta.canApply())
+}
+
+func TestCalculateSecurityGroupDeltasKeepsThePolicyViolationsOfTheConfiguration(t *testing.T) {
+	controller := NewController(nil)
+	controller.AsIsSecurityGroups = []types.SecurityGroup{{GroupId: aws.String("sg-1"), VpcId: aws.String("vpc-1")}}
+	controller.PolicyEvaluators = loadPolicyEvaluators(&PolicyConfiguration{Files: []string{"missing.cel"}})
+	controller.ToBeSecurityGroups = []types.SecurityGroup{{GroupId: aws.String("sg-1"), VpcId: aws.String("vpc-1")}}
+
+	policyViolations := make([]PolicyViolation, 1, 4)
+	policyViolations[0] = PolicyViolation{Rule: sshOpenToInternetPolicyRule, Severity: warningSeverity}
+	controller.PolicyViolations["sg-1"] = policyViolations
+
+	controller.CalculateSecurityGroupDeltas()
+
+	require.Len(t, controller.SecurityGroupDeltas, 1)
+	assert.Len(t, controller.SecurityGroupDeltas[0].PolicyViolations, 2)
+	assert.Equal(t, []PolicyViolation{{Rule: sshOpenToInternetPolicyRule, Severity: warningSeverity}, {}}, policyViolations[:2], "The denials aren't written to the spare capacity of the configuration's violations")
+}
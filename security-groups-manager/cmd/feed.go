@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"inet.af/netaddr"
+)
+
+const (
+	awsIpRangesFeedParser = "aws-ip-ranges"
+	cidrListFeedParser    = "cidr-list"
+	githubMetaFeedParser  = "github-meta"
+)
+
+const fetchRequestTimeout = 30 * time.Second
+
+// The AWS ip-ranges.json document is a couple of MB, a response larger than this isn't a feed or a host address
+const maximumFetchResponseLength = 32 * 1024 * 1024
+
+// allowFileUrls lets the tests read their fixtures from testdata. A configuration can't read local files
+var allowFileUrls = false
+
+type feedParser func(b []byte) ([]feedPrefix, error)
+
+var feedParsers = map[string]feedParser{
+	awsIpRangesFeedParser: parseAwsIpRangesFeed,
+	cidrListFeedParser:    parseCidrListFeed,
+	githubMetaFeedParser:  parseGithubMetaFeed,
+}
+
+type Feed struct {
+	Description *string
+	Filter      *string
+	Parser      *string
+	URL         *string
+}
+
+// expand fetches the feed and returns the prefixes matching its filter
+func (f *Feed) expand() ([]netaddr.IPPrefix, error) {
+	if f.URL == nil || f.Parser == nil {
+		return nil, fmt.Errorf("feed requires both a URL and a Parser")
+	}
+
+	parse, ok := feedParsers[*f.Parser]
+	if !ok {
+		return nil, fmt.Errorf("unknown feed parser %s", *f.Parser)
+	}
+
+	filter, err := parseFeedFilter(f.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := fetchUrl(*f.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	feedPrefixes, err := parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse feed %s: %v", *f.URL, err)
+	}
+
+	prefixes := make([]netaddr.IPPrefix, 0, len(feedPrefixes))
+
+	for _, feedPrefix := range feedPrefixes {
+		if feedPrefix.matches(filter) {
+			prefixes = append(prefixes, feedPrefix.Prefix)
+		}
+	}
+
+	return prefixes, nil
+}
+
+type feedPrefix struct {
+	Attributes map[string]string
+	Prefix     netaddr.IPPrefix
+}
+
+func (f *feedPrefix) matches(filter map[string]string) bool {
+	for key, value := range filter {
+		if !strings.EqualFold(f.Attributes[key], value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fetchUrl reads a document of at most maximumFetchResponseLength bytes over HTTP(S)
+func fetchUrl(documentUrl string) ([]byte, error) {
+	parsedUrl, err := url.Parse(documentUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsedUrl.Scheme == "file" {
+		if !allowFileUrls {
+			return nil, fmt.Errorf("unable to fetch %s: file URLs are not allowed", documentUrl)
+		}
+
+		return ioutil.ReadFile(parsedUrl.Path)
+	}
+
+	client := &http.Client{
+		Timeout: fetchRequestTimeout,
+	}
+
+	response, err := client.Get(documentUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s fetching %s", response.Status, documentUrl)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(response.Body, maximumFetchResponseLength+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > maximumFetchResponseLength {
+		return nil, fmt.Errorf("response fetching %s is larger than %d bytes", documentUrl, maximumFetchResponseLength)
+	}
+
+	return b, nil
+}
+
+// parseFeedFilter parses a filter expression of the form key=value[,key=value...]
+func parseFeedFilter(filter *string) (map[string]string, error) {
+	parsedFilter := make(map[string]string)
+
+	if filter == nil || strings.TrimSpace(*filter) == "" {
+		return parsedFilter, nil
+	}
+
+	for _, term := range strings.Split(*filter, ",") {
+		keyValue := strings.SplitN(term, "=", 2)
+		if len(keyValue) != 2 || strings.TrimSpace(keyValue[0]) == "" {
+			return nil, fmt.Errorf("invalid feed filter term %q", term)
+		}
+
+		parsedFilter[strings.ToLower(strings.TrimSpace(keyValue[0]))] = strings.TrimSpace(keyValue[1])
+	}
+
+	return parsedFilter, nil
+}
+
+// parseAwsIpRangesFeed parses the AWS ip-ranges.json document. Each prefix carries its service, region and
+// network_border_group attributes
+func parseAwsIpRangesFeed(b []byte) ([]feedPrefix, error) {
+	var awsIpRanges struct {
+		Prefixes []struct {
+			IpPrefix           string `json:"ip_prefix"`
+			NetworkBorderGroup string `json:"network_border_group"`
+			Region             string `json:"region"`
+			Service            string `json:"service"`
+		} `json:"prefixes"`
+		Ipv6Prefixes []struct {
+			Ipv6Prefix         string `json:"ipv6_prefix"`
+			NetworkBorderGroup string `json:"network_border_group"`
+			Region             string `json:"region"`
+			Service            string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+
+	if err := json.Unmarshal(b, &awsIpRanges); err != nil {
+		return nil, err
+	}
+
+	feedPrefixes := make([]feedPrefix, 0, len(awsIpRanges.Prefixes)+len(awsIpRanges.Ipv6Prefixes))
+
+	appendFeedPrefix := func(cidr string, networkBorderGroup string, region string, service string) error {
+		parsedPrefix, err := netaddr.ParseIPPrefix(cidr)
+		if err != nil {
+			return err
+		}
+
+		feedPrefixes = append(feedPrefixes, feedPrefix{
+			Attributes: map[string]string{
+				"network_border_group": networkBorderGroup,
+				"region":               region,
+				"service":              service,
+			},
+			Prefix: parsedPrefix.Masked(),
+		})
+
+		return nil
+	}
+
+	for _, prefix := range awsIpRanges.Prefixes {
+		if err := appendFeedPrefix(prefix.IpPrefix, prefix.NetworkBorderGroup, prefix.Region, prefix.Service); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, prefix := range awsIpRanges.Ipv6Prefixes {
+		if err := appendFeedPrefix(prefix.Ipv6Prefix, prefix.NetworkBorderGroup, prefix.Region, prefix.Service); err != nil {
+			return nil, err
+		}
+	}
+
+	return feedPrefixes, nil
+}
+
+// parseCidrListFeed parses a plain text list of CIDRs, one per line, such as the Cloudflare IPv4 and IPv6 lists
+func parseCidrListFeed(b []byte) ([]feedPrefix, error) {
+	feedPrefixes := make([]feedPrefix, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parsedPrefix, err := netaddr.ParseIPPrefix(line)
+		if err != nil {
+			return nil, err
+		}
+
+		feedPrefixes = append(feedPrefixes, feedPrefix{
+			Attributes: map[string]string{},
+			Prefix:     parsedPrefix.Masked(),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return feedPrefixes, nil
+}
+
+// parseGithubMetaFeed parses the GitHub /meta document. Each prefix carries a key attribute naming the list it was
+// published in, such as hooks or actions
+func parseGithubMetaFeed(b []byte) ([]feedPrefix, error) {
+	var githubMeta map[string]json.RawMessage
+
+	if err := json.Unmarshal(b, &githubMeta); err != nil {
+		return nil, err
+	}
+
+	feedPrefixes := make([]feedPrefix, 0)
+
+	for key, value := range githubMeta {
+		var cidrs []string
+
+		// Not every key in the document is a list of CIDRs
+		if err := json.Unmarshal(value, &cidrs); err != nil {
+			continue
+		}
+
+		for _, cidr := range cidrs {
+			parsedPrefix, err := netaddr.ParseIPPrefix(cidr)
+			if err != nil {
+				continue
+			}
+
+			feedPrefixes = append(feedPrefixes, feedPrefix{
+				Attributes: map[string]string{
+					"key": key,
+				},
+				Prefix: parsedPrefix.Masked(),
+			})
+		}
+	}
+
+	return feedPrefixes, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"inet.af/netaddr"
+)
+
+func init() {
+	allowFileUrls = true
+}
+
+func feedFixtureUrl(t *testing.T, name string) string {
+	path, err := filepath.Abs(filepath.Join("..", "testdata", "feeds", name))
+	require.NoError(t, err)
+
+	return "file://" + filepath.ToSlash(path)
+}
+
+func prefixStrings(prefixes []netaddr.IPPrefix) []string {
+	strings := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		strings = append(strings, prefix.String())
+	}
+
+	return strings
+}
+
+func TestFeedExpand(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		parser  string
+		filter  string
+		want    []string
+	}{
+		{
+			name:    "AWS ip-ranges filtered by service and region",
+			fixture: "aws-ip-ranges.json",
+			parser:  awsIpRangesFeedParser,
+			filter:  "service=EC2_INSTANCE_CONNECT, region=us-east-1",
+			want:    []string{"18.206.107.24/29", "2600:1f18:6fe3:8c00::/59"},
+		},
+		{
+			name:    "GitHub meta filtered by key",
+			fixture: "github-meta.json",
+			parser:  githubMetaFeedParser,
+			filter:  "key=hooks",
+			want:    []string{"192.30.252.0/22", "185.199.108.0/22", "2a0a:a440::/29"},
+		},
+		{
+			name:    "CIDR list",
+			fixture: "cloudflare-ips-v4.txt",
+			parser:  cidrListFeedParser,
+			want:    []string{"173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feed := Feed{
+				Filter: aws.String(test.filter),
+				Parser: aws.String(test.parser),
+				URL:    aws.String(feedFixtureUrl(t, test.fixture)),
+			}
+
+			prefixes, err := feed.expand()
+
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.want, prefixStrings(prefixes))
+		})
+	}
+}
+
+func TestFeedExpandErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		feed Feed
+	}{
+		{
+			name: "Missing URL",
+			feed: Feed{Parser: aws.String(cidrListFeedParser)},
+		},
+		{
+			name: "Unknown parser",
+			feed: Feed{Parser: aws.String("unknown"), URL: aws.String("file:///dev/null")},
+		},
+		{
+			name: "Invalid filter",
+			feed: Feed{Filter: aws.String("service"), Parser: aws.String(cidrListFeedParser), URL: aws.String("file:///dev/null")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.feed.expand()
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFetchUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.Write([]byte(strings.Repeat("0", maximumFetchResponseLength+1)))
+
+			return
+		}
+
+		w.Write([]byte("192.0.2.0/24\n"))
+	}))
+	defer server.Close()
+
+	b, err := fetchUrl(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.0/24\n", string(b))
+
+	_, err = fetchUrl(server.URL + "/large")
+	assert.Error(t, err)
+
+	allowFileUrls = false
+	defer func() {
+		allowFileUrls = true
+	}()
+
+	_, err = fetchUrl(feedFixtureUrl(t, "cloudflare-ips-v4.txt"))
+	assert.Error(t, err)
+}
+
+func TestConsolidateFeeds(t *testing.T) {
+	securityGroup := SecurityGroup{
+		IpPermissions: []IpPermission{
+			{
+				Feeds: []Feed{
+					{
+						Description: aws.String("GitHub hooks"),
+						Filter:      aws.String("key=hooks"),
+						Parser:      aws.String(githubMetaFeedParser),
+						URL:         aws.String(feedFixtureUrl(t, "github-meta.json")),
+					},
+				},
+				IpRanges: []IpRange{
+					{CidrIp: aws.String("192.30.252.0/22"), Description: aws.String("Static")},
+				},
+			},
+		},
+	}
+
+	securityGroup.consolidateHostsAndIpRanges(securityGroup.IpPermissions, NewHostResolver(nil, time.Now))
+
+	assert.ElementsMatch(t, []IpRange{
+		{CidrIp: aws.String("192.30.252.0/22"), Description: aws.String("Static")},
+		{CidrIp: aws.String("185.199.108.0/22"), Description: aws.String("GitHub hooks")},
+	}, securityGroup.IpPermissions[0].IpRanges)
+	assert.Equal(t, []Ipv6Range{
+		{CidrIpv6: aws.String("2a0a:a440::/29"), Description: aws.String("GitHub hooks")},
+	}, securityGroup.IpPermissions[0].Ipv6Ranges)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const grantStorePathEnvironmentVariableName = "GRANT_STORE_PATH"
+
+// Grant records a source IP granted temporary access through an access profile
+type Grant struct {
+	ExpiresAt time.Time
+	GrantedAt time.Time
+	GroupId   string
+	Profile   string
+	Requester string
+	SourceIp  string
+}
+
+func (g *Grant) isActive(now time.Time) bool {
+	return now.Before(g.ExpiresAt)
+}
+
+// GrantStore persists grants between invocations
+type GrantStore interface {
+	Add(grant Grant) error
+	List() ([]Grant, error)
+}
+
+// FileGrantStore stores grants as a JSON document in a local file. Expired grants are pruned whenever a grant is added.
+// Adding a grant holds an exclusive lock on a lock file next to the store, so processes sharing the store (for example
+// Lambda execution environments mounting the same EFS file system) never overwrite each other's grants
+type FileGrantStore struct {
+	Mutex sync.Mutex
+	Now   func() time.Time
+	Path  string
+}
+
+func NewFileGrantStore(path string) *FileGrantStore {
+	fileGrantStore := new(FileGrantStore)
+
+	fileGrantStore.Now = time.Now
+	fileGrantStore.Path = path
+
+	return fileGrantStore
+}
+
+func (f *FileGrantStore) Add(grant Grant) error {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+
+	unlock, err := lockFile(f.Path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	grants, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	activeGrants := make([]Grant, 0, len(grants)+1)
+	for _, existingGrant := range grants {
+		if existingGrant.isActive(f.Now()) {
+			activeGrants = append(activeGrants, existingGrant)
+		}
+	}
+	activeGrants = append(activeGrants, grant)
+
+	b, err := json.MarshalIndent(activeGrants, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(f.Path, b)
+}
+
+func (f *FileGrantStore) List() ([]Grant, error) {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+
+	return f.read()
+}
+
+func (f *FileGrantStore) read() ([]Grant, error) {
+	grants := make([]Grant, 0)
+
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return grants, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &grants); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// lockFile blocks until it holds an exclusive advisory lock on the file at path, creating it when needed, and returns
+// the function releasing the lock
+func lockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+func initGrantStore() GrantStore {
+	path := lookupOptionalEnvironmentVariable(grantStorePathEnvironmentVariableName, "")
+	if path == "" {
+		return nil
+	}
+
+	return NewFileGrantStore(path)
+}
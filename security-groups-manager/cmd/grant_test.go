@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileGrantStore(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	directory, err := ioutil.TempDir("", "grants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	fileGrantStore := NewFileGrantStore(filepath.Join(directory, "grants.json"))
+	fileGrantStore.Now = func() time.Time { return now }
+
+	grants, err := fileGrantStore.List()
+	assert.NoError(t, err)
+	assert.Empty(t, grants)
+
+	expiredGrant := Grant{ExpiresAt: now.Add(time.Minute), GrantedAt: now, GroupId: "sg-1", Profile: "ssh", Requester: "alice", SourceIp: "192.0.2.1"}
+	assert.NoError(t, fileGrantStore.Add(expiredGrant))
+
+	now = now.Add(time.Hour)
+
+	activeGrant := Grant{ExpiresAt: now.Add(time.Hour), GrantedAt: now, GroupId: "sg-1", Profile: "ssh", Requester: "bob", SourceIp: "192.0.2.2"}
+	assert.NoError(t, fileGrantStore.Add(activeGrant))
+
+	grants, err = fileGrantStore.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []Grant{activeGrant}, grants)
+}
+
+func TestFileGrantStoreSharedBetweenProcesses(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "grants.json")
+
+	// Every store stands for another process, sharing nothing but the file
+	var waitGroup sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		waitGroup.Add(1)
+
+		go func(i int) {
+			defer waitGroup.Done()
+
+			fileGrantStore := NewFileGrantStore(path)
+			fileGrantStore.Now = func() time.Time { return now }
+
+			assert.NoError(t, fileGrantStore.Add(Grant{ExpiresAt: now.Add(time.Hour), GrantedAt: now, GroupId: "sg-1", Profile: "ssh", Requester: fmt.Sprintf("user-%d", i), SourceIp: "192.0.2.1"}))
+		}(i)
+	}
+	waitGroup.Wait()
+
+	grants, err := NewFileGrantStore(path).List()
+	assert.NoError(t, err)
+	assert.Len(t, grants, 20)
+}
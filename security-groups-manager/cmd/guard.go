@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	maxRulesRevokedPerSecurityGroupEnvironmentVariableName = "MAX_RULES_REVOKED_PER_SECURITY_GROUP"
+	maxSecurityGroupsChangedPerRunEnvironmentVariableName  = "MAX_SECURITY_GROUPS_CHANGED_PER_RUN"
+	preventRevokingAllIngressEnvironmentVariableName       = "PREVENT_REVOKING_ALL_INGRESS"
+)
+
+// Guard holds the blast-radius thresholds a run must stay within. A threshold of 0 disables the corresponding check
+type Guard struct {
+	MaxRulesRevokedPerSecurityGroup int
+	MaxSecurityGroupsChangedPerRun  int
+	PreventRevokingAllIngress       bool
+}
+
+func NewGuard() *Guard {
+	guard := new(Guard)
+
+	guard.MaxRulesRevokedPerSecurityGroup = lookupOptionalIntEnvironmentVariable(maxRulesRevokedPerSecurityGroupEnvironmentVariableName, 0)
+	guard.MaxSecurityGroupsChangedPerRun = lookupOptionalIntEnvironmentVariable(maxSecurityGroupsChangedPerRunEnvironmentVariableName, 0)
+	guard.PreventRevokingAllIngress = lookupOptionalBoolEnvironmentVariable(preventRevokingAllIngressEnvironmentVariableName, false)
+
+	return guard
+}
+
+// check records a violation on every delta that exceeds a per security group threshold. If more security groups would
+// change than allowed per run, every changing delta is recorded as a violation
+func (g *Guard) check(securityGroupDeltas []SecurityGroupDelta, runLogger *Logger) {
+	changingSecurityGroupDeltas := make([]*SecurityGroupDelta, 0)
+
+	for i := range securityGroupDeltas {
+		securityGroupDelta := &securityGroupDeltas[i]
+
+		securityGroupDelta.GuardViolations = make([]string, 0)
+
+		if securityGroupDelta.AsIsSecurityGroup == nil || !securityGroupDelta.hasChanges() || !securityGroupDelta.canApply() {
+			continue
+		}
+
+		g.checkSecurityGroupDelta(securityGroupDelta)
+
+		if len(securityGroupDelta.GuardViolations) == 0 {
+			changingSecurityGroupDeltas = append(changingSecurityGroupDeltas, securityGroupDelta)
+		}
+	}
+
+	if g.MaxSecurityGroupsChangedPerRun > 0 && len(changingSecurityGroupDeltas) > g.MaxSecurityGroupsChangedPerRun {
+		runLogger.Warnf("Blast-radius guard tripped: %d security groups would change", len(changingSecurityGroupDeltas))
+
+		for _, securityGroupDelta := range changingSecurityGroupDeltas {
+			securityGroupDelta.GuardViolations = append(securityGroupDelta.GuardViolations, fmt.Sprintf(
+				"%d security groups would change, exceeding the maximum of %d per run",
+				len(changingSecurityGroupDeltas),
+				g.MaxSecurityGroupsChangedPerRun,
+			))
+		}
+	}
+}
+
+func (g *Guard) checkSecurityGroupDelta(securityGroupDelta *SecurityGroupDelta) {
+	rulesRevoked := countRulesRevoked(securityGroupDelta, ingressDirection) + countRulesRevoked(securityGroupDelta, egressDirection)
+
+	if g.MaxRulesRevokedPerSecurityGroup > 0 && rulesRevoked > g.MaxRulesRevokedPerSecurityGroup {
+		securityGroupDelta.GuardViolations = append(securityGroupDelta.GuardViolations, fmt.Sprintf(
+			"%d rules would be revoked, exceeding the maximum of %d per security group",
+			rulesRevoked,
+			g.MaxRulesRevokedPerSecurityGroup,
+		))
+	}
+
+	if g.PreventRevokingAllIngress && len(securityGroupDelta.asIsRules(ingressDirection)) > 0 &&
+		countToBeIngressRules(securityGroupDelta) == 0 {
+		securityGroupDelta.GuardViolations = append(securityGroupDelta.GuardViolations, "Every inbound rule would be revoked")
+	}
+}
+
+// countToBeIngressRules counts the inbound rules the security group will have once the delta is applied. A rule
+// modified in place, even to another source, is still an inbound rule
+func countToBeIngressRules(securityGroupDelta *SecurityGroupDelta) int {
+	return len(securityGroupDelta.asIsRules(ingressDirection)) -
+		len(securityGroupDelta.IngressRulesToRevoke) +
+		len(securityGroupDelta.IngressRulesToAuthorize)
+}
+
+// countRulesRevoked counts the rules to revoke in a direction along with the rules modified in place to another
+// source, as the access granted to their as is source is revoked all the same
+func countRulesRevoked(securityGroupDelta *SecurityGroupDelta, direction string) int {
+	rulesRevoked := len(securityGroupDelta.IngressRulesToRevoke)
+	if direction == egressDirection {
+		rulesRevoked = len(securityGroupDelta.EgressRulesToRevoke)
+	}
+
+	for _, ruleModification := range securityGroupDelta.rulesToModify(direction) {
+		if ruleModification.From.Source != ruleModification.To.Source {
+			rulesRevoked++
+		}
+	}
+
+	return rulesRevoked
+}
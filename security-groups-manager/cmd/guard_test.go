@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGuardedSecurityGroupDelta(groupId string, asIsRules []Rule, rulesToRevoke []Rule, rulesToAuthorize []Rule) SecurityGroupDelta {
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String(groupId)})
+	securityGroupDelta.AsIsRules = asIsRules
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String(groupId)}
+	securityGroupDelta.IngressRulesToAuthorize = rulesToAuthorize
+	securityGroupDelta.IngressRulesToRevoke = rulesToRevoke
+
+	return *securityGroupDelta
+}
+
+func withRulesToModify(securityGroupDelta SecurityGroupDelta, ruleModifications ...RuleModification) SecurityGroupDelta {
+	securityGroupDelta.RulesToModify = ruleModifications
+
+	return securityGroupDelta
+}
+
+func TestGuard(t *testing.T) {
+	ssh := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 22, ToPort: 22, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.1/32"}
+	http := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 80, ToPort: 80, SourceKind: ipv4CidrSourceKind, Source: "0.0.0.0/0"}
+	https := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: ipv4CidrSourceKind, Source: "0.0.0.0/0"}
+
+	// A host resolving to the wrong address moves every rule sourced from it in place
+	moveSsh := RuleModification{RuleId: "sgr-1", From: ssh, To: ssh}
+	moveSsh.To.Source = "203.0.113.1/32"
+	moveHttps := RuleModification{RuleId: "sgr-2", From: https, To: https}
+	moveHttps.To.Source = "203.0.113.1/32"
+	describeHttps := RuleModification{RuleId: "sgr-2", From: https, To: https}
+	describeHttps.To.Description = "Public"
+
+	tests := []struct {
+		name                string
+		guard               Guard
+		securityGroupDeltas []SecurityGroupDelta
+		want                [][]string
+	}{
+		{
+			name:  "Disabled",
+			guard: Guard{},
+			securityGroupDeltas: []SecurityGroupDelta{
+				newGuardedSecurityGroupDelta("sg-1", []Rule{ssh, http}, []Rule{ssh, http}, []Rule{}),
+			},
+			want: [][]string{{}},
+		},
+		{
+			name:  "Max rules revoked per security group",
+			guard: Guard{MaxRulesRevokedPerSecurityGroup: 1},
+			securityGroupDeltas: []SecurityGroupDelta{
+				newGuardedSecurityGroupDelta("sg-1", []Rule{ssh, http, https}, []Rule{ssh, http}, []Rule{}),
+				newGuardedSecurityGroupDelta("sg-2", []Rule{ssh, http}, []Rule{ssh}, []Rule{}),
+			},
+			want: [][]string{
+				{"2 rules would be revoked, exceeding the maximum of 1 per security group"},
+				{},
+			},
+		},
+		{
+			name:  "Prevent revoking all ingress",
+			guard: Guard{PreventRevokingAllIngress: true},
+			securityGroupDeltas: []SecurityGroupDelta{
+				newGuardedSecurityGroupDelta("sg-1", []Rule{ssh, http}, []Rule{ssh, http}, []Rule{}),
+				newGuardedSecurityGroupDelta("sg-2", []Rule{ssh}, []Rule{ssh}, []Rule{https}),
+			},
+			want: [][]string{
+				{"Every inbound rule would be revoked"},
+				{},
+			},
+		},
+		{
+			name:  "Rules modified to another source are revoked",
+			guard: Guard{MaxRulesRevokedPerSecurityGroup: 1, PreventRevokingAllIngress: true},
+			securityGroupDeltas: []SecurityGroupDelta{
+				withRulesToModify(newGuardedSecurityGroupDelta("sg-1", []Rule{ssh, https}, []Rule{}, []Rule{}), moveSsh, moveHttps),
+				withRulesToModify(newGuardedSecurityGroupDelta("sg-2", []Rule{ssh, https}, []Rule{}, []Rule{}), moveSsh, describeHttps),
+			},
+			want: [][]string{
+				{"2 rules would be revoked, exceeding the maximum of 1 per security group"},
+				{},
+			},
+		},
+		{
+			name:  "Rules modified to another source keep ingress",
+			guard: Guard{PreventRevokingAllIngress: true},
+			securityGroupDeltas: []SecurityGroupDelta{
+				withRulesToModify(newGuardedSecurityGroupDelta("sg-1", []Rule{ssh}, []Rule{}, []Rule{}), moveSsh),
+				withRulesToModify(newGuardedSecurityGroupDelta("sg-2", []Rule{ssh, https}, []Rule{https}, []Rule{}), moveSsh),
+			},
+			want: [][]string{
+				{},
+				{},
+			},
+		},
+		{
+			name:  "Max security groups changed per run",
+			guard: Guard{MaxSecurityGroupsChangedPerRun: 1},
+			securityGroupDeltas: []SecurityGroupDelta{
+				newGuardedSecurityGroupDelta("sg-1", []Rule{ssh}, []Rule{}, []Rule{http}),
+				newGuardedSecurityGroupDelta("sg-2", []Rule{ssh}, []Rule{}, []Rule{https}),
+				newGuardedSecurityGroupDelta("sg-3", []Rule{ssh}, []Rule{}, []Rule{}),
+			},
+			want: [][]string{
+				{"2 security groups would change, exceeding the maximum of 1 per run"},
+				{"2 security groups would change, exceeding the maximum of 1 per run"},
+				{},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.guard.check(test.securityGroupDeltas, logger)
+
+			for i, securityGroupDelta := range test.securityGroupDeltas {
+				assert.Equal(t, test.want[i], securityGroupDelta.GuardViolations)
+				assert.Equal(t, len(test.want[i]) == 0, securityGroupDelta.canApply())
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookup returns the addresses of the host. An FQDN is resolved using DNS, while a URL is fetched and its response,
+// or the value found at JSONPath within it, is used as the address
+func (h *Host) lookup() ([]string, error) {
+	switch {
+	case h.FQDN != nil:
+		return lookupHost(*h.FQDN)
+	case h.URL != nil:
+		b, err := fetchUrl(*h.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		if h.JSONPath == nil {
+			return []string{strings.TrimSpace(string(b))}, nil
+		}
+
+		address, err := lookupJSONPath(b, *h.JSONPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find %s in response from %s: %v", *h.JSONPath, *h.URL, err)
+		}
+
+		return []string{address}, nil
+	default:
+		return nil, fmt.Errorf("host requires either an FQDN or a URL")
+	}
+}
+
+func (h *Host) name() string {
+	switch {
+	case h.FQDN != nil:
+		return *h.FQDN
+	case h.URL != nil:
+		return *h.URL
+	default:
+		return ""
+	}
+}
+
+// lookupJSONPath returns the string found at a dot separated path such as ip or data.addresses.0 within a JSON
+// document. Numeric segments index into arrays
+func lookupJSONPath(b []byte, path string) (string, error) {
+	var document interface{}
+
+	if err := json.Unmarshal(b, &document); err != nil {
+		return "", err
+	}
+
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		switch value := document.(type) {
+		case map[string]interface{}:
+			element, ok := value[segment]
+			if !ok {
+				return "", fmt.Errorf("key %s not found", segment)
+			}
+
+			document = element
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(value) {
+				return "", fmt.Errorf("index %s out of range", segment)
+			}
+
+			document = value[index]
+		default:
+			return "", fmt.Errorf("segment %s can't be applied to a scalar", segment)
+		}
+	}
+
+	address, ok := document.(string)
+	if !ok {
+		return "", fmt.Errorf("value is not a string")
+	}
+
+	return strings.TrimSpace(address), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostLookupURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/plain":
+			fmt.Fprintln(w, "203.0.113.7")
+		case "/json":
+			fmt.Fprint(w, `{"wan": {"addresses": ["2001:db8::7", "203.0.113.8"]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		host     Host
+		want     []string
+		wantFail bool
+	}{
+		{
+			name: "Plain text",
+			host: Host{URL: aws.String(server.URL + "/plain")},
+			want: []string{"203.0.113.7"},
+		},
+		{
+			name: "JSON path",
+			host: Host{URL: aws.String(server.URL + "/json"), JSONPath: aws.String("wan.addresses.1")},
+			want: []string{"203.0.113.8"},
+		},
+		{
+			name:     "Missing JSON path",
+			host:     Host{URL: aws.String(server.URL + "/json"), JSONPath: aws.String("lan.addresses.0")},
+			wantFail: true,
+		},
+		{
+			name:     "Not found",
+			host:     Host{URL: aws.String(server.URL + "/missing")},
+			wantFail: true,
+		},
+		{
+			name:     "Neither FQDN nor URL",
+			host:     Host{},
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addresses, err := test.host.lookup()
+
+			if test.wantFail {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.want, addresses)
+		})
+	}
+}
+
+func TestConsolidateURLHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ip": "2001:db8::7"}`)
+	}))
+	defer server.Close()
+
+	securityGroup := SecurityGroup{
+		IpPermissions: []IpPermission{
+			{
+				Hosts: []Host{
+					{Description: aws.String("Office router"), JSONPath: aws.String("ip"), URL: aws.String(server.URL)},
+				},
+			},
+		},
+	}
+
+	resolvedHostAddresses := securityGroup.consolidateHostsAndIpRanges(securityGroup.IpPermissions, NewHostResolver(nil, time.Now))
+
+	assert.Equal(t, []string{"2001:db8::7"}, resolvedHostAddresses)
+	assert.Equal(t, []Ipv6Range{
+		{CidrIpv6: aws.String("2001:db8::7/128"), Description: aws.String("Office router")},
+	}, securityGroup.IpPermissions[0].Ipv6Ranges)
+}
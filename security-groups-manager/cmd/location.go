@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ConfigurationLocation is where an element of the configuration starts in the marshaled configuration
+type ConfigurationLocation struct {
+	Column int
+	Line   int
+	Path   string
+}
+
+// locate sets the location of every configured security group and IpPermission. Locations are left unset if the
+// marshaled configuration can't be scanned.
+func (c *Configuration) locate(marshaledConfiguration []byte) {
+	offsets, err := scanJSONOffsets(marshaledConfiguration)
+	if err != nil {
+		logger.Warnf("Unable to locate configuration elements: %v", err)
+
+		return
+	}
+
+	newLocation := func(path string) *ConfigurationLocation {
+		offset, ok := offsets[strings.ToLower(path)]
+		if !ok {
+			return nil
+		}
+
+		line := bytes.Count(marshaledConfiguration[:offset], []byte("\n")) + 1
+		lineStart := bytes.LastIndexByte(marshaledConfiguration[:offset], '\n') + 1
+
+		return &ConfigurationLocation{
+			Column: utf8.RuneCount(marshaledConfiguration[lineStart:offset]) + 1,
+			Line:   line,
+			Path:   path,
+		}
+	}
+
+	for i := range c.SecurityGroups {
+		securityGroup := &c.SecurityGroups[i]
+		securityGroupPath := fmt.Sprintf("SecurityGroups[%d]", i)
+
+		securityGroup.location = newLocation(securityGroupPath)
+
+		for j := range securityGroup.IpPermissions {
+			securityGroup.IpPermissions[j].location = newLocation(fmt.Sprintf("%s.IpPermissions[%d]", securityGroupPath, j))
+		}
+		for j := range securityGroup.IpPermissionsEgress {
+			securityGroup.IpPermissionsEgress[j].location = newLocation(fmt.Sprintf("%s.IpPermissionsEgress[%d]", securityGroupPath, j))
+		}
+	}
+}
+
+// scanJSONOffsets returns the offset of every object and array in a JSON document by its path, such as
+// "securitygroups[0].ippermissions[1]". Keys are lower cased as encoding/json matches them case-insensitively.
+func scanJSONOffsets(b []byte) (map[string]int, error) {
+	offsets := make(map[string]int)
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+
+	type container struct {
+		expected string
+		index    int
+		isArray  bool
+		key      string
+		path     string
+	}
+
+	var containers []*container
+
+	// valuePath returns the path of the value about to be read in the innermost container
+	valuePath := func() string {
+		if len(containers) == 0 {
+			return ""
+		}
+
+		parent := containers[len(containers)-1]
+		if parent.isArray {
+			return fmt.Sprintf("%s[%d]", parent.path, parent.index)
+		}
+		if parent.path == "" {
+			return parent.key
+		}
+
+		return parent.path + "." + parent.key
+	}
+
+	// valueRead moves the innermost container past the value just read
+	valueRead := func() {
+		if len(containers) == 0 {
+			return
+		}
+
+		parent := containers[len(containers)-1]
+		if parent.isArray {
+			parent.index++
+		} else {
+			parent.expected = "key"
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return offsets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(containers) > 0 {
+			if parent := containers[len(containers)-1]; !parent.isArray && parent.expected == "key" {
+				if key, ok := token.(string); ok {
+					parent.key = strings.ToLower(key)
+					parent.expected = "value"
+
+					continue
+				}
+			}
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			path := valuePath()
+			offsets[path] = int(decoder.InputOffset()) - 1
+
+			containers = append(containers, &container{expected: "key", isArray: token == json.Delim('['), path: path})
+		case json.Delim('}'), json.Delim(']'):
+			containers = containers[:len(containers)-1]
+
+			valueRead()
+		default:
+			valueRead()
+		}
+	}
+}
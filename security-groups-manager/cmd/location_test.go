@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigurationLocate(t *testing.T) {
+	configuration, err := NewConfiguration(`{
+  "SecurityGroups": [
+    {
+      "GroupId": "sg-1",
+      "Tags": [{"Key": "Name", "Value": "[{web}]"}],
+      "IpPermissions": [
+        {"IpProtocol": "tcp", "FromPort": 22, "ToPort": 22, "IpRanges": [{"CidrIp": "0.0.0.0/0"}]},
+        {"IpProtocol": "tcp", "FromPort": 443, "ToPort": 443}
+      ]
+    },
+    {"GroupId": "sg-2", "ipPermissionsEgress": [
+      {"IpProtocol": "-1", "Description": "é"}, {"IpProtocol": "-1"}]}
+  ]
+}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, &ConfigurationLocation{Column: 5, Line: 3, Path: "SecurityGroups[0]"}, configuration.SecurityGroups[0].location)
+	assert.Equal(t, &ConfigurationLocation{Column: 9, Line: 7, Path: "SecurityGroups[0].IpPermissions[0]"}, configuration.SecurityGroups[0].IpPermissions[0].location)
+	assert.Equal(t, &ConfigurationLocation{Column: 9, Line: 8, Path: "SecurityGroups[0].IpPermissions[1]"}, configuration.SecurityGroups[0].IpPermissions[1].location)
+	assert.Equal(t, &ConfigurationLocation{Column: 5, Line: 11, Path: "SecurityGroups[1]"}, configuration.SecurityGroups[1].location)
+	assert.Equal(t, &ConfigurationLocation{Column: 49, Line: 12, Path: "SecurityGroups[1].IpPermissionsEgress[1]"}, configuration.SecurityGroups[1].IpPermissionsEgress[1].location, "Keys are matched case-insensitively and columns count code points")
+
+	policyViolations := NewPolicy(nil).lint(configuration.SecurityGroups[0])
+	if assert.Len(t, policyViolations, 1) {
+		assert.Equal(t, configuration.SecurityGroups[0].IpPermissions[0].location, policyViolations[0].location)
+	}
+}
+
+func TestScanJSONOffsets(t *testing.T) {
+	offsets, err := scanJSONOffsets([]byte(`{"a": [1, {"B": {}}], "c": "{"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"": 0, "a": 6, "a[1]": 10, "a[1].b": 16}, offsets)
+
+	_, err = scanJSONOffsets([]byte(`{"a": [}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFormatEnvironmentVariableName = "LOG_FORMAT"
+	logLevelEnvironmentVariableName  = "LOG_LEVEL"
+)
+
+const (
+	jsonLogFormat = "json"
+	textLogFormat = "text"
+)
+
+const (
+	debugLevel = iota
+	infoLevel
+	warnLevel
+	errorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+type logField struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger writes leveled log records, one per line, either as text or as JSON. Fields added with with are included in
+// every record written by the returned Logger
+type Logger struct {
+	Fields []logField
+	Format string
+	Level  int
+	Mutex  *sync.Mutex
+	Now    func() time.Time
+	Output io.Writer
+}
+
+var logger = NewLogger(textLogFormat, infoLevel, os.Stderr)
+
+func NewLogger(format string, level int, output io.Writer) *Logger {
+	logger := new(Logger)
+
+	logger.Fields = make([]logField, 0)
+	logger.Format = format
+	logger.Level = level
+	logger.Mutex = new(sync.Mutex)
+	logger.Now = time.Now
+	logger.Output = output
+
+	return logger
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.log(debugLevel, format, v...)
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.log(errorLevel, format, v...)
+}
+
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.log(infoLevel, format, v...)
+}
+
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.log(warnLevel, format, v...)
+}
+
+// with returns a copy of the Logger that adds key to every record. A key that's already set is replaced
+func (l *Logger) with(key string, value interface{}) *Logger {
+	withLogger := *l
+
+	withLogger.Fields = append(make([]logField, 0, len(l.Fields)+1), l.Fields...)
+	for i := range withLogger.Fields {
+		if withLogger.Fields[i].Key == key {
+			withLogger.Fields[i].Value = value
+
+			return &withLogger
+		}
+	}
+	withLogger.Fields = append(withLogger.Fields, logField{Key: key, Value: value})
+
+	return &withLogger
+}
+
+func (l *Logger) log(level int, format string, v ...interface{}) {
+	if level < l.Level {
+		return
+	}
+
+	var buffer bytes.Buffer
+
+	message := fmt.Sprintf(format, v...)
+	timestamp := l.Now().UTC().Format(time.RFC3339Nano)
+
+	if l.Format == jsonLogFormat {
+		buffer.WriteString(`{"time":`)
+		writeJSONValue(&buffer, timestamp)
+		buffer.WriteString(`,"level":`)
+		writeJSONValue(&buffer, levelNames[level])
+		buffer.WriteString(`,"message":`)
+		writeJSONValue(&buffer, message)
+		for _, field := range l.Fields {
+			buffer.WriteString(",")
+			writeJSONValue(&buffer, field.Key)
+			buffer.WriteString(":")
+			writeJSONValue(&buffer, field.Value)
+		}
+		buffer.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&buffer, "%s %-5s %s", timestamp, strings.ToUpper(levelNames[level]), message)
+		for _, field := range l.Fields {
+			fmt.Fprintf(&buffer, " %s=%s", field.Key, quoteLogValue(fmt.Sprint(field.Value)))
+		}
+		buffer.WriteString("\n")
+	}
+
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+
+	l.Output.Write(buffer.Bytes())
+}
+
+func writeJSONValue(buffer *bytes.Buffer, value interface{}) {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	buffer.Write(b)
+}
+
+func quoteLogValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return strconv.Quote(value)
+	}
+
+	return value
+}
+
+func parseLogLevel(level string) (int, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return debugLevel, nil
+	case "info":
+		return infoLevel, nil
+	case "warn", "warning":
+		return warnLevel, nil
+	case "error":
+		return errorLevel, nil
+	default:
+		return infoLevel, fmt.Errorf("unsupported log level %s", level)
+	}
+}
+
+// initLogger configures a Logger from LOG_FORMAT and LOG_LEVEL. DEBUG=true is still honoured when LOG_LEVEL isn't set
+func initLogger() *Logger {
+	format := lookupOptionalEnvironmentVariable(logFormatEnvironmentVariableName, textLogFormat)
+	if format != jsonLogFormat && format != textLogFormat {
+		logger.Warnf("Unable to parse %s environment variable: unsupported log format %s", logFormatEnvironmentVariableName, format)
+
+		format = textLogFormat
+	}
+
+	defaultLevel := levelNames[infoLevel]
+	if lookupOptionalBoolEnvironmentVariable(debugEnvironmentVariableName, false) {
+		defaultLevel = levelNames[debugLevel]
+	}
+
+	level, err := parseLogLevel(lookupOptionalEnvironmentVariable(logLevelEnvironmentVariableName, defaultLevel))
+	if err != nil {
+		logger.Warnf("Unable to parse %s environment variable: %v", logLevelEnvironmentVariableName, err)
+	}
+
+	return NewLogger(format, level, os.Stderr)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{
+			name:   "Text",
+			format: textLogFormat,
+			want: "2021-09-01T12:00:00Z INFO  Applying remediations group_id=sg-1 region=eu-west-1\n" +
+				"2021-09-01T12:00:00Z ERROR Failed to revoke inbound rules: throttled group_id=sg-1 region=eu-west-1 operation=RevokeSecurityGroupIngress result=failed\n" +
+				"2021-09-01T12:00:00Z WARN  Host changed address group_id=sg-2 region=eu-west-1 host=\"my host\"\n",
+		},
+		{
+			name:   "JSON",
+			format: jsonLogFormat,
+			want: `{"time":"2021-09-01T12:00:00Z","level":"info","message":"Applying remediations","group_id":"sg-1","region":"eu-west-1"}` + "\n" +
+				`{"time":"2021-09-01T12:00:00Z","level":"error","message":"Failed to revoke inbound rules: throttled","group_id":"sg-1","region":"eu-west-1","operation":"RevokeSecurityGroupIngress","result":"failed"}` + "\n" +
+				`{"time":"2021-09-01T12:00:00Z","level":"warn","message":"Host changed address","group_id":"sg-2","region":"eu-west-1","host":"my host"}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+
+			testLogger := NewLogger(test.format, infoLevel, &buffer)
+			testLogger.Now = func() time.Time { return now }
+
+			securityGroupLogger := testLogger.with("group_id", "sg-1").with("region", "eu-west-1")
+			securityGroupLogger.Debugf("Not written")
+			securityGroupLogger.Infof("Applying remediations")
+			securityGroupLogger.with("operation", "RevokeSecurityGroupIngress").with("result", "failed").Errorf("Failed to revoke inbound rules: %v", fmt.Errorf("throttled"))
+			securityGroupLogger.with("group_id", "sg-2").with("host", "my host").Warnf("Host changed address")
+
+			assert.Equal(t, test.want, buffer.String())
+		})
+	}
+}
+
+func TestSecurityGroupDeltaLoggersCarryTheRunId(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	var buffer bytes.Buffer
+
+	runLogger := NewLogger(textLogFormat, infoLevel, &buffer)
+	runLogger.Now = func() time.Time { return now }
+
+	for _, runId := range []string{"run-1", "run-2"} {
+		controller := NewController(nil)
+		controller.Logger = runLogger.with("run_id", runId)
+		controller.RunId = runId
+		controller.ToBeSecurityGroups = []types.SecurityGroup{{GroupId: aws.String("sg-1"), VpcId: aws.String("vpc-1")}}
+		controller.CalculateSecurityGroupDeltas()
+
+		controller.SecurityGroupDeltas[0].logger().Infof("Applying remediations")
+	}
+
+	assert.Equal(t, "2021-09-01T12:00:00Z INFO  Calculating security group deltas run_id=run-1\n"+
+		"2021-09-01T12:00:00Z INFO  Calculated security group deltas run_id=run-1\n"+
+		"2021-09-01T12:00:00Z INFO  Applying remediations run_id=run-1 group_id=sg-1 region=\"\"\n"+
+		"2021-09-01T12:00:00Z INFO  Calculating security group deltas run_id=run-2\n"+
+		"2021-09-01T12:00:00Z INFO  Calculated security group deltas run_id=run-2\n"+
+		"2021-09-01T12:00:00Z INFO  Applying remediations run_id=run-2 group_id=sg-1 region=\"\"\n", buffer.String())
+	assert.Empty(t, logger.Fields, "Runs don't change the global logger")
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for name, want := range map[string]int{"debug": debugLevel, "INFO": infoLevel, "warning": warnLevel, "error": errorLevel} {
+		level, err := parseLogLevel(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, level)
+	}
+
+	_, err := parseLogLevel("verbose")
+	assert.Error(t, err)
+}
@@ -1,48 +1,409 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	lambdaservice "github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaservicetypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+)
+
+const (
+	applyCommand               = "apply"
+	historyCommand             = "history"
+	planCommand                = "plan"
+	serveCommand               = "serve"
+	serveAccessRequestsCommand = "serve-access-requests"
+	validateCommand            = "validate"
+	watchCommand               = "watch"
 )
 
 var executionEnvironment = new(ExecutionEnvironment)
 
-func debugf(format string, v ...interface{}) {
-	if executionEnvironment.DoDebug {
-		log.Printf(format, v...)
-	}
-}
+// Serializes reconciliations triggered by concurrent access requests, the daemon and configuration reloads
+var reconcileMutex sync.Mutex
+
+// Tracks the reconciliations access requests triggered in the background, so that a shutdown can wait for them
+var backgroundReconciliations sync.WaitGroup
 
 func execute() (*Controller, error) {
-	if !executionEnvironment.IsLambda {
+	return executeCommand(applyCommand)
+}
+
+func executeCommand(command string) (*Controller, error) {
+	return executeCommandOn(command, nil)
+}
+
+// executeCommandOn reconciles only the configured security groups in securityGroupRegionNames, describing them in
+// their region only, or every configured security group when it's nil
+func executeCommandOn(command string, securityGroupRegionNames map[string]string) (*Controller, error) {
+	return executeRun(newRunId(), command, securityGroupRegionNames)
+}
+
+// executeRun is executeCommandOn identifying the run by runId in log records, reports, notifications, the recorded
+// run outcome and audit tags
+func executeRun(runId string, command string, securityGroupRegionNames map[string]string) (*Controller, error) {
+	if command != applyCommand && command != planCommand && command != validateCommand {
+		logger.Errorf("Unknown command %s, expected one of %s, %s or %s", command, validateCommand, planCommand, applyCommand)
+
+		return nil, fmt.Errorf("unknown command %s", command)
+	}
+
+	if !executionEnvironment.IsLambda && !executionEnvironment.IsLongRunning {
 		var err error
 
 		executionEnvironment, err = NewExecutionEnvironment(false)
 		if err != nil {
 			return nil, err
 		}
+
+		// Only what plan would change is of interest when running it by hand
+		if _, ok := os.LookupEnv(outputFormatEnvironmentVariableName); !ok && command == planCommand {
+			executionEnvironment.OutputFormat = diffOutputFormat
+		}
 	}
 
 	controller := NewController(executionEnvironment.Client)
-	err := controller.InitAsIsSecurityGroups()
+	controller.Logger = logger.with("run_id", runId)
+	controller.RunId = runId
+	controller.StateStore = executionEnvironment.StateStore
+
+	startedAt := controller.Now()
+
+	if command == validateCommand {
+		controller.InitToBeSecurityGroups(executionEnvironment.Configuration)
+
+		return controller, controller.ValidateToBeSecurityGroups()
+	}
+
+	configuration := executionEnvironment.Configuration
+
+	var err error
+	if securityGroupRegionNames == nil {
+		err = controller.InitAsIsSecurityGroups()
+	} else {
+		groupIds := make([]string, 0, len(securityGroupRegionNames))
+		for groupId := range securityGroupRegionNames {
+			groupIds = append(groupIds, groupId)
+		}
+
+		configuration = configuration.only(groupIds)
+		err = controller.InitAsIsSecurityGroupsIn(securityGroupRegionNames)
+	}
+	if err != nil {
+		prometheusRegistry.observeRun(command, err, nil, controller.Now())
+
+		return nil, err
+	}
+	err = controller.InitGrants(executionEnvironment.GrantStore)
 	if err != nil {
+		prometheusRegistry.observeRun(command, err, nil, controller.Now())
+
 		return nil, err
 	}
-	controller.InitToBeSecurityGroups(executionEnvironment.Configuration)
+	controller.InitToBeSecurityGroups(configuration)
 	controller.CalculateSecurityGroupDeltas()
-	controller.ProcessSecurityGroupDeltas()
+	controller.GuardSecurityGroupDeltas(executionEnvironment.Guard)
+	controller.ProcessSecurityGroupDeltas(command == applyCommand)
+	controller.RecordRunOutcome(command, startedAt)
+	controller.Notify(executionEnvironment.NotificationDispatcher, command)
+	prometheusRegistry.observeRun(command, nil, controller.SecurityGroupDeltas, controller.Now())
+
+	emitMetrics(controller, startedAt)
 
 	// Only needed by the Test functions
 	return controller, nil
 }
 
-func handler() error {
-	_, err := execute()
+func reconcileCommand(command string) error {
+	reconcileMutex.Lock()
+	defer reconcileMutex.Unlock()
+
+	_, err := executeCommand(command)
+
+	return err
+}
+
+// triggerReconcileCommand returns a function starting a reconciliation with command in the background
+func triggerReconcileCommand(command string) func() error {
+	return func() error {
+		backgroundReconciliations.Add(1)
+
+		go func() {
+			defer backgroundReconciliations.Done()
+
+			if err := reconcileCommand(command); err != nil {
+				logger.Errorf("Unable to reconcile after granting access: %v", err)
+			}
+		}()
+
+		return nil
+	}
+}
+
+// invokeReconciliation invokes the Lambda Function asynchronously with an event that isn't an API Gateway event, so the
+// reconciliation runs in its own invocation rather than within the API Gateway integration timeout
+func invokeReconciliation() error {
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	_, err := executionEnvironment.LambdaClient.Invoke(ctx, &lambdaservice.InvokeInput{
+		FunctionName:   aws.String(os.Getenv(awsLambdaFunctionNameEnvironmentVariableName)),
+		InvocationType: lambdaservicetypes.InvocationTypeEvent,
+		Payload:        []byte("{}"),
+	})
+
+	return err
+}
+
+// newAccessRequestHandler serves access requests with the access profiles of the current configuration, triggering the
+// reconciliation of granted access with triggerReconciliation
+func newAccessRequestHandler(triggerReconciliation func() error) *AccessRequestHandler {
+	return NewAccessRequestHandler(
+		executionEnvironment.Configuration.AccessProfiles,
+		executionEnvironment.GrantStore,
+		executionEnvironment.AccessRequestSecret,
+		executionEnvironment.AccessRequestToken,
+		triggerReconciliation,
+	)
+}
+
+// handler reconciles on scheduled events and serves access requests on API Gateway events
+func handler(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	if isApiGatewayEvent(event) {
+		return newAccessRequestHandler(invokeReconciliation).handleApiGatewayEvent(event)
+	}
+
+	// The request ID ties the run to the invocation in CloudWatch Logs and CloudTrail
+	runId := newRunId()
+	if lambdaContext, ok := lambdacontext.FromContext(ctx); ok && lambdaContext.AwsRequestID != "" {
+		runId = lambdaContext.AwsRequestID
+	}
+
+	_, err := executeRun(runId, applyCommand, nil)
+	if err != nil {
+		return nil, fmt.Errorf("execution failed")
+	}
+
+	return nil, nil
+}
+
+// showHistory outputs the recorded run outcomes, or the last applied state of the security group or the resolutions of
+// the host given as the next argument
+func showHistory() error {
+	logger = initLogger()
+
+	// Only the state store is needed, so the history can be read without a valid configuration
+	awsConfiguration, err := initAwsConfiguration()
+	if err != nil {
+		return err
+	}
+
+	subject := ""
+	if len(os.Args) > 2 {
+		subject = os.Args[2]
+	}
+
+	return printHistory(initStateStore(awsConfiguration), subject)
+}
+
+func serveAccessRequests() error {
+	var err error
+
+	executionEnvironment, err = NewExecutionEnvironment(false)
+	if err != nil {
+		return err
+	}
+
+	if !executionEnvironment.accessRequestsEnabled() {
+		logger.Infof("Serving access requests requires the %s environment variable and either the %s or %s environment variable", grantStorePathEnvironmentVariableName, accessRequestSecretEnvironmentVariableName, accessRequestTokenEnvironmentVariableName)
+
+		return fmt.Errorf("access requests are not enabled")
+	}
+
+	address := lookupOptionalEnvironmentVariable(accessRequestAddressEnvironmentVariableName, defaultAccessRequestAddress)
+
+	logger.Infof("Serving access requests on %s", address)
+
+	serveMux := http.NewServeMux()
+	serveMux.Handle("/", newAccessRequestHandler(triggerReconcileCommand(applyCommand)))
+	serveMux.Handle("/metrics", prometheusRegistry)
+
+	return http.ListenAndServe(address, serveMux)
+}
+
+// serve runs the daemon, reconciling with the command given as the next argument (apply by default), and serves
+// /healthz, /readyz, /metrics and, when enabled, access requests until SIGTERM or SIGINT
+func serve() error {
+	var err error
+
+	executionEnvironment, err = NewExecutionEnvironment(false)
 	if err != nil {
-		return fmt.Errorf("execution failed")
+		return err
+	}
+	executionEnvironment.IsLongRunning = true
+
+	command := applyCommand
+	if len(os.Args) > 2 {
+		command = os.Args[2]
 	}
+	if command != applyCommand && command != planCommand {
+		return fmt.Errorf("unknown command %s, expected %s or %s", command, planCommand, applyCommand)
+	}
+
+	daemon := NewDaemon(
+		lookupOptionalDurationEnvironmentVariable(reconcileIntervalEnvironmentVariableName, defaultReconcileInterval),
+		lookupOptionalDurationEnvironmentVariable(reconcileJitterEnvironmentVariableName, defaultReconcileJitter),
+		lookupOptionalEnvironmentVariable(configurationPathEnvironmentVariableName, ""),
+		func() error {
+			return reconcileCommand(command)
+		},
+		func(configuration *Configuration) {
+			reconcileMutex.Lock()
+			defer reconcileMutex.Unlock()
+
+			executionEnvironment.Configuration = configuration
+		},
+	)
+
+	server := &http.Server{
+		Addr:    lookupOptionalEnvironmentVariable(serveAddressEnvironmentVariableName, defaultServeAddress),
+		Handler: newDaemonServeMux(daemon, command),
+	}
+
+	serveErrors := make(chan error, 1)
+	go func() {
+		logger.Infof("Serving on %s", server.Addr)
+
+		serveErrors <- server.ListenAndServe()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	daemonDone := make(chan struct{})
+	go func() {
+		daemon.Run(ctx)
+		close(daemonDone)
+	}()
+
+	select {
+	case err = <-serveErrors:
+		stop()
+		<-daemonDone
+
+		return err
+	case <-daemonDone:
+	}
+
+	logger.Infof("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+
+	backgroundReconciliations.Wait()
+
+	return err
+}
+
+// newDaemonServeMux serves /healthz, /readyz, /metrics and, when enabled, access requests reconciled with the daemon's
+// command, so that a plan daemon never changes a security group
+func newDaemonServeMux(daemon *Daemon, command string) *http.ServeMux {
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/healthz", daemon.ServeHealthz)
+	serveMux.HandleFunc("/readyz", daemon.ServeReadyz)
+	serveMux.Handle("/metrics", prometheusRegistry)
+	if executionEnvironment.accessRequestsEnabled() {
+		serveMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			// Picks up access profiles of a reloaded configuration
+			reconcileMutex.Lock()
+			accessRequestHandler := newAccessRequestHandler(triggerReconcileCommand(command))
+			reconcileMutex.Unlock()
+
+			accessRequestHandler.ServeHTTP(w, r)
+		})
+	}
+
+	return serveMux
+}
+
+// watch reconciles every configured security group once, then only the security groups whose FQDN Hosts changed
+// address, looking the Hosts up again whenever their DNS TTL expires, until SIGTERM or SIGINT
+func watch() error {
+	var err error
+
+	executionEnvironment, err = NewExecutionEnvironment(false)
+	if err != nil {
+		return err
+	}
+	executionEnvironment.IsLongRunning = true
+
+	command := applyCommand
+	if len(os.Args) > 2 {
+		command = os.Args[2]
+	}
+	if command != applyCommand && command != planCommand {
+		return fmt.Errorf("unknown command %s, expected %s or %s", command, planCommand, applyCommand)
+	}
+
+	controller, err := executeCommand(command)
+	if err != nil {
+		return err
+	}
+	securityGroupRegionNames := controller.SecurityGroupIdRegionName
+
+	var hostWatcher *HostWatcher
+	hostWatcher = NewHostWatcher(executionEnvironment.Configuration, lookupHostWithTTL, func(groupIds []string) error {
+		reconcileMutex.Lock()
+		defer reconcileMutex.Unlock()
+
+		changedSecurityGroupRegionNames := make(map[string]string, len(groupIds))
+		for _, groupId := range groupIds {
+			regionName, ok := securityGroupRegionNames[groupId]
+			if !ok {
+				// Not found by the previous reconciliation, only a scan of every region can find it
+				changedSecurityGroupRegionNames = nil
+
+				break
+			}
+
+			changedSecurityGroupRegionNames[groupId] = regionName
+		}
+
+		controller, err := executeCommandOn(command, changedSecurityGroupRegionNames)
+		if err != nil {
+			return err
+		}
+
+		for groupId, regionName := range controller.SecurityGroupIdRegionName {
+			securityGroupRegionNames[groupId] = regionName
+		}
+		hostWatcher.seed(controller.HostAddresses)
+
+		return nil
+	})
+	// Changes between the first reconciliation and the first lookups are caught by comparing against what it resolved
+	hostWatcher.seed(controller.HostAddresses)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	hostWatcher.Run(ctx)
+
+	logger.Infof("Shutting down")
 
 	return nil
 }
@@ -51,6 +412,51 @@ func main() {
 	if executionEnvironment.IsLambda {
 		lambda.Start(handler)
 	} else {
-		handler()
+		command := applyCommand
+		if len(os.Args) > 1 {
+			command = os.Args[1]
+		}
+
+		if command == historyCommand {
+			if err := showHistory(); err != nil {
+				os.Exit(1)
+			}
+
+			return
+		}
+
+		if command == serveCommand {
+			if err := serve(); err != nil {
+				logger.Errorf("Unable to serve: %v", err)
+
+				os.Exit(1)
+			}
+
+			return
+		}
+
+		if command == watchCommand {
+			if err := watch(); err != nil {
+				logger.Errorf("Unable to watch: %v", err)
+
+				os.Exit(1)
+			}
+
+			return
+		}
+
+		if command == serveAccessRequestsCommand {
+			if err := serveAccessRequests(); err != nil {
+				logger.Errorf("Unable to serve access requests: %v", err)
+
+				os.Exit(1)
+			}
+
+			return
+		}
+
+		if _, err := executeCommand(command); err != nil {
+			os.Exit(1)
+		}
 	}
 }
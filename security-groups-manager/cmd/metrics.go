@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	emitMetricsEnvironmentVariableName      = "EMIT_METRICS"
+	metricsNamespaceEnvironmentVariableName = "METRICS_NAMESPACE"
+)
+
+const defaultMetricsNamespace = "SecurityGroupsManager"
+
+const (
+	countUnit        = "Count"
+	millisecondsUnit = "Milliseconds"
+)
+
+// securityGroupMetrics are the metrics of a single security group delta. Rules and tags are only counted once the
+// operation applying them succeeded
+type securityGroupMetrics struct {
+	DriftDetected    int
+	FailedOperations int
+	RulesAuthorized  int
+	RulesRevoked     int
+	RulesUpdated     int
+	TagsCreated      int
+	TagsDeleted      int
+}
+
+func (s *SecurityGroupDelta) metrics() securityGroupMetrics {
+	var metrics securityGroupMetrics
+
+	if s.status() != inSyncStatus && s.status() != notFoundStatus {
+		metrics.DriftDetected = 1
+	}
+
+	for _, result := range s.results() {
+		if strings.HasPrefix(result, "Failed") {
+			metrics.FailedOperations++
+		}
+	}
+
+	succeeded := func(result string) bool {
+		return strings.HasPrefix(result, "Succeeded")
+	}
+
+	if succeeded(s.IngressRulesToAuthorizeResult) {
+		metrics.RulesAuthorized += len(s.IngressRulesToAuthorize)
+	}
+	if succeeded(s.EgressRulesToAuthorizeResult) {
+		metrics.RulesAuthorized += len(s.EgressRulesToAuthorize)
+	}
+	if succeeded(s.IngressRulesToRevokeResult) {
+		metrics.RulesRevoked += len(s.IngressRulesToRevoke)
+	}
+	if succeeded(s.EgressRulesToRevokeResult) {
+		metrics.RulesRevoked += len(s.EgressRulesToRevoke)
+	}
+	if succeeded(s.IngressRulesToUpdateResult) {
+		metrics.RulesUpdated += len(s.IngressRulesToUpdate)
+	}
+	if succeeded(s.EgressRulesToUpdateResult) {
+		metrics.RulesUpdated += len(s.EgressRulesToUpdate)
+	}
+	if succeeded(s.RulesToModifyResult) {
+		metrics.RulesUpdated += len(s.RulesToModify)
+	}
+	if succeeded(s.TagsToCreateResult) {
+		metrics.TagsCreated += len(s.TagsToCreate)
+	}
+	if succeeded(s.TagsToDeleteResult) {
+		metrics.TagsDeleted += len(s.TagsToDelete)
+	}
+
+	return metrics
+}
+
+type emfMetric struct {
+	Name  string
+	Unit  string
+	Value float64
+}
+
+// newEMFDocument returns a CloudWatch Embedded Metric Format document. CloudWatch Logs extracts the metrics from the
+// log event without any API call
+func newEMFDocument(namespace string, timestamp time.Time, dimensions map[string]string, metrics []emfMetric) map[string]interface{} {
+	dimensionNames := make([]string, 0, len(dimensions))
+	for _, name := range []string{"Region", "GroupId"} {
+		if _, ok := dimensions[name]; ok {
+			dimensionNames = append(dimensionNames, name)
+		}
+	}
+
+	metricDefinitions := make([]map[string]string, 0, len(metrics))
+	document := make(map[string]interface{})
+
+	for _, metric := range metrics {
+		metricDefinitions = append(metricDefinitions, map[string]string{
+			"Name": metric.Name,
+			"Unit": metric.Unit,
+		})
+		document[metric.Name] = metric.Value
+	}
+
+	for name, value := range dimensions {
+		document[name] = value
+	}
+
+	document["_aws"] = map[string]interface{}{
+		"CloudWatchMetrics": []map[string]interface{}{
+			{
+				"Dimensions": [][]string{dimensionNames},
+				"Metrics":    metricDefinitions,
+				"Namespace":  namespace,
+			},
+		},
+		"Timestamp": timestamp.UnixNano() / int64(time.Millisecond),
+	}
+
+	return document
+}
+
+// emitMetrics writes the metrics of a run to standard error when EMIT_METRICS is true, keeping standard output for the
+// reports. CloudWatch Logs extracts them from either stream of a Lambda Function
+func emitMetrics(controller *Controller, startedAt time.Time) {
+	if !executionEnvironment.EmitMetrics {
+		return
+	}
+
+	controller.EmitMetrics(os.Stderr, executionEnvironment.MetricsNamespace, startedAt)
+}
+
+// EmitMetrics writes one EMF document per security group, with Region and GroupId dimensions, and one for the run
+func (c *Controller) EmitMetrics(w io.Writer, namespace string, startedAt time.Time) {
+	now := c.Now()
+
+	documents := make([]map[string]interface{}, 0, len(c.SecurityGroupDeltas)+1)
+	groupsInSync := 0
+	failedOperations := 0
+
+	for i := range c.SecurityGroupDeltas {
+		securityGroupDelta := &c.SecurityGroupDeltas[i]
+		metrics := securityGroupDelta.metrics()
+
+		if securityGroupDelta.status() == inSyncStatus {
+			groupsInSync++
+		}
+		failedOperations += metrics.FailedOperations
+
+		if securityGroupDelta.AsIsSecurityGroup == nil {
+			continue
+		}
+
+		documents = append(documents, newEMFDocument(namespace, now, map[string]string{
+			"GroupId": aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupId),
+			"Region":  securityGroupDelta.RegionName,
+		}, []emfMetric{
+			{Name: "DriftDetected", Unit: countUnit, Value: float64(metrics.DriftDetected)},
+			{Name: "FailedOperations", Unit: countUnit, Value: float64(metrics.FailedOperations)},
+			{Name: "RulesAuthorized", Unit: countUnit, Value: float64(metrics.RulesAuthorized)},
+			{Name: "RulesRevoked", Unit: countUnit, Value: float64(metrics.RulesRevoked)},
+			{Name: "RulesUpdated", Unit: countUnit, Value: float64(metrics.RulesUpdated)},
+			{Name: "TagsCreated", Unit: countUnit, Value: float64(metrics.TagsCreated)},
+			{Name: "TagsDeleted", Unit: countUnit, Value: float64(metrics.TagsDeleted)},
+		}))
+	}
+
+	documents = append(documents, newEMFDocument(namespace, now, map[string]string{}, []emfMetric{
+		{Name: "FailedOperations", Unit: countUnit, Value: float64(failedOperations)},
+		{Name: "GroupsEvaluated", Unit: countUnit, Value: float64(len(c.SecurityGroupDeltas))},
+		{Name: "GroupsInSync", Unit: countUnit, Value: float64(groupsInSync)},
+		{Name: "HostLookupFailures", Unit: countUnit, Value: float64(c.HostLookupFailures)},
+		{Name: "RunDuration", Unit: millisecondsUnit, Value: float64(now.Sub(startedAt).Milliseconds())},
+	}))
+
+	for _, document := range documents {
+		b, err := json.Marshal(document)
+		if err != nil {
+			logger.Errorf("Unable to marshal metrics: %v", err)
+
+			continue
+		}
+
+		fmt.Fprintln(w, string(b))
+	}
+}
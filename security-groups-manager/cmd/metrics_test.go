@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityGroupDeltaMetrics(t *testing.T) {
+	ssh := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 22, ToPort: 22, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.1/32"}
+	https := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: ipv4CidrSourceKind, Source: "0.0.0.0/0"}
+
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-1")})
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1")}
+	securityGroupDelta.IngressRulesToAuthorize = []Rule{ssh, https}
+	securityGroupDelta.IngressRulesToAuthorizeResult = "Succeeded to authorize inbound rules"
+	securityGroupDelta.IngressRulesToRevoke = []Rule{ssh}
+	securityGroupDelta.IngressRulesToRevokeResult = "Failed to revoke inbound rules: throttled"
+	securityGroupDelta.TagsToCreate = []types.Tag{{Key: aws.String("Name"), Value: aws.String("web")}}
+	securityGroupDelta.TagsToCreateResult = "Succeeded to create tags"
+
+	assert.Equal(t, securityGroupMetrics{
+		DriftDetected:    1,
+		FailedOperations: 1,
+		RulesAuthorized:  2,
+		TagsCreated:      1,
+	}, securityGroupDelta.metrics())
+}
+
+func TestEmitMetrics(t *testing.T) {
+	startedAt := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	inSyncSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-1")})
+	inSyncSecurityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1")}
+	inSyncSecurityGroupDelta.RegionName = "eu-west-1"
+
+	notFoundSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-2")})
+
+	controller := NewController(nil)
+	controller.HostLookupFailures = 2
+	controller.Now = func() time.Time { return startedAt.Add(1500 * time.Millisecond) }
+	controller.SecurityGroupDeltas = []SecurityGroupDelta{*inSyncSecurityGroupDelta, *notFoundSecurityGroupDelta}
+
+	var buffer bytes.Buffer
+	controller.EmitMetrics(&buffer, defaultMetricsNamespace, startedAt)
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	var securityGroupDocument map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &securityGroupDocument))
+	assert.Equal(t, "sg-1", securityGroupDocument["GroupId"])
+	assert.Equal(t, "eu-west-1", securityGroupDocument["Region"])
+	assert.Equal(t, float64(0), securityGroupDocument["DriftDetected"])
+
+	metadata := securityGroupDocument["_aws"].(map[string]interface{})
+	assert.Equal(t, float64(1630497601500), metadata["Timestamp"])
+	cloudWatchMetrics := metadata["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, defaultMetricsNamespace, cloudWatchMetrics["Namespace"])
+	assert.Equal(t, []interface{}{[]interface{}{"Region", "GroupId"}}, cloudWatchMetrics["Dimensions"])
+
+	var runDocument map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &runDocument))
+	assert.Equal(t, float64(2), runDocument["GroupsEvaluated"])
+	assert.Equal(t, float64(1), runDocument["GroupsInSync"])
+	assert.Equal(t, float64(2), runDocument["HostLookupFailures"])
+	assert.Equal(t, float64(1500), runDocument["RunDuration"])
+	assert.Equal(t, []interface{}{[]interface{}{}}, runDocument["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})["Dimensions"])
+}
+
+func TestEmitMetricsKeepsReportsParseable(t *testing.T) {
+	startedAt := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	stdout, err := ioutil.TempFile("", "stdout")
+	require.NoError(t, err)
+	defer os.Remove(stdout.Name())
+
+	stderr, err := ioutil.TempFile("", "stderr")
+	require.NoError(t, err)
+	defer os.Remove(stderr.Name())
+
+	originalExecutionEnvironment, originalStdout, originalStderr := executionEnvironment, os.Stdout, os.Stderr
+	defer func() {
+		executionEnvironment, os.Stdout, os.Stderr = originalExecutionEnvironment, originalStdout, originalStderr
+	}()
+
+	executionEnvironment = new(ExecutionEnvironment)
+	executionEnvironment.EmitMetrics = true
+	executionEnvironment.MetricsNamespace = defaultMetricsNamespace
+	executionEnvironment.OutputFormat = jsonOutputFormat
+	os.Stdout, os.Stderr = stdout, stderr
+
+	inSyncSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-1"), GroupName: aws.String("web")})
+	inSyncSecurityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1"), GroupName: aws.String("web")}
+
+	controller := NewController(nil)
+	controller.RunId = "run-1"
+	controller.SecurityGroupDeltas = []SecurityGroupDelta{*inSyncSecurityGroupDelta}
+	controller.ProcessSecurityGroupDeltas(false)
+	emitMetrics(controller, startedAt)
+
+	b, err := ioutil.ReadFile(stdout.Name())
+	require.NoError(t, err)
+
+	var report Report
+	assert.NoError(t, json.Unmarshal(b, &report), "Standard output holds only the report")
+	assert.Equal(t, "run-1", report.RunId)
+
+	b, err = ioutil.ReadFile(stderr.Name())
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"CloudWatchMetrics"`)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"inet.af/netaddr"
+)
+
+var canonicalIpProtocols = map[string]string{
+	"-1":     "-1",
+	"all":    "-1",
+	"1":      "icmp",
+	"icmp":   "icmp",
+	"6":      "tcp",
+	"tcp":    "tcp",
+	"17":     "udp",
+	"udp":    "udp",
+	"58":     "icmpv6",
+	"icmpv6": "icmpv6",
+}
+
+func normalizeCidr(cidr string) string {
+	prefix, err := netaddr.ParseIPPrefix(strings.TrimSpace(cidr))
+	if err != nil {
+		logger.Debugf("Unable to parse CIDR %s: %v", cidr, err)
+
+		return cidr
+	}
+
+	return prefix.Masked().String()
+}
+
+func normalizeIpProtocol(ipProtocol string) string {
+	ipProtocol = strings.ToLower(strings.TrimSpace(ipProtocol))
+
+	if canonicalIpProtocol, ok := canonicalIpProtocols[ipProtocol]; ok {
+		return canonicalIpProtocol
+	}
+
+	return ipProtocol
+}
+
+func normalizeIpPermission(ipPermission types.IpPermission) types.IpPermission {
+	normalizedIpPermission := types.IpPermission{
+		IpProtocol: aws.String(normalizeIpProtocol(aws.ToString(ipPermission.IpProtocol))),
+	}
+
+	switch *normalizedIpPermission.IpProtocol {
+	case "tcp", "udp":
+		normalizedIpPermission.FromPort = ipPermission.FromPort
+		normalizedIpPermission.ToPort = ipPermission.ToPort
+
+		if normalizedIpPermission.ToPort == nil {
+			normalizedIpPermission.ToPort = normalizedIpPermission.FromPort
+		}
+	case "icmp", "icmpv6":
+		normalizedIpPermission.FromPort = aws.Int32(-1)
+		normalizedIpPermission.ToPort = aws.Int32(-1)
+
+		if ipPermission.FromPort != nil {
+			normalizedIpPermission.FromPort = aws.Int32(*ipPermission.FromPort)
+		}
+		if ipPermission.ToPort != nil {
+			normalizedIpPermission.ToPort = aws.Int32(*ipPermission.ToPort)
+		}
+	}
+
+	for _, ipRange := range ipPermission.IpRanges {
+		normalizedIpPermission.IpRanges = append(normalizedIpPermission.IpRanges, types.IpRange{
+			CidrIp:      aws.String(normalizeCidr(aws.ToString(ipRange.CidrIp))),
+			Description: ipRange.Description,
+		})
+	}
+
+	for _, ipv6Range := range ipPermission.Ipv6Ranges {
+		normalizedIpPermission.Ipv6Ranges = append(normalizedIpPermission.Ipv6Ranges, types.Ipv6Range{
+			CidrIpv6:    aws.String(normalizeCidr(aws.ToString(ipv6Range.CidrIpv6))),
+			Description: ipv6Range.Description,
+		})
+	}
+
+	normalizedIpPermission.PrefixListIds = append(normalizedIpPermission.PrefixListIds, ipPermission.PrefixListIds...)
+	normalizedIpPermission.UserIdGroupPairs = append(normalizedIpPermission.UserIdGroupPairs, ipPermission.UserIdGroupPairs...)
+
+	return normalizedIpPermission
+}
+
+func normalizeIpPermissions(ipPermissions []types.IpPermission) []types.IpPermission {
+	normalizedIpPermissions := make([]types.IpPermission, 0, len(ipPermissions))
+
+	for _, ipPermission := range ipPermissions {
+		normalizedIpPermission := normalizeIpPermission(ipPermission)
+		merged := false
+
+		for i := range normalizedIpPermissions {
+			existingIpPermission := &normalizedIpPermissions[i]
+
+			if *existingIpPermission.IpProtocol == *normalizedIpPermission.IpProtocol &&
+				compareInt32Pointers(existingIpPermission.FromPort, normalizedIpPermission.FromPort) == 0 &&
+				compareInt32Pointers(existingIpPermission.ToPort, normalizedIpPermission.ToPort) == 0 {
+				mergeIpPermission(existingIpPermission, normalizedIpPermission)
+
+				merged = true
+
+				break
+			}
+		}
+
+		if !merged {
+			deduplicatedIpPermission := types.IpPermission{
+				FromPort:   normalizedIpPermission.FromPort,
+				IpProtocol: normalizedIpPermission.IpProtocol,
+				ToPort:     normalizedIpPermission.ToPort,
+			}
+			mergeIpPermission(&deduplicatedIpPermission, normalizedIpPermission)
+
+			normalizedIpPermissions = append(normalizedIpPermissions, deduplicatedIpPermission)
+		}
+	}
+
+	return normalizedIpPermissions
+}
+
+func normalizeSecurityGroup(securityGroup *types.SecurityGroup) {
+	securityGroup.IpPermissions = normalizeIpPermissions(securityGroup.IpPermissions)
+	securityGroup.IpPermissionsEgress = normalizeIpPermissions(securityGroup.IpPermissionsEgress)
+}
+
+func mergeIpPermission(ipPermission *types.IpPermission, otherIpPermission types.IpPermission) {
+	for _, otherIpRange := range otherIpPermission.IpRanges {
+		ipRangeFound := false
+
+		for _, ipRange := range ipPermission.IpRanges {
+			if *ipRange.CidrIp == *otherIpRange.CidrIp {
+				ipRangeFound = true
+
+				break
+			}
+		}
+
+		if !ipRangeFound {
+			ipPermission.IpRanges = append(ipPermission.IpRanges, otherIpRange)
+		}
+	}
+
+	for _, otherIpv6Range := range otherIpPermission.Ipv6Ranges {
+		ipv6RangeFound := false
+
+		for _, ipv6Range := range ipPermission.Ipv6Ranges {
+			if *ipv6Range.CidrIpv6 == *otherIpv6Range.CidrIpv6 {
+				ipv6RangeFound = true
+
+				break
+			}
+		}
+
+		if !ipv6RangeFound {
+			ipPermission.Ipv6Ranges = append(ipPermission.Ipv6Ranges, otherIpv6Range)
+		}
+	}
+
+	for _, otherPrefixListId := range otherIpPermission.PrefixListIds {
+		prefixListIdFound := false
+
+		for _, prefixListId := range ipPermission.PrefixListIds {
+			if *prefixListId.PrefixListId == *otherPrefixListId.PrefixListId {
+				prefixListIdFound = true
+
+				break
+			}
+		}
+
+		if !prefixListIdFound {
+			ipPermission.PrefixListIds = append(ipPermission.PrefixListIds, otherPrefixListId)
+		}
+	}
+
+	for _, otherUserIdGroupPair := range otherIpPermission.UserIdGroupPairs {
+		userIdGroupPairFound := false
+
+		for _, userIdGroupPair := range ipPermission.UserIdGroupPairs {
+			// A configured pair may reference its security group by GroupName only
+			if aws.ToString(userIdGroupPair.GroupId) == aws.ToString(otherUserIdGroupPair.GroupId) &&
+				aws.ToString(userIdGroupPair.GroupName) == aws.ToString(otherUserIdGroupPair.GroupName) &&
+				aws.ToString(userIdGroupPair.UserId) == aws.ToString(otherUserIdGroupPair.UserId) {
+				userIdGroupPairFound = true
+
+				break
+			}
+		}
+
+		if !userIdGroupPairFound {
+			ipPermission.UserIdGroupPairs = append(ipPermission.UserIdGroupPairs, otherUserIdGroupPair)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeCidr(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want string
+	}{
+		{"10.0.0.0/24", "10.0.0.0/24"},
+		{"10.0.0.5/24", "10.0.0.0/24"},
+		{" 192.168.1.1/32 ", "192.168.1.1/32"},
+		{"2001:DB8:0:0:0:0:0:1/128", "2001:db8::1/128"},
+		{"2001:db8::ffff/64", "2001:db8::/64"},
+		{"not-a-cidr", "not-a-cidr"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, normalizeCidr(test.cidr), test.cidr)
+	}
+}
+
+func TestNormalizeIpProtocol(t *testing.T) {
+	tests := []struct {
+		ipProtocol string
+		want       string
+	}{
+		{"6", "tcp"},
+		{"TCP", "tcp"},
+		{"17", "udp"},
+		{"1", "icmp"},
+		{"58", "icmpv6"},
+		{"all", "-1"},
+		{"-1", "-1"},
+		{"55", "55"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, normalizeIpProtocol(test.ipProtocol), test.ipProtocol)
+	}
+}
+
+func TestNormalizeIpPermissions(t *testing.T) {
+	ipPermissions := []types.IpPermission{
+		{
+			FromPort:   aws.Int32(22),
+			IpProtocol: aws.String("6"),
+			IpRanges: []types.IpRange{
+				{CidrIp: aws.String("10.0.0.5/24"), Description: aws.String("Office")},
+			},
+			ToPort: aws.Int32(22),
+		},
+		{
+			FromPort:   aws.Int32(22),
+			IpProtocol: aws.String("tcp"),
+			IpRanges: []types.IpRange{
+				{CidrIp: aws.String("10.0.0.0/24"), Description: aws.String("Duplicate")},
+				{CidrIp: aws.String("10.0.1.0/24")},
+			},
+			ToPort: aws.Int32(22),
+		},
+		{
+			FromPort:   aws.Int32(0),
+			IpProtocol: aws.String("all"),
+			Ipv6Ranges: []types.Ipv6Range{
+				{CidrIpv6: aws.String("2001:DB8::/32")},
+			},
+			ToPort: aws.Int32(65535),
+		},
+	}
+
+	assert.Equal(t, []types.IpPermission{
+		{
+			FromPort:   aws.Int32(22),
+			IpProtocol: aws.String("tcp"),
+			IpRanges: []types.IpRange{
+				{CidrIp: aws.String("10.0.0.0/24"), Description: aws.String("Office")},
+				{CidrIp: aws.String("10.0.1.0/24")},
+			},
+			ToPort: aws.Int32(22),
+		},
+		{
+			IpProtocol: aws.String("-1"),
+			Ipv6Ranges: []types.Ipv6Range{
+				{CidrIpv6: aws.String("2001:db8::/32")},
+			},
+		},
+	}, normalizeIpPermissions(ipPermissions))
+}
+
+func TestNormalizeIpPermissionsWithGroupNameOnlyUserIdGroupPairs(t *testing.T) {
+	ipPermissions := []types.IpPermission{
+		{
+			FromPort:         aws.Int32(5432),
+			IpProtocol:       aws.String("tcp"),
+			ToPort:           aws.Int32(5432),
+			UserIdGroupPairs: []types.UserIdGroupPair{{GroupName: aws.String("web")}},
+		},
+		{
+			FromPort:         aws.Int32(5432),
+			IpProtocol:       aws.String("tcp"),
+			ToPort:           aws.Int32(5432),
+			UserIdGroupPairs: []types.UserIdGroupPair{{GroupName: aws.String("web")}, {GroupName: aws.String("worker")}},
+		},
+	}
+
+	assert.Equal(t, []types.IpPermission{
+		{
+			FromPort:         aws.Int32(5432),
+			IpProtocol:       aws.String("tcp"),
+			ToPort:           aws.Int32(5432),
+			UserIdGroupPairs: []types.UserIdGroupPair{{GroupName: aws.String("web")}, {GroupName: aws.String("worker")}},
+		},
+	}, normalizeIpPermissions(ipPermissions))
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+const (
+	notificationDeduplicationPeriodEnvironmentVariableName = "NOTIFICATION_DEDUPLICATION_PERIOD"
+	notificationSlackWebhookUrlEnvironmentVariableName     = "NOTIFICATION_SLACK_WEBHOOK_URL"
+	notificationSnsTopicArnEnvironmentVariableName         = "NOTIFICATION_SNS_TOPIC_ARN"
+	notificationTeamsWebhookUrlEnvironmentVariableName     = "NOTIFICATION_TEAMS_WEBHOOK_URL"
+	notificationTemplateEnvironmentVariableName            = "NOTIFICATION_TEMPLATE"
+	notificationWebhookUrlEnvironmentVariableName          = "NOTIFICATION_WEBHOOK_URL"
+)
+
+const (
+	jsonWebhookFormat  = "json"
+	slackWebhookFormat = "slack"
+	teamsWebhookFormat = "teams"
+)
+
+const defaultNotificationDeduplicationPeriod = time.Hour
+
+// Notification records are kept well beyond any sensible deduplication period
+const notificationRecordRetention = 30 * 24 * time.Hour
+
+const notificationRequestTimeout = 10 * time.Second
+
+const defaultNotificationTemplate = `{{range .SecurityGroups}}{{.GroupName}} ({{.GroupId}}{{if .RegionName}}, {{.RegionName}}{{end}}) is {{.Status}}{{if .Failed}} and failed to apply{{end}}
+{{range .RulesToAuthorize}}  authorize {{.}}
+{{end}}{{range .RulesToRevoke}}  revoke {{.}}
+{{end}}{{range .RulesToUpdate}}  update {{.}}
+{{end}}{{range .TagsToCreate}}  create tag {{.}}
+{{end}}{{range .TagsToDelete}}  delete tag {{.}}
+{{end}}{{range .FailedResults}}  {{.}}
+{{end}}{{end}}{{if .RunId}}Run {{.RunId}}
+{{end}}`
+
+// Notification summarizes the security groups of a run that drifted or failed to apply
+type Notification struct {
+	Command        string
+	RunId          string
+	SecurityGroups []SecurityGroupNotification
+}
+
+type SecurityGroupNotification struct {
+	Failed           bool
+	FailedResults    []string
+	GroupId          string
+	GroupName        string
+	RegionName       string
+	RulesToAuthorize []string
+	RulesToRevoke    []string
+	RulesToUpdate    []string
+	Status           string
+	TagsToCreate     []string
+	TagsToDelete     []string
+}
+
+func NewSecurityGroupNotification(securityGroupDelta *SecurityGroupDelta) *SecurityGroupNotification {
+	securityGroupNotification := new(SecurityGroupNotification)
+
+	securityGroupNotification.Failed = securityGroupDelta.failed()
+	securityGroupNotification.FailedResults = make([]string, 0)
+	securityGroupNotification.GroupId = aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupId)
+	securityGroupNotification.GroupName = aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupName)
+	securityGroupNotification.RegionName = securityGroupDelta.RegionName
+	securityGroupNotification.RulesToAuthorize = make([]string, 0)
+	securityGroupNotification.RulesToRevoke = make([]string, 0)
+	securityGroupNotification.RulesToUpdate = make([]string, 0)
+	securityGroupNotification.Status = securityGroupDelta.status()
+	securityGroupNotification.TagsToCreate = make([]string, 0)
+	securityGroupNotification.TagsToDelete = make([]string, 0)
+
+	for _, result := range securityGroupDelta.results() {
+		if strings.HasPrefix(result, "Failed") {
+			securityGroupNotification.FailedResults = append(securityGroupNotification.FailedResults, result)
+		}
+	}
+
+	for _, rules := range [][]Rule{securityGroupDelta.IngressRulesToAuthorize, securityGroupDelta.EgressRulesToAuthorize} {
+		for _, rule := range rules {
+			securityGroupNotification.RulesToAuthorize = append(securityGroupNotification.RulesToAuthorize, summarizeRule(rule))
+		}
+	}
+	for _, rules := range [][]Rule{securityGroupDelta.IngressRulesToRevoke, securityGroupDelta.EgressRulesToRevoke} {
+		for _, rule := range rules {
+			securityGroupNotification.RulesToRevoke = append(securityGroupNotification.RulesToRevoke, summarizeRule(rule))
+		}
+	}
+	for _, rules := range [][]Rule{securityGroupDelta.IngressRulesToUpdate, securityGroupDelta.EgressRulesToUpdate} {
+		for _, rule := range rules {
+			securityGroupNotification.RulesToUpdate = append(securityGroupNotification.RulesToUpdate, summarizeRule(rule))
+		}
+	}
+	for _, ruleModification := range securityGroupDelta.RulesToModify {
+		securityGroupNotification.RulesToUpdate = append(securityGroupNotification.RulesToUpdate,
+			fmt.Sprintf("%s -> %s", summarizeRule(ruleModification.From), summarizeRule(ruleModification.To)))
+	}
+
+	securityGroupNotification.TagsToCreate = summarizeTags(securityGroupDelta.TagsToCreate)
+	securityGroupNotification.TagsToDelete = summarizeTags(securityGroupDelta.TagsToDelete)
+
+	return securityGroupNotification
+}
+
+// fingerprint identifies what a security group is notified about through a notifier. Failures are identified by their
+// operation only, as their error messages carry request IDs
+func (s *SecurityGroupNotification) fingerprint(command string, notifierName string) string {
+	failedOperations := make([]string, 0, len(s.FailedResults))
+	for _, failedResult := range s.FailedResults {
+		failedOperations = append(failedOperations, strings.SplitN(failedResult, ":", 2)[0])
+	}
+
+	b, _ := json.Marshal([]interface{}{notifierName, command, s.GroupId, s.Status, failedOperations, s.RulesToAuthorize, s.RulesToRevoke, s.RulesToUpdate, s.TagsToCreate, s.TagsToDelete})
+	hash := sha256.Sum256(b)
+
+	return hex.EncodeToString(hash[:])
+}
+
+// summarizeRule returns a rule on a single line, such as "inbound TCP 22 from 10.0.0.1/32 (Office)"
+func summarizeRule(rule Rule) string {
+	ipPermission := rule.ipPermission()
+
+	direction := "inbound"
+	preposition := "from"
+	if rule.Direction == egressDirection {
+		direction = "outbound"
+		preposition = "to"
+	}
+
+	summary := fmt.Sprintf("%s %s %s %s %s", direction, determineProtocol(ipPermission), determinePortRange(ipPermission), preposition, rule.Source)
+	if rule.Description != "" {
+		summary += fmt.Sprintf(" (%s)", rule.Description)
+	}
+
+	return summary
+}
+
+func summarizeTags(tags []types.Tag) []string {
+	summaries := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		summaries = append(summaries, fmt.Sprintf("%s=%s", aws.ToString(tag.Key), aws.ToString(tag.Value)))
+	}
+
+	return summaries
+}
+
+// isNotifiable returns true when the security group drifted, in which case its delta isn't empty, or failed to apply
+func isNotifiable(securityGroupDelta *SecurityGroupDelta) bool {
+	return securityGroupDelta.AsIsSecurityGroup != nil && (securityGroupDelta.hasChanges() || securityGroupDelta.failed())
+}
+
+func (n *Notification) subject() string {
+	failed := 0
+	for _, securityGroupNotification := range n.SecurityGroups {
+		if securityGroupNotification.Failed {
+			failed++
+		}
+	}
+
+	subject := fmt.Sprintf("SecurityGroupsManager %s: %d security groups drifted", n.Command, len(n.SecurityGroups))
+	if failed > 0 {
+		subject += fmt.Sprintf(", %d failed", failed)
+	}
+
+	return subject
+}
+
+// Notifier delivers a notification. subject and message are rendered, notification is there for structured payloads.
+// Name identifies the notifier in notification records and log records
+type Notifier interface {
+	Name() string
+	Notify(subject string, message string, notification *Notification) error
+}
+
+// WebhookNotifier posts notifications to an incoming webhook, either Slack compatible, Microsoft Teams or generic JSON
+type WebhookNotifier struct {
+	Format     string
+	HTTPClient *http.Client
+	URL        string
+}
+
+func NewWebhookNotifier(format string, url string) *WebhookNotifier {
+	webhookNotifier := new(WebhookNotifier)
+
+	webhookNotifier.Format = format
+	webhookNotifier.HTTPClient = &http.Client{Timeout: notificationRequestTimeout}
+	webhookNotifier.URL = url
+
+	return webhookNotifier
+}
+
+// escapeSlackText escapes the characters Slack's mrkdwn reserves, and follows every backtick with a zero width space so
+// that text can't close the code block it's placed in
+func escapeSlackText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "`", "`\u200b").Replace(text)
+}
+
+func (w *WebhookNotifier) Name() string {
+	return w.Format + "-webhook"
+}
+
+func (w *WebhookNotifier) Notify(subject string, message string, notification *Notification) error {
+	var payload interface{}
+
+	switch w.Format {
+	case slackWebhookFormat:
+		payload = map[string]string{
+			"text": fmt.Sprintf("*%s*\n```\n%s```", escapeSlackText(subject), escapeSlackText(message)),
+		}
+	case teamsWebhookFormat:
+		payload = map[string]string{
+			"@context": "https://schema.org/extensions",
+			"@type":    "MessageCard",
+			"summary":  subject,
+			"text":     fmt.Sprintf("<pre>%s</pre>", template.HTMLEscapeString(message)),
+			"title":    subject,
+		}
+	default:
+		payload = struct {
+			*Notification
+			Message string
+			Subject string
+		}{notification, message, subject}
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	response, err := w.HTTPClient.Post(w.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s webhook responded with status %d", w.Format, response.StatusCode)
+	}
+
+	return nil
+}
+
+// NotificationDispatcher renders the notification of a run and hands it to every notifier. A security group is only
+// notified about again once DeduplicationPeriod passed, unless what it's notified about changed
+type NotificationDispatcher struct {
+	DeduplicationPeriod time.Duration
+	Mutex               sync.Mutex
+	NotificationRecords map[string]NotificationRecord
+	Notifiers           []Notifier
+	Now                 func() time.Time
+	Template            *template.Template
+}
+
+func NewNotificationDispatcher(notifiers []Notifier, template *template.Template, deduplicationPeriod time.Duration) *NotificationDispatcher {
+	notificationDispatcher := new(NotificationDispatcher)
+
+	notificationDispatcher.DeduplicationPeriod = deduplicationPeriod
+	notificationDispatcher.NotificationRecords = make(map[string]NotificationRecord)
+	notificationDispatcher.Notifiers = notifiers
+	notificationDispatcher.Now = time.Now
+	notificationDispatcher.Template = template
+
+	return notificationDispatcher
+}
+
+// dispatch notifies every notifier about the security groups that drifted or failed to apply. Each notifier keeps its
+// own notification records, so a notifier that failed is retried on the next run while the others stay deduplicated.
+// Notification records are kept in the state store when there's one, so deduplication holds across invocations, and in
+// memory otherwise
+func (n *NotificationDispatcher) dispatch(command string, runId string, securityGroupDeltas []SecurityGroupDelta, stateStore StateStore) {
+	n.Mutex.Lock()
+	defer n.Mutex.Unlock()
+
+	now := n.Now()
+	runLogger := logger.with("run_id", runId)
+
+	for _, notifier := range n.Notifiers {
+		notification := &Notification{
+			Command:        command,
+			RunId:          runId,
+			SecurityGroups: make([]SecurityGroupNotification, 0),
+		}
+		notificationRecords := make([]NotificationRecord, 0)
+
+		for i := range securityGroupDeltas {
+			securityGroupDelta := &securityGroupDeltas[i]
+			if !isNotifiable(securityGroupDelta) {
+				continue
+			}
+
+			securityGroupNotification := NewSecurityGroupNotification(securityGroupDelta)
+			fingerprint := securityGroupNotification.fingerprint(command, notifier.Name())
+
+			notificationRecord, err := n.getNotificationRecord(fingerprint, stateStore)
+			if err != nil {
+				securityGroupDelta.logger().with("notifier", notifier.Name()).Errorf("Unable to get notification record: %v", err)
+			}
+			if notificationRecord != nil && now.Sub(notificationRecord.NotifiedAt) < n.DeduplicationPeriod {
+				securityGroupDelta.logger().with("notifier", notifier.Name()).Debugf("Already notified at %s", notificationRecord.NotifiedAt.Format(time.RFC3339))
+
+				continue
+			}
+
+			notification.SecurityGroups = append(notification.SecurityGroups, *securityGroupNotification)
+			notificationRecords = append(notificationRecords, NotificationRecord{
+				Fingerprint: fingerprint,
+				GroupId:     securityGroupNotification.GroupId,
+				NotifiedAt:  now,
+				Notifier:    notifier.Name(),
+			})
+		}
+
+		n.notify(notifier, notification, notificationRecords, stateStore, runLogger.with("notifier", notifier.Name()))
+	}
+}
+
+// notify hands the notification to notifier and records it once delivered. Failed notifications are retried on the
+// next run
+func (n *NotificationDispatcher) notify(notifier Notifier, notification *Notification, notificationRecords []NotificationRecord, stateStore StateStore, notifierLogger *Logger) {
+	if len(notification.SecurityGroups) == 0 {
+		return
+	}
+
+	var message strings.Builder
+	if err := n.Template.Execute(&message, notification); err != nil {
+		notifierLogger.Errorf("Unable to render notification: %v", err)
+
+		return
+	}
+
+	if err := notifier.Notify(notification.subject(), message.String(), notification); err != nil {
+		notifierLogger.with("operation", "Notify").with("result", "failed").Errorf("Unable to notify: %v", err)
+
+		return
+	}
+
+	for _, notificationRecord := range notificationRecords {
+		n.NotificationRecords[notificationRecord.Fingerprint] = notificationRecord
+
+		if stateStore != nil {
+			if err := stateStore.PutNotificationRecord(notificationRecord); err != nil {
+				notifierLogger.with("group_id", notificationRecord.GroupId).Errorf("Unable to record notification: %v", err)
+			}
+		}
+	}
+}
+
+func (n *NotificationDispatcher) getNotificationRecord(fingerprint string, stateStore StateStore) (*NotificationRecord, error) {
+	if stateStore != nil {
+		return stateStore.GetNotificationRecord(fingerprint)
+	}
+
+	notificationRecord, ok := n.NotificationRecords[fingerprint]
+	if !ok {
+		return nil, nil
+	}
+
+	return &notificationRecord, nil
+}
+
+// initNotificationDispatcher returns nil unless at least one notifier is configured
+func initNotificationDispatcher(awsConfiguration aws.Config) *NotificationDispatcher {
+	notifiers := make([]Notifier, 0)
+
+	for _, webhook := range []struct {
+		environmentVariableName string
+		format                  string
+	}{
+		{notificationWebhookUrlEnvironmentVariableName, jsonWebhookFormat},
+		{notificationSlackWebhookUrlEnvironmentVariableName, slackWebhookFormat},
+		{notificationTeamsWebhookUrlEnvironmentVariableName, teamsWebhookFormat},
+	} {
+		if url := lookupOptionalEnvironmentVariable(webhook.environmentVariableName, ""); url != "" {
+			notifiers = append(notifiers, NewWebhookNotifier(webhook.format, url))
+		}
+	}
+
+	if topicArn := lookupOptionalEnvironmentVariable(notificationSnsTopicArnEnvironmentVariableName, ""); topicArn != "" {
+		notifiers = append(notifiers, NewSNSNotifier(newSdkSNSClient(sns.NewFromConfig(awsConfiguration, func(options *sns.Options) {
+			options.Region = snsTopicRegion(topicArn, awsConfiguration.Region)
+		})), topicArn))
+	}
+
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	notificationTemplate, err := template.New("notification").Parse(lookupOptionalEnvironmentVariable(notificationTemplateEnvironmentVariableName, defaultNotificationTemplate))
+	if err != nil {
+		logger.Warnf("Unable to parse %s environment variable: %v", notificationTemplateEnvironmentVariableName, err)
+
+		notificationTemplate = template.Must(template.New("notification").Parse(defaultNotificationTemplate))
+	}
+
+	return NewNotificationDispatcher(notifiers, notificationTemplate,
+		lookupOptionalDurationEnvironmentVariable(notificationDeduplicationPeriodEnvironmentVariableName, defaultNotificationDeduplicationPeriod))
+}
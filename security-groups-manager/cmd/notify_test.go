@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingNotifier struct {
+	Err      error
+	Id       string
+	Messages []string
+	Subjects []string
+}
+
+func (r *recordingNotifier) Name() string {
+	return "recording" + r.Id
+}
+
+func (r *recordingNotifier) Notify(subject string, message string, notification *Notification) error {
+	if r.Err != nil {
+		return r.Err
+	}
+
+	r.Messages = append(r.Messages, message)
+	r.Subjects = append(r.Subjects, subject)
+
+	return nil
+}
+
+func newTestNotificationSecurityGroupDeltas(requestId string) []SecurityGroupDelta {
+	changedSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-1"), GroupName: aws.String("web")})
+	changedSecurityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1")}
+	changedSecurityGroupDelta.IngressRulesToAuthorize = []Rule{{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: ipv4CidrSourceKind, Source: "192.0.2.1/32", Description: "Office"}}
+	changedSecurityGroupDelta.IngressRulesToAuthorizeResult = "Succeeded to authorize inbound rules"
+	changedSecurityGroupDelta.EgressRulesToRevoke = []Rule{{Direction: egressDirection, IpProtocol: "-1", FromPort: -1, ToPort: -1, SourceKind: ipv4CidrSourceKind, Source: "0.0.0.0/0"}}
+	changedSecurityGroupDelta.EgressRulesToRevokeResult = "Failed to revoke outbound rules: throttled, request id: " + requestId
+	changedSecurityGroupDelta.RegionName = "eu-west-1"
+	changedSecurityGroupDelta.TagsToCreate = []types.Tag{{Key: aws.String("Team"), Value: aws.String("platform")}}
+
+	inSyncSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-2"), GroupName: aws.String("db")})
+	inSyncSecurityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-2")}
+
+	notFoundSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-3"), GroupName: aws.String("cache")})
+	notFoundSecurityGroupDelta.IngressRulesToAuthorize = changedSecurityGroupDelta.IngressRulesToAuthorize
+
+	return []SecurityGroupDelta{*changedSecurityGroupDelta, *inSyncSecurityGroupDelta, *notFoundSecurityGroupDelta}
+}
+
+func TestNotificationDispatcher(t *testing.T) {
+	for name, stateStore := range map[string]StateStore{"Memory": nil, "State store": NewDynamoDBStateStore(newMemoryDynamoDBClient(), "state")} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+			notifier := new(recordingNotifier)
+			notificationDispatcher := NewNotificationDispatcher([]Notifier{notifier}, template.Must(template.New("notification").Parse(defaultNotificationTemplate)), time.Hour)
+			notificationDispatcher.Now = func() time.Time { return now }
+
+			notificationDispatcher.dispatch(applyCommand, "run-1", newTestNotificationSecurityGroupDeltas("1"), stateStore)
+
+			assert.Equal(t, []string{"SecurityGroupsManager apply: 1 security groups drifted, 1 failed"}, notifier.Subjects)
+			assert.Equal(t, []string{
+				"web (sg-1, eu-west-1) is changed and failed to apply\n" +
+					"  authorize inbound TCP 443 from 192.0.2.1/32 (Office)\n" +
+					"  revoke outbound All All to 0.0.0.0/0\n" +
+					"  create tag Team=platform\n" +
+					"  Failed to revoke outbound rules: throttled, request id: 1\n" +
+					"Run run-1\n",
+			}, notifier.Messages)
+
+			now = now.Add(time.Minute)
+			notificationDispatcher.dispatch(applyCommand, "run-2", newTestNotificationSecurityGroupDeltas("2"), stateStore)
+			assert.Len(t, notifier.Messages, 1, "The same failure with another request ID is deduplicated")
+
+			notificationDispatcher.dispatch(planCommand, "run-3", newTestNotificationSecurityGroupDeltas("3"), stateStore)
+			assert.Len(t, notifier.Messages, 2, "Another command isn't deduplicated")
+
+			now = now.Add(time.Hour)
+			notifier.Err = fmt.Errorf("unavailable")
+			notificationDispatcher.dispatch(applyCommand, "run-4", newTestNotificationSecurityGroupDeltas("4"), stateStore)
+
+			notifier.Err = nil
+			notificationDispatcher.dispatch(applyCommand, "run-5", newTestNotificationSecurityGroupDeltas("5"), stateStore)
+			assert.Len(t, notifier.Messages, 3, "Failed notifications are retried")
+		})
+	}
+}
+
+func TestNotificationDispatcherRetriesFailedNotifiersOnly(t *testing.T) {
+	for name, stateStore := range map[string]StateStore{"Memory": nil, "State store": NewDynamoDBStateStore(newMemoryDynamoDBClient(), "state")} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+			notifier := &recordingNotifier{Id: "1"}
+			failingNotifier := &recordingNotifier{Err: fmt.Errorf("unavailable"), Id: "2"}
+			notificationDispatcher := NewNotificationDispatcher([]Notifier{notifier, failingNotifier}, template.Must(template.New("notification").Parse(defaultNotificationTemplate)), time.Hour)
+			notificationDispatcher.Now = func() time.Time { return now }
+
+			notificationDispatcher.dispatch(applyCommand, "run-1", newTestNotificationSecurityGroupDeltas("1"), stateStore)
+			assert.Len(t, notifier.Messages, 1)
+			assert.Empty(t, failingNotifier.Messages)
+
+			now = now.Add(time.Minute)
+			failingNotifier.Err = nil
+			notificationDispatcher.dispatch(applyCommand, "run-2", newTestNotificationSecurityGroupDeltas("2"), stateStore)
+			assert.Len(t, notifier.Messages, 1, "The notifier that succeeded is deduplicated")
+			assert.Len(t, failingNotifier.Messages, 1, "The notifier that failed is retried")
+
+			now = now.Add(time.Minute)
+			notificationDispatcher.dispatch(applyCommand, "run-3", newTestNotificationSecurityGroupDeltas("3"), stateStore)
+			assert.Len(t, notifier.Messages, 1)
+			assert.Len(t, failingNotifier.Messages, 1, "Once delivered the notifier is deduplicated too")
+		})
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	notification := &Notification{Command: applyCommand, RunId: "run-1", SecurityGroups: []SecurityGroupNotification{{GroupId: "sg-1", Status: changedStatus}}}
+
+	tests := []struct {
+		format string
+		want   map[string]interface{}
+	}{
+		{
+			format: jsonWebhookFormat,
+			want: map[string]interface{}{
+				"Command":        applyCommand,
+				"Message":        "web <changed> ```\n",
+				"RunId":          "run-1",
+				"SecurityGroups": []interface{}{map[string]interface{}{"GroupId": "sg-1", "Status": changedStatus}},
+				"Subject":        "Drift",
+			},
+		},
+		{
+			format: slackWebhookFormat,
+			want: map[string]interface{}{
+				"text": "*Drift*\n```\nweb &lt;changed&gt; `\u200b`\u200b`\u200b\n```",
+			},
+		},
+		{
+			format: teamsWebhookFormat,
+			want: map[string]interface{}{
+				"@context": "https://schema.org/extensions",
+				"@type":    "MessageCard",
+				"summary":  "Drift",
+				"text":     "<pre>web &lt;changed&gt; ```\n</pre>",
+				"title":    "Drift",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var payload map[string]interface{}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			}))
+			defer server.Close()
+
+			assert.NoError(t, NewWebhookNotifier(test.format, server.URL).Notify("Drift", "web <changed> ```\n", notification))
+
+			if test.format == jsonWebhookFormat {
+				// Only check the fields set on the notification
+				securityGroup := payload["SecurityGroups"].([]interface{})[0].(map[string]interface{})
+				for key := range securityGroup {
+					if key != "GroupId" && key != "Status" {
+						delete(securityGroup, key)
+					}
+				}
+			}
+
+			assert.Equal(t, test.want, payload)
+		})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	assert.EqualError(t, NewWebhookNotifier(slackWebhookFormat, server.URL).Notify("Drift", "", notification), "slack webhook responded with status 404")
+}
+
+func TestSNSNotifier(t *testing.T) {
+	var forms []map[string]string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/sns/aws4_request")
+
+		assert.NoError(t, r.ParseForm())
+		form := make(map[string]string)
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		forms = append(forms, form)
+
+		w.Header().Set("Content-Type", "text/xml")
+
+		if form["TopicArn"] != "arn:aws:sns:us-east-1:123456789012:drift" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>NotFound</Code><Message>Topic does not exist</Message></Error></ErrorResponse>`))
+
+			return
+		}
+
+		w.Write([]byte(`<PublishResponse><PublishResult><MessageId>1</MessageId></PublishResult></PublishResponse>`))
+	}))
+	defer server.Close()
+
+	assert.Equal(t, "us-east-1", snsTopicRegion("arn:aws:sns:us-east-1:123456789012:drift", "eu-west-1"), "The region of the topic")
+	assert.Equal(t, "eu-west-1", snsTopicRegion("drift", "eu-west-1"))
+
+	client := newSdkSNSClient(sns.New(sns.Options{
+		Credentials:      staticCredentialsProvider{},
+		EndpointResolver: sns.EndpointResolverFromURL(server.URL),
+		HTTPClient:       server.Client(),
+		Region:           snsTopicRegion("arn:aws:sns:us-east-1:123456789012:drift", "eu-west-1"),
+		Retryer:          aws.NopRetryer{},
+	}))
+
+	assert.NoError(t, NewSNSNotifier(client, "arn:aws:sns:us-east-1:123456789012:drift").Notify(strings.Repeat("s", 120), "web is changed\n", nil))
+
+	err := NewSNSNotifier(client, "arn:aws:sns:us-east-1:123456789012:missing").Notify("Drift", "", nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "NotFound: Topic does not exist")
+	}
+
+	if assert.Len(t, forms, 2) {
+		assert.Equal(t, map[string]string{
+			"Action":   "Publish",
+			"Message":  strings.Repeat("s", maximumSNSSubjectLength) + "\n\nweb is changed\n",
+			"Subject":  strings.Repeat("s", maximumSNSSubjectLength),
+			"TopicArn": "arn:aws:sns:us-east-1:123456789012:drift",
+			"Version":  "2010-03-31",
+		}, forms[0])
+	}
+}
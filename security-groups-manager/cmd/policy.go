@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	errorSeverity   = "error"
+	offSeverity     = "off"
+	warningSeverity = "warning"
+)
+
+const (
+	allTrafficFromInternetPolicyRule = "all-traffic-from-internet"
+	rdpOpenToInternetPolicyRule      = "rdp-open-to-internet"
+	sshOpenToInternetPolicyRule      = "ssh-open-to-internet"
+)
+
+type PolicyConfiguration struct {
+	Files      []string
+	Severities map[string]string
+}
+
+type PolicyViolation struct {
+	Direction    string
+	IpPermission string
+	Message      string
+	Rule         string
+	Severity     string
+
+	location *ConfigurationLocation
+}
+
+func (p PolicyViolation) String() string {
+	return fmt.Sprintf("[%s] %s: %s", p.Severity, p.Rule, p.Message)
+}
+
+type policyRule struct {
+	Check           func(direction string, ipPermission IpPermission) string
+	DefaultSeverity string
+	Description     string
+	Name            string
+}
+
+var defaultPolicyRules = []policyRule{
+	{
+		Check: func(direction string, ipPermission IpPermission) string {
+			if direction == ingressDirection && ipPermission.isOpenToInternet() && normalizeIpProtocol(aws.ToString(ipPermission.IpProtocol)) == "-1" {
+				return "All traffic is allowed from the internet"
+			}
+
+			return ""
+		},
+		DefaultSeverity: warningSeverity,
+		Description:     "All traffic is allowed from the internet",
+		Name:            allTrafficFromInternetPolicyRule,
+	},
+	{
+		Check: func(direction string, ipPermission IpPermission) string {
+			if direction == ingressDirection && ipPermission.isOpenToInternet() && ipPermission.allowsPort("tcp", 3389) {
+				return "RDP (TCP 3389) is allowed from the internet"
+			}
+
+			return ""
+		},
+		DefaultSeverity: warningSeverity,
+		Description:     "RDP is allowed from the internet",
+		Name:            rdpOpenToInternetPolicyRule,
+	},
+	{
+		Check: func(direction string, ipPermission IpPermission) string {
+			if direction == ingressDirection && ipPermission.isOpenToInternet() && ipPermission.allowsPort("tcp", 22) {
+				return "SSH (TCP 22) is allowed from the internet"
+			}
+
+			return ""
+		},
+		DefaultSeverity: warningSeverity,
+		Description:     "SSH is allowed from the internet",
+		Name:            sshOpenToInternetPolicyRule,
+	},
+}
+
+type Policy struct {
+	Rules      []policyRule
+	Severities map[string]string
+}
+
+func NewPolicy(policyConfiguration *PolicyConfiguration) *Policy {
+	policy := new(Policy)
+
+	policy.Rules = defaultPolicyRules
+	policy.Severities = make(map[string]string)
+
+	for _, rule := range policy.Rules {
+		policy.Severities[rule.Name] = rule.DefaultSeverity
+	}
+
+	if policyConfiguration != nil {
+		for name, severity := range policyConfiguration.Severities {
+			if _, ok := policy.Severities[name]; !ok {
+				logger.Warnf("Unknown policy rule %s", name)
+
+				continue
+			}
+
+			switch severity {
+			case errorSeverity, offSeverity, warningSeverity:
+				policy.Severities[name] = severity
+			default:
+				logger.Warnf("Unknown severity %s for policy rule %s", severity, name)
+			}
+		}
+	}
+
+	return policy
+}
+
+// lint evaluates every policy rule against the consolidated IpPermissions of a configured security group. Rules
+// suppressed by an IpPermission are not evaluated against it
+func (p *Policy) lint(configuredSecurityGroup SecurityGroup) []PolicyViolation {
+	policyViolations := make([]PolicyViolation, 0)
+
+	for _, directionIpPermissions := range []struct {
+		direction     string
+		ipPermissions []IpPermission
+	}{
+		{ingressDirection, configuredSecurityGroup.IpPermissions},
+		{egressDirection, configuredSecurityGroup.IpPermissionsEgress},
+	} {
+		for _, ipPermission := range directionIpPermissions.ipPermissions {
+			for _, rule := range p.Rules {
+				severity := p.Severities[rule.Name]
+				if severity == offSeverity || ipPermission.suppresses(rule.Name) {
+					continue
+				}
+
+				if message := rule.Check(directionIpPermissions.direction, ipPermission); message != "" {
+					policyViolations = append(policyViolations, PolicyViolation{
+						Direction:    directionIpPermissions.direction,
+						IpPermission: ipPermission.describe(),
+						Message:      message,
+						Rule:         rule.Name,
+						Severity:     severity,
+						location:     ipPermission.location,
+					})
+				}
+			}
+		}
+	}
+
+	return policyViolations
+}
+
+func hasErrorPolicyViolations(policyViolations []PolicyViolation) bool {
+	for _, policyViolation := range policyViolations {
+		if policyViolation.Severity == errorSeverity {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsPort reports whether the IpPermission allows traffic to port over protocol
+func (i *IpPermission) allowsPort(protocol string, port int32) bool {
+	if normalizeIpProtocol(aws.ToString(i.IpProtocol)) != protocol {
+		return false
+	}
+
+	if i.FromPort == nil || *i.FromPort == -1 {
+		return true
+	}
+
+	toPort := *i.FromPort
+	if i.ToPort != nil {
+		toPort = *i.ToPort
+	}
+
+	return *i.FromPort <= port && port <= toPort
+}
+
+func (i *IpPermission) describe() string {
+	ipPermission := types.IpPermission{
+		FromPort:   i.FromPort,
+		IpProtocol: aws.String(normalizeIpProtocol(aws.ToString(i.IpProtocol))),
+		ToPort:     i.ToPort,
+	}
+
+	return fmt.Sprintf("%s %s", determineProtocol(ipPermission), determinePortRange(ipPermission))
+}
+
+func (i *IpPermission) isOpenToInternet() bool {
+	for _, ipRange := range i.IpRanges {
+		if normalizeCidr(aws.ToString(ipRange.CidrIp)) == "0.0.0.0/0" {
+			return true
+		}
+	}
+
+	for _, ipv6Range := range i.Ipv6Ranges {
+		if normalizeCidr(aws.ToString(ipv6Range.CidrIpv6)) == "::/0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (i *IpPermission) suppresses(policyRuleName string) bool {
+	for _, suppressedPolicyRule := range i.SuppressPolicyRules {
+		if suppressedPolicyRule == policyRuleName {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyLint(t *testing.T) {
+	internet := []IpRange{{CidrIp: aws.String("0.0.0.0/0")}}
+
+	securityGroup := SecurityGroup{
+		IpPermissions: []IpPermission{
+			{FromPort: aws.Int32(22), IpProtocol: aws.String("6"), IpRanges: internet, ToPort: aws.Int32(22)},
+			{FromPort: aws.Int32(3000), IpProtocol: aws.String("tcp"), Ipv6Ranges: []Ipv6Range{{CidrIpv6: aws.String("::0/0")}}, ToPort: aws.Int32(4000)},
+			{IpProtocol: aws.String("all"), IpRanges: internet},
+			{FromPort: aws.Int32(22), IpProtocol: aws.String("tcp"), IpRanges: []IpRange{{CidrIp: aws.String("10.0.0.0/8")}}, ToPort: aws.Int32(22)},
+			{FromPort: aws.Int32(22), IpProtocol: aws.String("tcp"), IpRanges: internet, SuppressPolicyRules: []string{sshOpenToInternetPolicyRule}, ToPort: aws.Int32(22)},
+		},
+		IpPermissionsEgress: []IpPermission{
+			{IpProtocol: aws.String("-1"), IpRanges: internet},
+		},
+	}
+
+	tests := []struct {
+		name                string
+		policyConfiguration *PolicyConfiguration
+		want                []PolicyViolation
+	}{
+		{
+			name: "Default severities",
+			want: []PolicyViolation{
+				{Direction: ingressDirection, IpPermission: "TCP 22", Message: "SSH (TCP 22) is allowed from the internet", Rule: sshOpenToInternetPolicyRule, Severity: warningSeverity},
+				{Direction: ingressDirection, IpPermission: "TCP 3000 - 4000", Message: "RDP (TCP 3389) is allowed from the internet", Rule: rdpOpenToInternetPolicyRule, Severity: warningSeverity},
+				{Direction: ingressDirection, IpPermission: "All All", Message: "All traffic is allowed from the internet", Rule: allTrafficFromInternetPolicyRule, Severity: warningSeverity},
+			},
+		},
+		{
+			name: "Configured severities",
+			policyConfiguration: &PolicyConfiguration{
+				Severities: map[string]string{
+					allTrafficFromInternetPolicyRule: offSeverity,
+					rdpOpenToInternetPolicyRule:      errorSeverity,
+					sshOpenToInternetPolicyRule:      "fatal",
+				},
+			},
+			want: []PolicyViolation{
+				{Direction: ingressDirection, IpPermission: "TCP 22", Message: "SSH (TCP 22) is allowed from the internet", Rule: sshOpenToInternetPolicyRule, Severity: warningSeverity},
+				{Direction: ingressDirection, IpPermission: "TCP 3000 - 4000", Message: "RDP (TCP 3389) is allowed from the internet", Rule: rdpOpenToInternetPolicyRule, Severity: errorSeverity},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, NewPolicy(test.policyConfiguration).lint(securityGroup))
+		})
+	}
+}
+
+func TestPolicyViolationsBlockApply(t *testing.T) {
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{})
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{}
+
+	securityGroupDelta.PolicyViolations = []PolicyViolation{{Rule: rdpOpenToInternetPolicyRule, Severity: warningSeverity}}
+
+	assert.True(t, securityGroupDelta.canApply())
+	assert.Equal(t, inSyncStatus, securityGroupDelta.status())
+
+	securityGroupDelta.PolicyViolations = append(securityGroupDelta.PolicyViolations, PolicyViolation{Rule: sshOpenToInternetPolicyRule, Severity: errorSeverity})
+
+	assert.False(t, securityGroupDelta.canApply())
+	assert.Equal(t, blockedStatus, securityGroupDelta.status())
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	apiErrorsMetricName             = "security_groups_manager_api_errors_total"
+	dnsLookupDurationMetricName     = "security_groups_manager_dns_lookup_duration_seconds"
+	driftDetectedMetricName         = "security_groups_manager_drift_detected_total"
+	hostResolvedAddressesMetricName = "security_groups_manager_host_resolved_addresses"
+	lastSyncTimestampMetricName     = "security_groups_manager_last_sync_timestamp_seconds"
+	reconciliationRunsMetricName    = "security_groups_manager_reconciliation_runs_total"
+)
+
+const (
+	counterMetricType   = "counter"
+	gaugeMetricType     = "gauge"
+	histogramMetricType = "histogram"
+)
+
+const prometheusExpositionContentType = "text/plain; version=0.0.4"
+
+const unknownErrorCode = "Unknown"
+
+var dnsLookupDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type prometheusMetricFamily struct {
+	Help    string
+	Name    string
+	Samples map[string]float64
+	Type    string
+}
+
+type prometheusHistogram struct {
+	Buckets []float64
+	Count   uint64
+	Counts  []uint64
+	Sum     float64
+}
+
+// PrometheusRegistry holds the metrics exposed on /metrics in the Prometheus text exposition format
+type PrometheusRegistry struct {
+	Families   map[string]*prometheusMetricFamily
+	Histograms map[string]*prometheusHistogram
+	Mutex      sync.Mutex
+}
+
+var prometheusRegistry = NewPrometheusRegistry()
+
+func NewPrometheusRegistry() *PrometheusRegistry {
+	prometheusRegistry := new(PrometheusRegistry)
+
+	prometheusRegistry.Families = make(map[string]*prometheusMetricFamily)
+	prometheusRegistry.Histograms = make(map[string]*prometheusHistogram)
+
+	for _, family := range []prometheusMetricFamily{
+		{Help: "API errors by operation and error code", Name: apiErrorsMetricName, Type: counterMetricType},
+		{Help: "Duration of DNS lookups of Hosts", Name: dnsLookupDurationMetricName, Type: histogramMetricType},
+		{Help: "Reconciliations that found a security group out of sync", Name: driftDetectedMetricName, Type: counterMetricType},
+		{Help: "Number of addresses a Host resolved to", Name: hostResolvedAddressesMetricName, Type: gaugeMetricType},
+		{Help: "Unix time a security group was last in sync", Name: lastSyncTimestampMetricName, Type: gaugeMetricType},
+		{Help: "Reconciliation runs by command and result", Name: reconciliationRunsMetricName, Type: counterMetricType},
+	} {
+		family := family
+		family.Samples = make(map[string]float64)
+		prometheusRegistry.Families[family.Name] = &family
+	}
+
+	prometheusRegistry.Histograms[dnsLookupDurationMetricName] = &prometheusHistogram{
+		Buckets: dnsLookupDurationBuckets,
+		Counts:  make([]uint64, len(dnsLookupDurationBuckets)),
+	}
+
+	return prometheusRegistry
+}
+
+// add increments a counter. labels are name, value pairs
+func (p *PrometheusRegistry) add(name string, value float64, labels ...string) {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+
+	p.Families[name].Samples[formatPrometheusLabels(labels)] += value
+}
+
+// set sets a gauge. labels are name, value pairs
+func (p *PrometheusRegistry) set(name string, value float64, labels ...string) {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+
+	p.Families[name].Samples[formatPrometheusLabels(labels)] = value
+}
+
+func (p *PrometheusRegistry) observe(name string, value float64) {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+
+	histogram := p.Histograms[name]
+	histogram.Count++
+	histogram.Sum += value
+
+	for i, upperBound := range histogram.Buckets {
+		if value <= upperBound {
+			histogram.Counts[i]++
+		}
+	}
+}
+
+// observeRun records the outcome of a plan or apply
+func (p *PrometheusRegistry) observeRun(command string, err error, securityGroupDeltas []SecurityGroupDelta, now time.Time) {
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+	}
+
+	for i := range securityGroupDeltas {
+		securityGroupDelta := &securityGroupDeltas[i]
+		if securityGroupDelta.AsIsSecurityGroup == nil {
+			continue
+		}
+
+		groupId := aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupId)
+		status := securityGroupDelta.status()
+
+		if status != inSyncStatus {
+			p.add(driftDetectedMetricName, 1, "group_id", groupId, "region", securityGroupDelta.RegionName)
+		}
+
+		applied := command == applyCommand && status == changedStatus && len(securityGroupDelta.results()) > 0 && !securityGroupDelta.failed()
+		if status == inSyncStatus || applied {
+			p.set(lastSyncTimestampMetricName, float64(now.Unix()), "group_id", groupId, "region", securityGroupDelta.RegionName)
+		}
+
+		if securityGroupDelta.failed() {
+			result = "failed"
+		}
+	}
+
+	p.add(reconciliationRunsMetricName, 1, "command", command, "result", result)
+}
+
+func (p *PrometheusRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prometheusExpositionContentType)
+
+	fmt.Fprint(w, p.expose())
+}
+
+func (p *PrometheusRegistry) expose() string {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+
+	var builder strings.Builder
+
+	names := make([]string, 0, len(p.Families))
+	for name := range p.Families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := p.Families[name]
+
+		fmt.Fprintf(&builder, "# HELP %s %s\n", family.Name, family.Help)
+		fmt.Fprintf(&builder, "# TYPE %s %s\n", family.Name, family.Type)
+
+		if histogram, ok := p.Histograms[name]; ok {
+			for i, upperBound := range histogram.Buckets {
+				fmt.Fprintf(&builder, "%s_bucket{le=\"%s\"} %d\n", family.Name, formatPrometheusValue(upperBound), histogram.Counts[i])
+			}
+			fmt.Fprintf(&builder, "%s_bucket{le=\"+Inf\"} %d\n", family.Name, histogram.Count)
+			fmt.Fprintf(&builder, "%s_sum %s\n", family.Name, formatPrometheusValue(histogram.Sum))
+			fmt.Fprintf(&builder, "%s_count %d\n", family.Name, histogram.Count)
+
+			continue
+		}
+
+		labels := make([]string, 0, len(family.Samples))
+		for label := range family.Samples {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			fmt.Fprintf(&builder, "%s%s %s\n", family.Name, label, formatPrometheusValue(family.Samples[label]))
+		}
+	}
+
+	return builder.String()
+}
+
+func formatPrometheusLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatPrometheusValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// errorCode returns the error code of an AWS API error
+func errorCode(err error) string {
+	var apiError interface {
+		ErrorCode() string
+	}
+
+	if errors.As(err, &apiError) {
+		return apiError.ErrorCode()
+	}
+
+	return unknownErrorCode
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type testAPIError struct {
+	Code string
+}
+
+func (t *testAPIError) Error() string {
+	return "api error " + t.Code
+}
+
+func (t *testAPIError) ErrorCode() string {
+	return t.Code
+}
+
+func TestPrometheusRegistry(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	inSyncSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-1")})
+	inSyncSecurityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1")}
+	inSyncSecurityGroupDelta.RegionName = "eu-west-1"
+
+	failedSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-2")})
+	failedSecurityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-2")}
+	failedSecurityGroupDelta.IngressRulesToRevoke = []Rule{{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 22, ToPort: 22, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.1/32"}}
+	failedSecurityGroupDelta.IngressRulesToRevokeResult = "Failed to revoke inbound rules: throttled"
+	failedSecurityGroupDelta.RegionName = "eu-west-1"
+
+	registry := NewPrometheusRegistry()
+	registry.observeRun(applyCommand, nil, []SecurityGroupDelta{*inSyncSecurityGroupDelta, *failedSecurityGroupDelta}, now)
+	registry.observeRun(planCommand, nil, []SecurityGroupDelta{*inSyncSecurityGroupDelta}, now)
+	registry.add(apiErrorsMetricName, 1, "operation", "RevokeSecurityGroupIngress", "error_code", errorCode(fmt.Errorf("revoke: %w", &testAPIError{Code: "RequestLimitExceeded"})))
+	registry.add(apiErrorsMetricName, 1, "operation", "DescribeRegions", "error_code", errorCode(fmt.Errorf("timeout")))
+	registry.observe(dnsLookupDurationMetricName, 0.02)
+	registry.observe(dnsLookupDurationMetricName, 0.3)
+	registry.set(hostResolvedAddressesMetricName, 2, "host", `my "host"`)
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, prometheusExpositionContentType, recorder.Header().Get("Content-Type"))
+	assert.Equal(t, strings.Join([]string{
+		`# HELP security_groups_manager_api_errors_total API errors by operation and error code`,
+		`# TYPE security_groups_manager_api_errors_total counter`,
+		`security_groups_manager_api_errors_total{operation="DescribeRegions",error_code="Unknown"} 1`,
+		`security_groups_manager_api_errors_total{operation="RevokeSecurityGroupIngress",error_code="RequestLimitExceeded"} 1`,
+		`# HELP security_groups_manager_dns_lookup_duration_seconds Duration of DNS lookups of Hosts`,
+		`# TYPE security_groups_manager_dns_lookup_duration_seconds histogram`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="0.005"} 0`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="0.01"} 0`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="0.025"} 1`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="0.05"} 1`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="0.1"} 1`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="0.25"} 1`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="0.5"} 2`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="1"} 2`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="2.5"} 2`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="5"} 2`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="10"} 2`,
+		`security_groups_manager_dns_lookup_duration_seconds_bucket{le="+Inf"} 2`,
+		`security_groups_manager_dns_lookup_duration_seconds_sum 0.32`,
+		`security_groups_manager_dns_lookup_duration_seconds_count 2`,
+		`# HELP security_groups_manager_drift_detected_total Reconciliations that found a security group out of sync`,
+		`# TYPE security_groups_manager_drift_detected_total counter`,
+		`security_groups_manager_drift_detected_total{group_id="sg-2",region="eu-west-1"} 1`,
+		`# HELP security_groups_manager_host_resolved_addresses Number of addresses a Host resolved to`,
+		`# TYPE security_groups_manager_host_resolved_addresses gauge`,
+		`security_groups_manager_host_resolved_addresses{host="my \"host\""} 2`,
+		`# HELP security_groups_manager_last_sync_timestamp_seconds Unix time a security group was last in sync`,
+		`# TYPE security_groups_manager_last_sync_timestamp_seconds gauge`,
+		`security_groups_manager_last_sync_timestamp_seconds{group_id="sg-1",region="eu-west-1"} 1630497600`,
+		`# HELP security_groups_manager_reconciliation_runs_total Reconciliation runs by command and result`,
+		`# TYPE security_groups_manager_reconciliation_runs_total counter`,
+		`security_groups_manager_reconciliation_runs_total{command="apply",result="failed"} 1`,
+		`security_groups_manager_reconciliation_runs_total{command="plan",result="succeeded"} 1`,
+	}, "\n")+"\n", recorder.Body.String())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const rulesPerSecurityGroupQuotaEnvironmentVariableName = "RULES_PER_SECURITY_GROUP_QUOTA"
+
+// The default number of inbound or outbound rules per security group, enforced separately for IPv4 and IPv6 rules
+const defaultRulesPerSecurityGroupQuota = 60
+
+const (
+	ipv4AddressFamily = "IPv4"
+	ipv6AddressFamily = "IPv6"
+)
+
+// preflight counts the rules the security group will have once the delta is applied and records a violation for
+// every direction and address family that would exceed the quota, naming the IpPermission that exceeded it. Rules
+// referencing a security group or a prefix list count against both the IPv4 and the IPv6 quota, as they do in AWS
+func (s *SecurityGroupDelta) preflight(rulesPerSecurityGroupQuota int) {
+	s.QuotaViolations = make([]string, 0)
+
+	ownerId := aws.ToString(s.ToBeSecurityGroup.OwnerId)
+	if s.AsIsSecurityGroup != nil {
+		ownerId = aws.ToString(s.AsIsSecurityGroup.OwnerId)
+	}
+
+	for _, directionIpPermissions := range []struct {
+		direction     string
+		label         string
+		ipPermissions []types.IpPermission
+	}{
+		{ingressDirection, "Inbound", s.ToBeSecurityGroup.IpPermissions},
+		{egressDirection, "Outbound", s.ToBeSecurityGroup.IpPermissionsEgress},
+	} {
+		ruleCounts := make(map[string]int)
+		exceedingIpPermissions := make(map[string]types.IpPermission)
+
+		for _, ipPermission := range directionIpPermissions.ipPermissions {
+			for _, rule := range flattenIpPermissions(directionIpPermissions.direction, []types.IpPermission{ipPermission}, ownerId) {
+				for _, addressFamily := range ruleAddressFamilies(rule) {
+					ruleCounts[addressFamily]++
+
+					if ruleCounts[addressFamily] == rulesPerSecurityGroupQuota+1 {
+						exceedingIpPermissions[addressFamily] = ipPermission
+					}
+				}
+			}
+		}
+
+		for _, addressFamily := range []string{ipv4AddressFamily, ipv6AddressFamily} {
+			if ruleCounts[addressFamily] > rulesPerSecurityGroupQuota {
+				exceedingIpPermission := exceedingIpPermissions[addressFamily]
+
+				s.QuotaViolations = append(s.QuotaViolations, fmt.Sprintf(
+					"%s %s rules (%d) exceed the quota of %d, first exceeded by IpPermission %s %s",
+					directionIpPermissions.label,
+					addressFamily,
+					ruleCounts[addressFamily],
+					rulesPerSecurityGroupQuota,
+					determineProtocol(exceedingIpPermission),
+					determinePortRange(exceedingIpPermission),
+				))
+			}
+		}
+	}
+}
+
+// ruleAddressFamilies returns the address families whose quota the rule counts against
+func ruleAddressFamilies(rule Rule) []string {
+	switch rule.SourceKind {
+	case ipv4CidrSourceKind:
+		return []string{ipv4AddressFamily}
+	case ipv6CidrSourceKind:
+		return []string{ipv6AddressFamily}
+	default:
+		return []string{ipv4AddressFamily, ipv6AddressFamily}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func ipRanges(count int) []types.IpRange {
+	ipRanges := make([]types.IpRange, 0, count)
+	for i := 0; i < count; i++ {
+		ipRanges = append(ipRanges, types.IpRange{CidrIp: aws.String(fmt.Sprintf("10.0.0.%d/32", i))})
+	}
+
+	return ipRanges
+}
+
+func TestPreflight(t *testing.T) {
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{
+		IpPermissions: []types.IpPermission{
+			{FromPort: aws.Int32(22), IpProtocol: aws.String("tcp"), IpRanges: ipRanges(2), ToPort: aws.Int32(22)},
+			{FromPort: aws.Int32(443), IpProtocol: aws.String("tcp"), IpRanges: ipRanges(2), ToPort: aws.Int32(443)},
+			{
+				FromPort:   aws.Int32(80),
+				IpProtocol: aws.String("tcp"),
+				Ipv6Ranges: []types.Ipv6Range{{CidrIpv6: aws.String("::/0")}},
+				ToPort:     aws.Int32(80),
+			},
+		},
+		IpPermissionsEgress: []types.IpPermission{
+			{IpProtocol: aws.String("-1"), IpRanges: ipRanges(3)},
+		},
+	})
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{OwnerId: aws.String("123456789012")}
+
+	securityGroupDelta.preflight(3)
+
+	assert.Equal(t, []string{
+		"Inbound IPv4 rules (4) exceed the quota of 3, first exceeded by IpPermission TCP 443",
+	}, securityGroupDelta.QuotaViolations)
+	assert.False(t, securityGroupDelta.canApply())
+	assert.Equal(t, refusedStatus, securityGroupDelta.status())
+
+	securityGroupDelta.preflight(4)
+
+	assert.Empty(t, securityGroupDelta.QuotaViolations)
+	assert.True(t, securityGroupDelta.canApply())
+}
+
+func TestPreflightCountsReferencesAgainstBothAddressFamilies(t *testing.T) {
+	ipv6Ranges := make([]types.Ipv6Range, 0, 3)
+	for i := 0; i < 3; i++ {
+		ipv6Ranges = append(ipv6Ranges, types.Ipv6Range{CidrIpv6: aws.String(fmt.Sprintf("2001:db8::%d/128", i))})
+	}
+
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{
+		IpPermissions: []types.IpPermission{
+			{FromPort: aws.Int32(443), IpProtocol: aws.String("tcp"), Ipv6Ranges: ipv6Ranges, ToPort: aws.Int32(443)},
+			{
+				FromPort:         aws.Int32(5432),
+				IpProtocol:       aws.String("tcp"),
+				ToPort:           aws.Int32(5432),
+				UserIdGroupPairs: []types.UserIdGroupPair{{GroupId: aws.String("sg-1")}},
+			},
+		},
+	})
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{OwnerId: aws.String("123456789012")}
+
+	securityGroupDelta.preflight(3)
+
+	assert.Equal(t, []string{
+		"Inbound IPv6 rules (4) exceed the quota of 3, first exceeded by IpPermission TCP 5432",
+	}, securityGroupDelta.QuotaViolations)
+
+	securityGroupDelta.preflight(4)
+
+	assert.Empty(t, securityGroupDelta.QuotaViolations)
+}
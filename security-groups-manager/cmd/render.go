@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	ruleModificationsTableKind = "rule-modifications"
+	rulesTableKind             = "rules"
+	securityGroupTableKind     = "security-group"
+	tagsTableKind              = "tags"
+)
+
+// Renderer renders the report of a security group delta in one output format
+type Renderer interface {
+	Render(securityGroupDelta *SecurityGroupDelta) string
+}
+
+// DeltaReport is the content of the report of a security group delta, independent of the output format. Every row
+// has a cell per column of the header.
+type DeltaReport struct {
+	Header []string
+	Rows   []ReportRow
+	Title  string
+}
+
+type ReportRow struct {
+	Cells []ReportCell
+	Title string
+}
+
+// ReportCell holds tables followed by lines of text
+type ReportCell struct {
+	Lines  []string
+	Tables []ReportTable
+}
+
+type ReportTable struct {
+	Header []string
+	Kind   string
+	Rows   [][]string
+	Title  string
+}
+
+func textCell(lines ...string) ReportCell {
+	return ReportCell{Lines: lines}
+}
+
+func tablesCell(tables ...ReportTable) ReportCell {
+	return ReportCell{Tables: tables}
+}
+
+func (r ReportCell) isEmpty() bool {
+	for _, line := range r.Lines {
+		if line != "" {
+			return false
+		}
+	}
+
+	return len(r.Tables) == 0
+}
+
+// isRightAligned returns true for the columns holding port ranges
+func (r ReportTable) isRightAligned(column int) bool {
+	switch r.Kind {
+	case ruleModificationsTableKind:
+		return column == 2
+	case rulesTableKind:
+		return column == 1
+	default:
+		return false
+	}
+}
+
+func newIpPermissionsTable(ipPermissions []types.IpPermission, securityGroup types.SecurityGroup, title string) ReportTable {
+	return newRulesTable(flattenIpPermissions("", ipPermissions, aws.ToString(securityGroup.OwnerId)), securityGroup, title)
+}
+
+func newRuleModificationsTable(ruleModifications []RuleModification, securityGroup types.SecurityGroup, title string) ReportTable {
+	ruleModificationsTable := ReportTable{
+		Header: []string{"Rule ID", "Protocol", "Port Range", "Source", "Description"},
+		Kind:   ruleModificationsTableKind,
+		Rows:   make([][]string, 0, len(ruleModifications)),
+		Title:  title,
+	}
+
+	for _, ruleModification := range ruleModifications {
+		source := determineSource(ruleModification.To, securityGroup)
+		if fromSource := determineSource(ruleModification.From, securityGroup); fromSource != source {
+			source = fromSource + " -> " + source
+		}
+		description := ruleModification.To.Description
+		if ruleModification.From.Description != description {
+			description = ruleModification.From.Description + " -> " + description
+		}
+
+		ruleModificationsTable.Rows = append(ruleModificationsTable.Rows, []string{
+			ruleModification.RuleId,
+			determineProtocol(ruleModification.To.ipPermission()),
+			determinePortRange(ruleModification.To.ipPermission()),
+			source,
+			description,
+		})
+	}
+
+	return ruleModificationsTable
+}
+
+func newRulesTable(rules []Rule, securityGroup types.SecurityGroup, title string) ReportTable {
+	hasRuleIds := false
+	for _, rule := range rules {
+		if rule.RuleId != "" {
+			hasRuleIds = true
+
+			break
+		}
+	}
+
+	rulesTable := ReportTable{
+		Header: []string{"Protocol", "Port Range", "Source", "Description"},
+		Kind:   rulesTableKind,
+		Rows:   make([][]string, 0, len(rules)),
+		Title:  title,
+	}
+	if hasRuleIds {
+		rulesTable.Header = append(rulesTable.Header, "Rule ID")
+	}
+
+	sortedRules := make([]Rule, len(rules))
+	copy(sortedRules, rules)
+	sortRules(sortedRules)
+
+	for _, rule := range sortedRules {
+		ipPermission := rule.ipPermission()
+
+		row := []string{
+			determineProtocol(ipPermission),
+			determinePortRange(ipPermission),
+			determineSource(rule, securityGroup),
+			rule.Description,
+		}
+		if hasRuleIds {
+			row = append(row, rule.RuleId)
+		}
+
+		rulesTable.Rows = append(rulesTable.Rows, row)
+	}
+
+	return rulesTable
+}
+
+func newSecurityGroupTable(securityGroup types.SecurityGroup) ReportTable {
+	return ReportTable{
+		Header: []string{"VPC ID", "Group ID", "Group Name", "Description", "Owner"},
+		Kind:   securityGroupTableKind,
+		Rows: [][]string{{
+			*securityGroup.VpcId,
+			*securityGroup.GroupId,
+			*securityGroup.GroupName,
+			*securityGroup.Description,
+			*securityGroup.OwnerId,
+		}},
+		Title: *securityGroup.GroupName,
+	}
+}
+
+func newTagsTable(tags []types.Tag, title string) ReportTable {
+	tagsTable := ReportTable{
+		Header: []string{"Key", "Value"},
+		Kind:   tagsTableKind,
+		Rows:   make([][]string, 0, len(tags)),
+		Title:  title,
+	}
+
+	for _, tag := range sortTags(tags) {
+		tagsTable.Rows = append(tagsTable.Rows, []string{*tag.Key, *tag.Value})
+	}
+
+	return tagsTable
+}
+
+// newRenderer returns the renderer of the output format, or nil if the output format isn't rendered per security group
+func newRenderer(outputFormat string) Renderer {
+	switch outputFormat {
+	case diffOutputFormat:
+		return NewDiffRenderer(isColorTerminal(os.Stdout))
+	case htmlOutputFormat:
+		return new(HTMLRenderer)
+	case markdownOutputFormat:
+		return new(MarkdownRenderer)
+	case plainOutputFormat:
+		return new(PlainRenderer)
+	case tableOutputFormat:
+		return new(TableRenderer)
+	default:
+		return nil
+	}
+}
+
+// MarkdownRenderer renders GitHub Flavored Markdown, for pull request comments and chat. Markdown tables can't be
+// nested, so every row of the report becomes a subsection listing its As is, To be, Remediation and Result cells.
+type MarkdownRenderer struct{}
+
+func (m *MarkdownRenderer) Render(securityGroupDelta *SecurityGroupDelta) string {
+	deltaReport := securityGroupDelta.report()
+
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "### %s\n", escapeMarkdown(deltaReport.Title))
+
+	for _, row := range deltaReport.Rows {
+		fmt.Fprintf(&builder, "\n#### %s\n", escapeMarkdown(row.Title))
+
+		for i, cell := range row.Cells {
+			if cell.isEmpty() {
+				continue
+			}
+
+			fmt.Fprintf(&builder, "\n**%s**\n", deltaReport.Header[i])
+
+			for _, reportTable := range cell.Tables {
+				builder.WriteString("\n")
+				if reportTable.Title != row.Title {
+					fmt.Fprintf(&builder, "_%s_\n\n", escapeMarkdown(reportTable.Title))
+				}
+				builder.WriteString(markdownTable(reportTable))
+			}
+
+			lines := make([]string, 0, len(cell.Lines))
+			for _, line := range cell.Lines {
+				if line != "" {
+					lines = append(lines, "- "+escapeMarkdown(line)+"\n")
+				}
+			}
+			if len(lines) > 0 {
+				builder.WriteString("\n" + strings.Join(lines, ""))
+			}
+		}
+	}
+
+	return builder.String()
+}
+
+func markdownTable(reportTable ReportTable) string {
+	var builder strings.Builder
+
+	delimiters := make([]string, 0, len(reportTable.Header))
+	for i := range reportTable.Header {
+		delimiter := "---"
+		if reportTable.isRightAligned(i) {
+			delimiter = "---:"
+		}
+		delimiters = append(delimiters, delimiter)
+	}
+
+	builder.WriteString(markdownTableRow(reportTable.Header))
+	builder.WriteString("| " + strings.Join(delimiters, " | ") + " |\n")
+
+	for _, row := range reportTable.Rows {
+		builder.WriteString(markdownTableRow(row))
+	}
+
+	return builder.String()
+}
+
+func markdownTableRow(cells []string) string {
+	escapedCells := make([]string, 0, len(cells))
+	for _, cell := range cells {
+		escapedCells = append(escapedCells, strings.ReplaceAll(escapeMarkdown(cell), "|", `\|`))
+	}
+
+	return "| " + strings.Join(escapedCells, " | ") + " |\n"
+}
+
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"`", "\\`",
+	"<", "&lt;",
+	">", "&gt;",
+	"\n", "<br>",
+)
+
+func escapeMarkdown(s string) string {
+	return markdownReplacer.Replace(s)
+}
+
+// HTMLRenderer renders an HTML fragment, for email and web pages, nesting the tables like TableRenderer does
+type HTMLRenderer struct{}
+
+func (h *HTMLRenderer) Render(securityGroupDelta *SecurityGroupDelta) string {
+	deltaReport := securityGroupDelta.report()
+
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "<h3>%s</h3>\n", html.EscapeString(deltaReport.Title))
+	builder.WriteString("<table>\n<thead>\n<tr>")
+	for _, header := range deltaReport.Header {
+		fmt.Fprintf(&builder, "<th>%s</th>", html.EscapeString(header))
+	}
+	builder.WriteString("</tr>\n</thead>\n<tbody>\n")
+
+	for _, row := range deltaReport.Rows {
+		builder.WriteString("<tr>\n")
+
+		for _, cell := range row.Cells {
+			builder.WriteString("<td>")
+
+			for _, reportTable := range cell.Tables {
+				builder.WriteString(htmlTable(reportTable))
+			}
+
+			lines := make([]string, 0, len(cell.Lines))
+			for _, line := range cell.Lines {
+				if line != "" {
+					lines = append(lines, html.EscapeString(line))
+				}
+			}
+			builder.WriteString(strings.Join(lines, "<br>"))
+
+			builder.WriteString("</td>\n")
+		}
+
+		builder.WriteString("</tr>\n")
+	}
+
+	builder.WriteString("</tbody>\n</table>\n")
+
+	return builder.String()
+}
+
+func htmlTable(reportTable ReportTable) string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "\n<table>\n<caption>%s</caption>\n<thead>\n<tr>", html.EscapeString(reportTable.Title))
+	for _, header := range reportTable.Header {
+		fmt.Fprintf(&builder, "<th>%s</th>", html.EscapeString(header))
+	}
+	builder.WriteString("</tr>\n</thead>\n<tbody>\n")
+
+	for _, row := range reportTable.Rows {
+		builder.WriteString("<tr>")
+		for i, cell := range row {
+			if reportTable.isRightAligned(i) {
+				fmt.Fprintf(&builder, `<td style="text-align: right">%s</td>`, html.EscapeString(cell))
+			} else {
+				fmt.Fprintf(&builder, "<td>%s</td>", html.EscapeString(cell))
+			}
+		}
+		builder.WriteString("</tr>\n")
+	}
+
+	builder.WriteString("</tbody>\n</table>\n")
+
+	return builder.String()
+}
+
+// PlainRenderer renders text without box-drawing characters, for email and terminals that can't display them
+type PlainRenderer struct{}
+
+func (p *PlainRenderer) Render(securityGroupDelta *SecurityGroupDelta) string {
+	deltaReport := securityGroupDelta.report()
+
+	var builder strings.Builder
+
+	builder.WriteString(deltaReport.Title + "\n")
+	builder.WriteString(strings.Repeat("=", len(deltaReport.Title)) + "\n")
+
+	for _, row := range deltaReport.Rows {
+		builder.WriteString("\n" + row.Title + "\n")
+
+		for i, cell := range row.Cells {
+			if cell.isEmpty() {
+				continue
+			}
+
+			builder.WriteString("  " + deltaReport.Header[i] + "\n")
+
+			for _, reportTable := range cell.Tables {
+				if reportTable.Title != row.Title {
+					builder.WriteString("    " + reportTable.Title + "\n")
+				}
+				builder.WriteString(plainTable(reportTable, "    "))
+			}
+
+			for _, line := range cell.Lines {
+				if line != "" {
+					builder.WriteString("    " + line + "\n")
+				}
+			}
+		}
+	}
+
+	return builder.String()
+}
+
+func plainTable(reportTable ReportTable, indentation string) string {
+	var buffer bytes.Buffer
+
+	tabWriter := tabwriter.NewWriter(&buffer, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tabWriter, indentation+strings.Join(reportTable.Header, "\t"))
+	for _, row := range reportTable.Rows {
+		fmt.Fprintln(tabWriter, indentation+strings.Join(row, "\t"))
+	}
+
+	tabWriter.Flush()
+
+	// Empty cells in the last column leave the padding of the previous column behind
+	lines := strings.SplitAfter(buffer.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \n")
+		if strings.HasSuffix(line, "\n") {
+			lines[i] += "\n"
+		}
+	}
+
+	return strings.Join(lines, "")
+}
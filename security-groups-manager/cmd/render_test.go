@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRenderedSecurityGroupDelta() *SecurityGroupDelta {
+	toBeSecurityGroup := &types.SecurityGroup{
+		Description: aws.String("Web"),
+		GroupId:     aws.String("sg-1"),
+		GroupName:   aws.String("web"),
+		IpPermissions: []types.IpPermission{
+			{IpProtocol: aws.String("tcp"), FromPort: aws.Int32(443), ToPort: aws.Int32(443), IpRanges: []types.IpRange{{CidrIp: aws.String("192.0.2.1/32"), Description: aws.String("Office | HQ")}}},
+		},
+		OwnerId: aws.String("123456789012"),
+		VpcId:   aws.String("vpc-1"),
+	}
+
+	securityGroupDelta := NewSecurityGroupDelta(toBeSecurityGroup)
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{
+		Description: aws.String("Web"),
+		GroupId:     aws.String("sg-1"),
+		GroupName:   aws.String("web"),
+		OwnerId:     aws.String("123456789012"),
+		VpcId:       aws.String("vpc-1"),
+	}
+	securityGroupDelta.IngressRulesToAuthorize = []Rule{{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: ipv4CidrSourceKind, Source: "192.0.2.1/32", Description: "Office | HQ"}}
+	securityGroupDelta.IngressRulesToAuthorizeResult = "Succeeded to authorize inbound rules"
+	securityGroupDelta.RegionName = "eu-west-1"
+
+	return securityGroupDelta
+}
+
+func TestMarkdownRenderer(t *testing.T) {
+	assert.Equal(t, `### web (sg-1, eu-west-1)
+
+#### Security group
+
+**As is**
+
+_web_
+
+| VPC ID | Group ID | Group Name | Description | Owner |
+| --- | --- | --- | --- | --- |
+| vpc-1 | sg-1 | web | Web | 123456789012 |
+
+**To be**
+
+_web_
+
+| VPC ID | Group ID | Group Name | Description | Owner |
+| --- | --- | --- | --- | --- |
+| vpc-1 | sg-1 | web | Web | 123456789012 |
+
+#### Inbound rules
+
+**As is**
+
+| Protocol | Port Range | Source | Description |
+| --- | ---: | --- | --- |
+
+**To be**
+
+| Protocol | Port Range | Source | Description |
+| --- | ---: | --- | --- |
+| TCP | 443 | 192.0.2.1/32 | Office \| HQ |
+
+**Remediation**
+
+_Inbound rules to authorize_
+
+| Protocol | Port Range | Source | Description |
+| --- | ---: | --- | --- |
+| TCP | 443 | 192.0.2.1/32 | Office \| HQ |
+
+**Result**
+
+- Succeeded to authorize inbound rules
+
+#### Outbound rules
+
+**As is**
+
+| Protocol | Port Range | Source | Description |
+| --- | ---: | --- | --- |
+
+**To be**
+
+| Protocol | Port Range | Source | Description |
+| --- | ---: | --- | --- |
+
+#### Tags
+
+**As is**
+
+| Key | Value |
+| --- | --- |
+
+**To be**
+
+| Key | Value |
+| --- | --- |
+`, new(MarkdownRenderer).Render(newTestRenderedSecurityGroupDelta()))
+}
+
+func TestHTMLRenderer(t *testing.T) {
+	securityGroupDelta := newTestRenderedSecurityGroupDelta()
+	securityGroupDelta.IngressRulesToAuthorizeResult = "Failed to authorize inbound rules: <InvalidParameterValue>"
+
+	rendered := new(HTMLRenderer).Render(securityGroupDelta)
+
+	assert.True(t, strings.HasPrefix(rendered, "<h3>web (sg-1, eu-west-1)</h3>\n<table>\n<thead>\n<tr><th>As is</th><th>To be</th><th>Remediation</th><th>Result</th></tr>\n</thead>\n"))
+	assert.Contains(t, rendered, "<caption>Inbound rules to authorize</caption>")
+	assert.Contains(t, rendered, `<tr><td>TCP</td><td style="text-align: right">443</td><td>192.0.2.1/32</td><td>Office | HQ</td></tr>`)
+	assert.Contains(t, rendered, "<td>Failed to authorize inbound rules: &lt;InvalidParameterValue&gt;</td>")
+	assert.Equal(t, strings.Count(rendered, "<table>"), strings.Count(rendered, "</table>"))
+}
+
+func TestPlainRenderer(t *testing.T) {
+	securityGroupDelta := newTestRenderedSecurityGroupDelta()
+	securityGroupDelta.AsIsSecurityGroup = nil
+
+	assert.Equal(t, `web (sg-1, eu-west-1)
+=====================
+
+Security group
+  As is
+    No matching security group found with ID: sg-1 in VPC: vpc-1
+  To be
+    web
+    VPC ID  Group ID  Group Name  Description  Owner
+    vpc-1   sg-1      web         Web          123456789012
+
+Inbound rules
+  To be
+    Protocol  Port Range  Source        Description
+    TCP       443         192.0.2.1/32  Office | HQ
+
+Outbound rules
+  To be
+    Protocol  Port Range  Source  Description
+
+Tags
+  To be
+    Key  Value
+`, new(PlainRenderer).Render(securityGroupDelta))
+}
+
+func TestNewRenderer(t *testing.T) {
+	assert.IsType(t, new(DiffRenderer), newRenderer(diffOutputFormat))
+	assert.IsType(t, new(HTMLRenderer), newRenderer(htmlOutputFormat))
+	assert.IsType(t, new(MarkdownRenderer), newRenderer(markdownOutputFormat))
+	assert.IsType(t, new(PlainRenderer), newRenderer(plainOutputFormat))
+	assert.IsType(t, new(TableRenderer), newRenderer(tableOutputFormat))
+	assert.Nil(t, newRenderer(jsonOutputFormat))
+}
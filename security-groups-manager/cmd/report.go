@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type Report struct {
+	RunId          string
+	SecurityGroups []SecurityGroupReport
+}
+
+func NewReport(runId string, securityGroupDeltas []SecurityGroupDelta) *Report {
+	report := new(Report)
+
+	report.RunId = runId
+	report.SecurityGroups = make([]SecurityGroupReport, 0, len(securityGroupDeltas))
+
+	for i := range securityGroupDeltas {
+		report.SecurityGroups = append(report.SecurityGroups, *NewSecurityGroupReport(&securityGroupDeltas[i]))
+	}
+
+	return report
+}
+
+func (r *Report) marshal() (string, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+type SecurityGroupReport struct {
+	AsIsRules        []Rule
+	GroupId          string
+	GroupName        string
+	GuardViolations  []string
+	PolicyViolations []PolicyViolation
+	QuotaViolations  []string
+	RegionName       string
+	Results          []string
+	RulesToAuthorize []Rule
+	RulesToModify    []RuleModification
+	RulesToRevoke    []Rule
+	RulesToUpdate    []Rule
+	Status           string
+	TagsToCreate     []types.Tag
+	TagsToDelete     []types.Tag
+	VpcId            string
+}
+
+func NewSecurityGroupReport(securityGroupDelta *SecurityGroupDelta) *SecurityGroupReport {
+	securityGroupReport := new(SecurityGroupReport)
+
+	securityGroupReport.AsIsRules = make([]Rule, 0)
+	securityGroupReport.GroupId = aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupId)
+	securityGroupReport.GroupName = aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupName)
+	securityGroupReport.GuardViolations = securityGroupDelta.GuardViolations
+	securityGroupReport.PolicyViolations = securityGroupDelta.PolicyViolations
+	securityGroupReport.QuotaViolations = securityGroupDelta.QuotaViolations
+	securityGroupReport.RegionName = securityGroupDelta.RegionName
+	securityGroupReport.RulesToAuthorize = append(append(make([]Rule, 0), securityGroupDelta.IngressRulesToAuthorize...), securityGroupDelta.EgressRulesToAuthorize...)
+	securityGroupReport.RulesToModify = securityGroupDelta.RulesToModify
+	securityGroupReport.RulesToRevoke = append(append(make([]Rule, 0), securityGroupDelta.IngressRulesToRevoke...), securityGroupDelta.EgressRulesToRevoke...)
+	securityGroupReport.RulesToUpdate = append(append(make([]Rule, 0), securityGroupDelta.IngressRulesToUpdate...), securityGroupDelta.EgressRulesToUpdate...)
+	securityGroupReport.Status = securityGroupDelta.status()
+	securityGroupReport.TagsToCreate = securityGroupDelta.TagsToCreate
+	securityGroupReport.TagsToDelete = securityGroupDelta.TagsToDelete
+	securityGroupReport.VpcId = aws.ToString(securityGroupDelta.ToBeSecurityGroup.VpcId)
+
+	if securityGroupDelta.AsIsSecurityGroup != nil {
+		securityGroupReport.AsIsRules = append(securityGroupDelta.asIsRules(ingressDirection), securityGroupDelta.asIsRules(egressDirection)...)
+	}
+
+	securityGroupReport.Results = securityGroupDelta.results()
+
+	return securityGroupReport
+}
+
+type ValidationReport struct {
+	RunId          string
+	SecurityGroups []SecurityGroupValidation
+}
+
+func NewValidationReport(runId string, toBeSecurityGroups []types.SecurityGroup, policyViolations map[string][]PolicyViolation) *ValidationReport {
+	validationReport := new(ValidationReport)
+
+	validationReport.RunId = runId
+	validationReport.SecurityGroups = make([]SecurityGroupValidation, 0, len(toBeSecurityGroups))
+
+	for _, toBeSecurityGroup := range toBeSecurityGroups {
+		securityGroupPolicyViolations := policyViolations[aws.ToString(toBeSecurityGroup.GroupId)]
+		if securityGroupPolicyViolations == nil {
+			securityGroupPolicyViolations = make([]PolicyViolation, 0)
+		}
+
+		validationReport.SecurityGroups = append(validationReport.SecurityGroups, SecurityGroupValidation{
+			GroupId:          aws.ToString(toBeSecurityGroup.GroupId),
+			GroupName:        aws.ToString(toBeSecurityGroup.GroupName),
+			PolicyViolations: securityGroupPolicyViolations,
+			VpcId:            aws.ToString(toBeSecurityGroup.VpcId),
+		})
+	}
+
+	return validationReport
+}
+
+func (v *ValidationReport) marshal() (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+type SecurityGroupValidation struct {
+	GroupId          string
+	GroupName        string
+	PolicyViolations []PolicyViolation
+	VpcId            string
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	egressDirection  = "egress"
+	ingressDirection = "ingress"
+)
+
+const (
+	ipv4CidrSourceKind      = "ipv4-cidr"
+	ipv6CidrSourceKind      = "ipv6-cidr"
+	prefixListSourceKind    = "prefix-list"
+	securityGroupSourceKind = "security-group"
+)
+
+type Rule struct {
+	Direction   string
+	IpProtocol  string
+	FromPort    int32
+	ToPort      int32
+	SourceKind  string
+	Source      string
+	Description string
+	RuleId      string
+}
+
+type RuleKey struct {
+	Direction  string
+	IpProtocol string
+	FromPort   int32
+	ToPort     int32
+	SourceKind string
+	Source     string
+}
+
+type RuleModification struct {
+	RuleId string
+	From   Rule
+	To     Rule
+}
+
+func newRuleFromSecurityGroupRule(securityGroupRule types.SecurityGroupRule) Rule {
+	rule := Rule{
+		Direction:   ingressDirection,
+		IpProtocol:  normalizeIpProtocol(aws.ToString(securityGroupRule.IpProtocol)),
+		FromPort:    -1,
+		ToPort:      -1,
+		Description: aws.ToString(securityGroupRule.Description),
+		RuleId:      aws.ToString(securityGroupRule.SecurityGroupRuleId),
+	}
+
+	if aws.ToBool(securityGroupRule.IsEgress) {
+		rule.Direction = egressDirection
+	}
+
+	if rule.hasPorts() {
+		if securityGroupRule.FromPort != nil {
+			rule.FromPort = *securityGroupRule.FromPort
+		}
+		if securityGroupRule.ToPort != nil {
+			rule.ToPort = *securityGroupRule.ToPort
+		}
+	}
+
+	switch {
+	case securityGroupRule.CidrIpv4 != nil:
+		rule.SourceKind = ipv4CidrSourceKind
+		rule.Source = normalizeCidr(*securityGroupRule.CidrIpv4)
+	case securityGroupRule.CidrIpv6 != nil:
+		rule.SourceKind = ipv6CidrSourceKind
+		rule.Source = normalizeCidr(*securityGroupRule.CidrIpv6)
+	case securityGroupRule.PrefixListId != nil:
+		rule.SourceKind = prefixListSourceKind
+		rule.Source = *securityGroupRule.PrefixListId
+	case securityGroupRule.ReferencedGroupInfo != nil:
+		rule.SourceKind = securityGroupSourceKind
+		rule.Source = aws.ToString(securityGroupRule.ReferencedGroupInfo.GroupId)
+
+		if userId := aws.ToString(securityGroupRule.ReferencedGroupInfo.UserId); userId != "" {
+			rule.Source = userId + "/" + rule.Source
+		}
+	}
+
+	return rule
+}
+
+func (r Rule) key() RuleKey {
+	return RuleKey{
+		Direction:  r.Direction,
+		IpProtocol: r.IpProtocol,
+		FromPort:   r.FromPort,
+		ToPort:     r.ToPort,
+		SourceKind: r.SourceKind,
+		Source:     r.Source,
+	}
+}
+
+func (r Rule) hasPorts() bool {
+	switch r.IpProtocol {
+	case "tcp", "udp", "icmp", "icmpv6":
+		return true
+	default:
+		return false
+	}
+}
+
+func (r Rule) ipPermission() types.IpPermission {
+	ipPermission := types.IpPermission{
+		IpProtocol: aws.String(r.IpProtocol),
+	}
+
+	if r.hasPorts() {
+		ipPermission.FromPort = aws.Int32(r.FromPort)
+		ipPermission.ToPort = aws.Int32(r.ToPort)
+	}
+
+	var description *string
+	if r.Description != "" {
+		description = aws.String(r.Description)
+	}
+
+	switch r.SourceKind {
+	case ipv4CidrSourceKind:
+		ipPermission.IpRanges = []types.IpRange{
+			{
+				CidrIp:      aws.String(r.Source),
+				Description: description,
+			},
+		}
+	case ipv6CidrSourceKind:
+		ipPermission.Ipv6Ranges = []types.Ipv6Range{
+			{
+				CidrIpv6:    aws.String(r.Source),
+				Description: description,
+			},
+		}
+	case prefixListSourceKind:
+		ipPermission.PrefixListIds = []types.PrefixListId{
+			{
+				Description:  description,
+				PrefixListId: aws.String(r.Source),
+			},
+		}
+	case securityGroupSourceKind:
+		userIdGroupPair := types.UserIdGroupPair{
+			Description: description,
+			GroupId:     aws.String(r.Source),
+		}
+
+		if i := strings.Index(r.Source, "/"); i != -1 {
+			userIdGroupPair.UserId = aws.String(r.Source[:i])
+			userIdGroupPair.GroupId = aws.String(r.Source[i+1:])
+		}
+
+		ipPermission.UserIdGroupPairs = []types.UserIdGroupPair{
+			userIdGroupPair,
+		}
+	}
+
+	return ipPermission
+}
+
+func (r Rule) securityGroupRuleRequest() *types.SecurityGroupRuleRequest {
+	securityGroupRuleRequest := &types.SecurityGroupRuleRequest{
+		Description: aws.String(r.Description),
+		FromPort:    aws.Int32(r.FromPort),
+		IpProtocol:  aws.String(r.IpProtocol),
+		ToPort:      aws.Int32(r.ToPort),
+	}
+
+	switch r.SourceKind {
+	case ipv4CidrSourceKind:
+		securityGroupRuleRequest.CidrIpv4 = aws.String(r.Source)
+	case ipv6CidrSourceKind:
+		securityGroupRuleRequest.CidrIpv6 = aws.String(r.Source)
+	case prefixListSourceKind:
+		securityGroupRuleRequest.PrefixListId = aws.String(r.Source)
+	case securityGroupSourceKind:
+		securityGroupRuleRequest.ReferencedGroupId = aws.String(r.Source[strings.Index(r.Source, "/")+1:])
+	}
+
+	return securityGroupRuleRequest
+}
+
+func diffRules(asIsRules []Rule, toBeRules []Rule) (rulesToRevoke []Rule, rulesToAuthorize []Rule, rulesToUpdate []Rule) {
+	asIsRulesByKey := make(map[RuleKey]Rule, len(asIsRules))
+	for _, asIsRule := range asIsRules {
+		asIsRulesByKey[asIsRule.key()] = asIsRule
+	}
+
+	toBeRulesByKey := make(map[RuleKey]Rule, len(toBeRules))
+	for _, toBeRule := range toBeRules {
+		toBeRulesByKey[toBeRule.key()] = toBeRule
+	}
+
+	rulesToRevoke = make([]Rule, 0)
+	rulesToAuthorize = make([]Rule, 0)
+	rulesToUpdate = make([]Rule, 0)
+
+	for key, asIsRule := range asIsRulesByKey {
+		if _, ok := toBeRulesByKey[key]; !ok {
+			rulesToRevoke = append(rulesToRevoke, asIsRule)
+		}
+	}
+
+	for key, toBeRule := range toBeRulesByKey {
+		asIsRule, ok := asIsRulesByKey[key]
+		if !ok {
+			rulesToAuthorize = append(rulesToAuthorize, toBeRule)
+		} else if asIsRule.Description != toBeRule.Description {
+			toBeRule.RuleId = asIsRule.RuleId
+
+			rulesToUpdate = append(rulesToUpdate, toBeRule)
+		}
+	}
+
+	sortRules(rulesToRevoke)
+	sortRules(rulesToAuthorize)
+	sortRules(rulesToUpdate)
+
+	return rulesToRevoke, rulesToAuthorize, rulesToUpdate
+}
+
+func flattenIpPermissions(direction string, ipPermissions []types.IpPermission, ownerId string) []Rule {
+	rules := make([]Rule, 0, len(ipPermissions))
+
+	for _, ipPermission := range ipPermissions {
+		rule := Rule{
+			Direction:  direction,
+			IpProtocol: aws.ToString(ipPermission.IpProtocol),
+			FromPort:   -1,
+			ToPort:     -1,
+		}
+
+		if ipPermission.FromPort != nil {
+			rule.FromPort = *ipPermission.FromPort
+		}
+		if ipPermission.ToPort != nil {
+			rule.ToPort = *ipPermission.ToPort
+		}
+
+		for _, ipRange := range ipPermission.IpRanges {
+			rule.SourceKind = ipv4CidrSourceKind
+			rule.Source = aws.ToString(ipRange.CidrIp)
+			rule.Description = aws.ToString(ipRange.Description)
+
+			rules = append(rules, rule)
+		}
+
+		for _, ipv6Range := range ipPermission.Ipv6Ranges {
+			rule.SourceKind = ipv6CidrSourceKind
+			rule.Source = aws.ToString(ipv6Range.CidrIpv6)
+			rule.Description = aws.ToString(ipv6Range.Description)
+
+			rules = append(rules, rule)
+		}
+
+		for _, prefixListId := range ipPermission.PrefixListIds {
+			rule.SourceKind = prefixListSourceKind
+			rule.Source = aws.ToString(prefixListId.PrefixListId)
+			rule.Description = aws.ToString(prefixListId.Description)
+
+			rules = append(rules, rule)
+		}
+
+		for _, userIdGroupPair := range ipPermission.UserIdGroupPairs {
+			userId := aws.ToString(userIdGroupPair.UserId)
+			if userId == "" {
+				userId = ownerId
+			}
+
+			rule.SourceKind = securityGroupSourceKind
+			rule.Source = aws.ToString(userIdGroupPair.GroupId)
+			if userId != "" {
+				rule.Source = userId + "/" + rule.Source
+			}
+			rule.Description = aws.ToString(userIdGroupPair.Description)
+
+			rules = append(rules, rule)
+		}
+	}
+
+	sortRules(rules)
+
+	return rules
+}
+
+func ipPermissionsFromRules(rules []Rule) []types.IpPermission {
+	ipPermissions := make([]types.IpPermission, 0, len(rules))
+
+	for _, rule := range rules {
+		ruleIpPermission := rule.ipPermission()
+		merged := false
+
+		for i := range ipPermissions {
+			ipPermission := &ipPermissions[i]
+
+			if *ipPermission.IpProtocol == *ruleIpPermission.IpProtocol &&
+				compareInt32Pointers(ipPermission.FromPort, ruleIpPermission.FromPort) == 0 &&
+				compareInt32Pointers(ipPermission.ToPort, ruleIpPermission.ToPort) == 0 {
+				ipPermission.IpRanges = append(ipPermission.IpRanges, ruleIpPermission.IpRanges...)
+				ipPermission.Ipv6Ranges = append(ipPermission.Ipv6Ranges, ruleIpPermission.Ipv6Ranges...)
+				ipPermission.PrefixListIds = append(ipPermission.PrefixListIds, ruleIpPermission.PrefixListIds...)
+				ipPermission.UserIdGroupPairs = append(ipPermission.UserIdGroupPairs, ruleIpPermission.UserIdGroupPairs...)
+
+				merged = true
+
+				break
+			}
+		}
+
+		if !merged {
+			ipPermissions = append(ipPermissions, ruleIpPermission)
+		}
+	}
+
+	return ipPermissions
+}
+
+func splitRuleModifications(asIsRules []Rule, rulesToRevoke []Rule, rulesToAuthorize []Rule, rulesToUpdate []Rule) (ruleModifications []RuleModification, remainingRulesToRevoke []Rule, remainingRulesToAuthorize []Rule, remainingRulesToUpdate []Rule) {
+	asIsRulesByRuleId := make(map[string]Rule, len(asIsRules))
+	for _, asIsRule := range asIsRules {
+		if asIsRule.RuleId != "" {
+			asIsRulesByRuleId[asIsRule.RuleId] = asIsRule
+		}
+	}
+
+	ruleModifications = make([]RuleModification, 0)
+	remainingRulesToRevoke = make([]Rule, 0, len(rulesToRevoke))
+	remainingRulesToAuthorize = make([]Rule, 0, len(rulesToAuthorize))
+	remainingRulesToUpdate = make([]Rule, 0, len(rulesToUpdate))
+
+	for _, ruleToUpdate := range rulesToUpdate {
+		if asIsRule, ok := asIsRulesByRuleId[ruleToUpdate.RuleId]; ok {
+			ruleModifications = append(ruleModifications, RuleModification{
+				RuleId: ruleToUpdate.RuleId,
+				From:   asIsRule,
+				To:     ruleToUpdate,
+			})
+		} else {
+			remainingRulesToUpdate = append(remainingRulesToUpdate, ruleToUpdate)
+		}
+	}
+
+	authorizedRules := make([]bool, len(rulesToAuthorize))
+
+	for _, ruleToRevoke := range rulesToRevoke {
+		ruleModified := false
+
+		if ruleToRevoke.RuleId != "" && ruleToRevoke.SourceKind != securityGroupSourceKind {
+			for i, ruleToAuthorize := range rulesToAuthorize {
+				if !authorizedRules[i] &&
+					ruleToAuthorize.Direction == ruleToRevoke.Direction &&
+					ruleToAuthorize.IpProtocol == ruleToRevoke.IpProtocol &&
+					ruleToAuthorize.FromPort == ruleToRevoke.FromPort &&
+					ruleToAuthorize.ToPort == ruleToRevoke.ToPort &&
+					ruleToAuthorize.SourceKind == ruleToRevoke.SourceKind {
+					authorizedRules[i] = true
+					ruleToAuthorize.RuleId = ruleToRevoke.RuleId
+
+					ruleModifications = append(ruleModifications, RuleModification{
+						RuleId: ruleToRevoke.RuleId,
+						From:   ruleToRevoke,
+						To:     ruleToAuthorize,
+					})
+
+					ruleModified = true
+
+					break
+				}
+			}
+		}
+
+		if !ruleModified {
+			remainingRulesToRevoke = append(remainingRulesToRevoke, ruleToRevoke)
+		}
+	}
+
+	for i, ruleToAuthorize := range rulesToAuthorize {
+		if !authorizedRules[i] {
+			remainingRulesToAuthorize = append(remainingRulesToAuthorize, ruleToAuthorize)
+		}
+	}
+
+	sort.SliceStable(ruleModifications, func(i int, j int) bool {
+		return ruleModifications[i].RuleId < ruleModifications[j].RuleId
+	})
+
+	return ruleModifications, remainingRulesToRevoke, remainingRulesToAuthorize, remainingRulesToUpdate
+}
+
+func sortRules(rules []Rule) {
+	sort.SliceStable(rules, func(i int, j int) bool {
+		if rules[i].Direction != rules[j].Direction {
+			return rules[i].Direction < rules[j].Direction
+		}
+		if rules[i].IpProtocol != rules[j].IpProtocol {
+			return rules[i].IpProtocol < rules[j].IpProtocol
+		}
+		if rules[i].FromPort != rules[j].FromPort {
+			return rules[i].FromPort < rules[j].FromPort
+		}
+		if rules[i].ToPort != rules[j].ToPort {
+			return rules[i].ToPort < rules[j].ToPort
+		}
+		if rules[i].SourceKind != rules[j].SourceKind {
+			return rules[i].SourceKind < rules[j].SourceKind
+		}
+		if rules[i].Source != rules[j].Source {
+			return rules[i].Source < rules[j].Source
+		}
+		if rules[i].Description != rules[j].Description {
+			return rules[i].Description < rules[j].Description
+		}
+
+		return rules[i].RuleId < rules[j].RuleId
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffRules(t *testing.T) {
+	ssh := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 22, ToPort: 22, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.1/32", Description: "Home"}
+	sshRenamed := ssh
+	sshRenamed.Description = "Office"
+	http := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 80, ToPort: 80, SourceKind: ipv6CidrSourceKind, Source: "::/0"}
+	peer := Rule{Direction: ingressDirection, IpProtocol: "-1", FromPort: -1, ToPort: -1, SourceKind: securityGroupSourceKind, Source: "123456789012/sg-1"}
+
+	tests := []struct {
+		name          string
+		asIs          []Rule
+		toBe          []Rule
+		wantRevoke    []Rule
+		wantAuthorize []Rule
+		wantUpdate    []Rule
+	}{
+		{
+			name:          "No change",
+			asIs:          []Rule{ssh, http},
+			toBe:          []Rule{http, ssh},
+			wantRevoke:    []Rule{},
+			wantAuthorize: []Rule{},
+			wantUpdate:    []Rule{},
+		},
+		{
+			name:          "Authorize",
+			asIs:          []Rule{ssh},
+			toBe:          []Rule{ssh, http, peer},
+			wantRevoke:    []Rule{},
+			wantAuthorize: []Rule{peer, http},
+			wantUpdate:    []Rule{},
+		},
+		{
+			name:          "Revoke",
+			asIs:          []Rule{ssh, http, peer},
+			toBe:          []Rule{http},
+			wantRevoke:    []Rule{peer, ssh},
+			wantAuthorize: []Rule{},
+			wantUpdate:    []Rule{},
+		},
+		{
+			name:          "Update description",
+			asIs:          []Rule{ssh},
+			toBe:          []Rule{sshRenamed},
+			wantRevoke:    []Rule{},
+			wantAuthorize: []Rule{},
+			wantUpdate:    []Rule{sshRenamed},
+		},
+		{
+			name:          "Empty",
+			wantRevoke:    []Rule{},
+			wantAuthorize: []Rule{},
+			wantUpdate:    []Rule{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotRevoke, gotAuthorize, gotUpdate := diffRules(test.asIs, test.toBe)
+
+			assert.Equal(t, test.wantRevoke, gotRevoke)
+			assert.Equal(t, test.wantAuthorize, gotAuthorize)
+			assert.Equal(t, test.wantUpdate, gotUpdate)
+		})
+	}
+}
+
+func TestFlattenIpPermissions(t *testing.T) {
+	ipPermissions := []types.IpPermission{
+		{
+			FromPort:   aws.Int32(443),
+			IpProtocol: aws.String("tcp"),
+			IpRanges: []types.IpRange{
+				{CidrIp: aws.String("10.0.0.0/8"), Description: aws.String("Internal")},
+			},
+			PrefixListIds: []types.PrefixListId{
+				{PrefixListId: aws.String("pl-1")},
+			},
+			ToPort: aws.Int32(443),
+			UserIdGroupPairs: []types.UserIdGroupPair{
+				{GroupId: aws.String("sg-1")},
+				{GroupId: aws.String("sg-2"), UserId: aws.String("210987654321")},
+			},
+		},
+		{
+			IpProtocol: aws.String("-1"),
+			Ipv6Ranges: []types.Ipv6Range{
+				{CidrIpv6: aws.String("::/0")},
+			},
+		},
+	}
+
+	rules := flattenIpPermissions(egressDirection, ipPermissions, "123456789012")
+
+	assert.Equal(t, []Rule{
+		{Direction: egressDirection, IpProtocol: "-1", FromPort: -1, ToPort: -1, SourceKind: ipv6CidrSourceKind, Source: "::/0"},
+		{Direction: egressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.0/8", Description: "Internal"},
+		{Direction: egressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: prefixListSourceKind, Source: "pl-1"},
+		{Direction: egressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: securityGroupSourceKind, Source: "123456789012/sg-1"},
+		{Direction: egressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: securityGroupSourceKind, Source: "210987654321/sg-2"},
+	}, rules)
+
+	assert.Equal(t, rules, flattenIpPermissions(egressDirection, ipPermissionsFromRules(rules), "123456789012"))
+}
+
+func TestNewRuleFromSecurityGroupRule(t *testing.T) {
+	tests := []struct {
+		name              string
+		securityGroupRule types.SecurityGroupRule
+		want              Rule
+	}{
+		{
+			name: "IPv4 ingress",
+			securityGroupRule: types.SecurityGroupRule{
+				CidrIpv4:            aws.String("10.0.0.5/24"),
+				Description:         aws.String("Office"),
+				FromPort:            aws.Int32(22),
+				IpProtocol:          aws.String("6"),
+				IsEgress:            aws.Bool(false),
+				SecurityGroupRuleId: aws.String("sgr-1"),
+				ToPort:              aws.Int32(22),
+			},
+			want: Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 22, ToPort: 22, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.0/24", Description: "Office", RuleId: "sgr-1"},
+		},
+		{
+			name: "All traffic egress",
+			securityGroupRule: types.SecurityGroupRule{
+				CidrIpv6:            aws.String("::/0"),
+				FromPort:            aws.Int32(-1),
+				IpProtocol:          aws.String("-1"),
+				IsEgress:            aws.Bool(true),
+				SecurityGroupRuleId: aws.String("sgr-2"),
+				ToPort:              aws.Int32(-1),
+			},
+			want: Rule{Direction: egressDirection, IpProtocol: "-1", FromPort: -1, ToPort: -1, SourceKind: ipv6CidrSourceKind, Source: "::/0", RuleId: "sgr-2"},
+		},
+		{
+			name: "Referenced security group",
+			securityGroupRule: types.SecurityGroupRule{
+				FromPort:   aws.Int32(443),
+				IpProtocol: aws.String("tcp"),
+				IsEgress:   aws.Bool(false),
+				ReferencedGroupInfo: &types.ReferencedSecurityGroup{
+					GroupId: aws.String("sg-1"),
+					UserId:  aws.String("123456789012"),
+				},
+				SecurityGroupRuleId: aws.String("sgr-3"),
+				ToPort:              aws.Int32(443),
+			},
+			want: Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: securityGroupSourceKind, Source: "123456789012/sg-1", RuleId: "sgr-3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, newRuleFromSecurityGroupRule(test.securityGroupRule))
+		})
+	}
+}
+
+func TestSplitRuleModifications(t *testing.T) {
+	oldHome := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 22, ToPort: 22, SourceKind: ipv4CidrSourceKind, Source: "1.2.3.4/32", Description: "Home", RuleId: "sgr-1"}
+	newHome := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 22, ToPort: 22, SourceKind: ipv4CidrSourceKind, Source: "5.6.7.8/32", Description: "Home"}
+	web := Rule{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 80, ToPort: 80, SourceKind: ipv4CidrSourceKind, Source: "0.0.0.0/0", Description: "Web", RuleId: "sgr-2"}
+	webRenamed := web
+	webRenamed.Description = "HTTP"
+	legacy := Rule{Direction: ingressDirection, IpProtocol: "udp", FromPort: 53, ToPort: 53, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.0/8"}
+	newHomeModified := newHome
+	newHomeModified.RuleId = "sgr-1"
+
+	asIsRules := []Rule{oldHome, web}
+	rulesToRevoke, rulesToAuthorize, rulesToUpdate := diffRules(asIsRules, []Rule{newHome, webRenamed, legacy})
+
+	ruleModifications, rulesToRevoke, rulesToAuthorize, rulesToUpdate := splitRuleModifications(asIsRules, rulesToRevoke, rulesToAuthorize, rulesToUpdate)
+
+	assert.Equal(t, []RuleModification{
+		{RuleId: "sgr-1", From: oldHome, To: newHomeModified},
+		{RuleId: "sgr-2", From: web, To: webRenamed},
+	}, ruleModifications)
+	assert.Equal(t, []Rule{}, rulesToRevoke)
+	assert.Equal(t, []Rule{legacy}, rulesToAuthorize)
+	assert.Equal(t, []Rule{}, rulesToUpdate)
+}
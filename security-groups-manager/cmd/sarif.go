@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const sarifArtifactUriEnvironmentVariableName = "SARIF_ARTIFACT_URI"
+
+const defaultSARIFArtifactUri = "configuration.json"
+
+const (
+	sarifInformationUri = "https://github.com/sfanous/SecurityGroupsManager"
+	sarifSchema         = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion        = "2.1.0"
+)
+
+const (
+	errorSARIFLevel   = "error"
+	warningSARIFLevel = "warning"
+)
+
+const (
+	driftSARIFRuleId    = "drift"
+	notFoundSARIFRuleId = "security-group-not-found"
+)
+
+var sarifRuleDescriptions = map[string]string{
+	driftSARIFRuleId:    "The security group differs from its configuration",
+	notFoundSARIFRuleId: "No security group matches the configured security group",
+}
+
+// SARIFLog holds the policy violations and drift of a run in the Static Analysis Results Interchange Format 2.1.0,
+// pointing every result at the configuration element it was found in
+type SARIFLog struct {
+	Runs    []SARIFRun `json:"runs"`
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+
+	artifactUri string
+}
+
+type SARIFRun struct {
+	ColumnKind string            `json:"columnKind"`
+	Properties map[string]string `json:"properties,omitempty"`
+	Results    []SARIFResult     `json:"results"`
+	Tool       SARIFTool         `json:"tool"`
+}
+
+type SARIFTool struct {
+	Driver SARIFDriver `json:"driver"`
+}
+
+type SARIFDriver struct {
+	InformationUri string      `json:"informationUri"`
+	Name           string      `json:"name"`
+	Rules          []SARIFRule `json:"rules"`
+}
+
+type SARIFRule struct {
+	Id               string       `json:"id"`
+	ShortDescription SARIFMessage `json:"shortDescription"`
+}
+
+type SARIFResult struct {
+	Level     string          `json:"level"`
+	Locations []SARIFLocation `json:"locations"`
+	Message   SARIFMessage    `json:"message"`
+	RuleId    string          `json:"ruleId"`
+}
+
+type SARIFMessage struct {
+	Text string `json:"text"`
+}
+
+type SARIFLocation struct {
+	LogicalLocations []SARIFLogicalLocation `json:"logicalLocations,omitempty"`
+	PhysicalLocation SARIFPhysicalLocation  `json:"physicalLocation"`
+}
+
+type SARIFLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+type SARIFPhysicalLocation struct {
+	ArtifactLocation SARIFArtifactLocation `json:"artifactLocation"`
+	Region           *SARIFRegion          `json:"region,omitempty"`
+}
+
+type SARIFArtifactLocation struct {
+	Uri string `json:"uri"`
+}
+
+type SARIFRegion struct {
+	StartColumn int `json:"startColumn"`
+	StartLine   int `json:"startLine"`
+}
+
+// NewSARIFLog returns a SARIFLog with a single run. The run ID is kept in the properties of the run rather than its
+// automationDetails, which code scanning uses to tell analyses apart
+func NewSARIFLog(artifactUri string, runId string) *SARIFLog {
+	sarifLog := new(SARIFLog)
+
+	sarifLog.Runs = []SARIFRun{
+		{
+			ColumnKind: "unicodeCodePoints",
+			Results:    make([]SARIFResult, 0),
+			Tool: SARIFTool{
+				Driver: SARIFDriver{
+					InformationUri: sarifInformationUri,
+					Name:           managedByTagValue,
+					Rules:          make([]SARIFRule, 0),
+				},
+			},
+		},
+	}
+	if runId != "" {
+		sarifLog.Runs[0].Properties = map[string]string{"runId": runId}
+	}
+	sarifLog.Schema = sarifSchema
+	sarifLog.Version = sarifVersion
+	sarifLog.artifactUri = artifactUri
+
+	return sarifLog
+}
+
+// addPolicyViolations adds a result for every policy violation, located at its IpPermission when known
+func (s *SARIFLog) addPolicyViolations(title string, policyViolations []PolicyViolation, configuredSecurityGroup SecurityGroup) {
+	for _, policyViolation := range policyViolations {
+		level := warningSARIFLevel
+		if policyViolation.Severity == errorSeverity {
+			level = errorSARIFLevel
+		}
+
+		message := fmt.Sprintf("%s: %s", title, policyViolation.Message)
+		if policyViolation.IpPermission != "" {
+			message = fmt.Sprintf("%s: %s (%s)", title, policyViolation.Message, policyViolation.IpPermission)
+		}
+
+		location := policyViolation.location
+		if location == nil {
+			location = configuredSecurityGroup.location
+		}
+
+		s.addResult(policyViolation.Rule, level, message, location)
+	}
+}
+
+// addSecurityGroupDelta adds the policy violations of the delta, then a result for every rule and tag it changes
+func (s *SARIFLog) addSecurityGroupDelta(securityGroupDelta *SecurityGroupDelta, configuredSecurityGroup SecurityGroup) {
+	title := securityGroupDelta.title()
+
+	s.addPolicyViolations(title, securityGroupDelta.PolicyViolations, configuredSecurityGroup)
+
+	if securityGroupDelta.AsIsSecurityGroup == nil {
+		s.addResult(notFoundSARIFRuleId, errorSARIFLevel, fmt.Sprintf("%s: No matching security group found in VPC: %s", title, aws.ToString(securityGroupDelta.ToBeSecurityGroup.VpcId)), configuredSecurityGroup.location)
+
+		return
+	}
+
+	ownerId := aws.ToString(securityGroupDelta.AsIsSecurityGroup.OwnerId)
+
+	for _, rules := range []struct {
+		operation string
+		rules     []Rule
+	}{
+		{"revoke", securityGroupDelta.IngressRulesToRevoke},
+		{"authorize", securityGroupDelta.IngressRulesToAuthorize},
+		{"update", securityGroupDelta.IngressRulesToUpdate},
+		{"revoke", securityGroupDelta.EgressRulesToRevoke},
+		{"authorize", securityGroupDelta.EgressRulesToAuthorize},
+		{"update", securityGroupDelta.EgressRulesToUpdate},
+	} {
+		for _, rule := range rules.rules {
+			s.addResult(driftSARIFRuleId, warningSARIFLevel, fmt.Sprintf("%s: %s %s", title, rules.operation, summarizeRule(rule)), locateRule(rule, configuredSecurityGroup, ownerId))
+		}
+	}
+
+	for _, ruleModification := range securityGroupDelta.RulesToModify {
+		s.addResult(driftSARIFRuleId, warningSARIFLevel, fmt.Sprintf("%s: modify %s", title, summarizeRuleModification(ruleModification)), locateRule(ruleModification.To, configuredSecurityGroup, ownerId))
+	}
+
+	for _, tags := range []struct {
+		operation string
+		tags      []types.Tag
+	}{
+		{"delete", securityGroupDelta.TagsToDelete},
+		{"create", securityGroupDelta.TagsToCreate},
+	} {
+		for _, tag := range summarizeTags(sortTags(tags.tags)) {
+			s.addResult(driftSARIFRuleId, warningSARIFLevel, fmt.Sprintf("%s: %s tag %s", title, tags.operation, tag), configuredSecurityGroup.location)
+		}
+	}
+}
+
+func (s *SARIFLog) addResult(ruleId string, level string, message string, location *ConfigurationLocation) {
+	run := &s.Runs[0]
+
+	sarifLocation := SARIFLocation{
+		PhysicalLocation: SARIFPhysicalLocation{
+			ArtifactLocation: SARIFArtifactLocation{Uri: s.artifactUri},
+		},
+	}
+	if location != nil {
+		sarifLocation.LogicalLocations = []SARIFLogicalLocation{{FullyQualifiedName: location.Path}}
+		sarifLocation.PhysicalLocation.Region = &SARIFRegion{StartColumn: location.Column, StartLine: location.Line}
+	}
+
+	run.Results = append(run.Results, SARIFResult{
+		Level:     level,
+		Locations: []SARIFLocation{sarifLocation},
+		Message:   SARIFMessage{Text: message},
+		RuleId:    ruleId,
+	})
+
+	for _, rule := range run.Tool.Driver.Rules {
+		if rule.Id == ruleId {
+			return
+		}
+	}
+
+	run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, SARIFRule{Id: ruleId, ShortDescription: SARIFMessage{Text: sarifRuleDescription(ruleId)}})
+	sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool {
+		return run.Tool.Driver.Rules[i].Id < run.Tool.Driver.Rules[j].Id
+	})
+}
+
+func (s *SARIFLog) marshal() (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// locateRule returns the location of the configured IpPermission the rule was flattened from, or of the configured
+// security group when no IpPermission matches, as for rules to revoke
+func locateRule(rule Rule, configuredSecurityGroup SecurityGroup, ownerId string) *ConfigurationLocation {
+	configuredIpPermissions := configuredSecurityGroup.IpPermissions
+	if rule.Direction == egressDirection {
+		configuredIpPermissions = configuredSecurityGroup.IpPermissionsEgress
+	}
+
+	for _, configuredIpPermission := range configuredIpPermissions {
+		if configuredIpPermission.location == nil {
+			continue
+		}
+
+		b, err := json.Marshal(configuredIpPermission)
+		if err != nil {
+			continue
+		}
+
+		ipPermissions := make([]types.IpPermission, 1)
+		if err := json.Unmarshal(b, &ipPermissions[0]); err != nil {
+			continue
+		}
+
+		for _, configuredRule := range flattenIpPermissions(rule.Direction, normalizeIpPermissions(ipPermissions), ownerId) {
+			if configuredRule.key() == rule.key() {
+				return configuredIpPermission.location
+			}
+		}
+	}
+
+	return configuredSecurityGroup.location
+}
+
+func sarifRuleDescription(ruleId string) string {
+	if description, ok := sarifRuleDescriptions[ruleId]; ok {
+		return description
+	}
+
+	for _, policyRule := range defaultPolicyRules {
+		if policyRule.Name == ruleId {
+			return policyRule.Description
+		}
+	}
+
+	return fmt.Sprintf("Custom policy %s", ruleId)
+}
+
+func initSARIFArtifactUri() string {
+	return lookupOptionalEnvironmentVariable(sarifArtifactUriEnvironmentVariableName, lookupOptionalEnvironmentVariable(configurationPathEnvironmentVariableName, defaultSARIFArtifactUri))
+}
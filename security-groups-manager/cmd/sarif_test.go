@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSARIFLog(t *testing.T) {
+	configuration, err := NewConfiguration(`{
+  "SecurityGroups": [
+    {
+      "GroupId": "sg-1",
+      "GroupName": "web",
+      "IpPermissions": [
+        {"IpProtocol": "tcp", "FromPort": 22, "ToPort": 22, "IpRanges": [{"CidrIp": "0.0.0.0/0"}]},
+        {"IpProtocol": "TCP", "FromPort": 443, "ToPort": 443, "IpRanges": [{"CidrIp": "192.0.2.1/32"}]}
+      ]
+    },
+    {"GroupId": "sg-2", "GroupName": "db"}
+  ]
+}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	configuredSecurityGroup := configuration.SecurityGroups[0]
+
+	securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-1"), GroupName: aws.String("web")})
+	securityGroupDelta.AsIsSecurityGroup = &types.SecurityGroup{GroupId: aws.String("sg-1"), OwnerId: aws.String("123456789012")}
+	securityGroupDelta.IngressRulesToAuthorize = []Rule{{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 443, ToPort: 443, SourceKind: ipv4CidrSourceKind, Source: "192.0.2.1/32"}}
+	securityGroupDelta.IngressRulesToRevoke = []Rule{{Direction: ingressDirection, IpProtocol: "tcp", FromPort: 80, ToPort: 80, SourceKind: ipv4CidrSourceKind, Source: "10.0.0.1/32"}}
+	securityGroupDelta.PolicyViolations = append(NewPolicy(&PolicyConfiguration{Severities: map[string]string{sshOpenToInternetPolicyRule: errorSeverity}}).lint(configuredSecurityGroup), PolicyViolation{Message: "Not allowed", Rule: "custom", Severity: warningSeverity})
+	securityGroupDelta.TagsToCreate = []types.Tag{{Key: aws.String("Team"), Value: aws.String("platform")}}
+
+	notFoundSecurityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{GroupId: aws.String("sg-2"), GroupName: aws.String("db"), VpcId: aws.String("vpc-1")})
+
+	sarifLog := NewSARIFLog("configuration.json", "01ARYZ6S41ZZZZZZZZZZZZZZZZ")
+	sarifLog.addSecurityGroupDelta(securityGroupDelta, configuredSecurityGroup)
+	sarifLog.addSecurityGroupDelta(notFoundSecurityGroupDelta, configuration.SecurityGroups[1])
+
+	marshaledSARIFLog, err := sarifLog.marshal()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var sarif struct {
+		Runs []struct {
+			Properties struct {
+				RunId string
+			}
+			Results []struct {
+				Level     string
+				Locations []struct {
+					LogicalLocations []struct {
+						FullyQualifiedName string
+					}
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							Uri string
+						}
+						Region struct {
+							StartLine int
+						}
+					}
+				}
+				Message struct {
+					Text string
+				}
+				RuleId string
+			}
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						Id string
+					}
+				}
+			}
+		}
+		Version string
+	}
+	if !assert.NoError(t, json.Unmarshal([]byte(marshaledSARIFLog), &sarif)) {
+		return
+	}
+
+	assert.Equal(t, "2.1.0", sarif.Version)
+	assert.Equal(t, "01ARYZ6S41ZZZZZZZZZZZZZZZZ", sarif.Runs[0].Properties.RunId)
+	assert.Contains(t, marshaledSARIFLog, `"$schema":"https://json.schemastore.org/sarif-2.1.0.json"`)
+
+	type result struct {
+		Level   string
+		Line    int
+		Message string
+		Path    string
+		RuleId  string
+	}
+
+	results := make([]result, 0)
+	for _, sarifResult := range sarif.Runs[0].Results {
+		if assert.Len(t, sarifResult.Locations, 1) {
+			assert.Equal(t, "configuration.json", sarifResult.Locations[0].PhysicalLocation.ArtifactLocation.Uri)
+
+			results = append(results, result{
+				Level:   sarifResult.Level,
+				Line:    sarifResult.Locations[0].PhysicalLocation.Region.StartLine,
+				Message: sarifResult.Message.Text,
+				Path:    sarifResult.Locations[0].LogicalLocations[0].FullyQualifiedName,
+				RuleId:  sarifResult.RuleId,
+			})
+		}
+	}
+
+	assert.Equal(t, []result{
+		{errorSARIFLevel, 7, "web (sg-1): SSH (TCP 22) is allowed from the internet (TCP 22)", "SecurityGroups[0].IpPermissions[0]", sshOpenToInternetPolicyRule},
+		{warningSARIFLevel, 3, "web (sg-1): Not allowed", "SecurityGroups[0]", "custom"},
+		{warningSARIFLevel, 3, "web (sg-1): revoke inbound TCP 80 from 10.0.0.1/32", "SecurityGroups[0]", driftSARIFRuleId},
+		{warningSARIFLevel, 8, "web (sg-1): authorize inbound TCP 443 from 192.0.2.1/32", "SecurityGroups[0].IpPermissions[1]", driftSARIFRuleId},
+		{warningSARIFLevel, 3, "web (sg-1): create tag Team=platform", "SecurityGroups[0]", driftSARIFRuleId},
+		{errorSARIFLevel, 11, "db (sg-2): No matching security group found in VPC: vpc-1", "SecurityGroups[1]", notFoundSARIFRuleId},
+	}, results)
+
+	ruleIds := make([]string, 0)
+	for _, rule := range sarif.Runs[0].Tool.Driver.Rules {
+		ruleIds = append(ruleIds, rule.Id)
+	}
+	assert.Equal(t, []string{"custom", driftSARIFRuleId, notFoundSARIFRuleId, sshOpenToInternetPolicyRule}, ruleIds)
+}
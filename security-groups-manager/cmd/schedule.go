@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// activeWindow is a recurring weekly schedule such as "Mon-Fri 08:00-18:00 Europe/London". A window whose end is
+// before its start spans midnight and ends on the following day
+type activeWindow struct {
+	Days     map[time.Weekday]bool
+	End      time.Duration
+	Location *time.Location
+	Start    time.Duration
+}
+
+// parseActiveWindow parses "<days> <HH:MM>-<HH:MM> [time zone]". Days are a comma separated list of days or day
+// ranges (Mon-Fri,Sun) or * for every day. The time zone defaults to UTC
+func parseActiveWindow(schedule string) (*activeWindow, error) {
+	fields := strings.Fields(schedule)
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, fmt.Errorf("expected \"<days> <HH:MM>-<HH:MM> [time zone]\" but got %q", schedule)
+	}
+
+	window := new(activeWindow)
+
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	window.Days = days
+
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return nil, fmt.Errorf("invalid time range %q", fields[1])
+	}
+
+	if window.Start, err = parseTimeOfDay(times[0]); err != nil {
+		return nil, err
+	}
+	if window.End, err = parseTimeOfDay(times[1]); err != nil {
+		return nil, err
+	}
+
+	window.Location = time.UTC
+	if len(fields) == 3 {
+		if window.Location, err = time.LoadLocation(fields[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	return window, nil
+}
+
+func parseDays(days string) (map[time.Weekday]bool, error) {
+	parsedDays := make(map[time.Weekday]bool)
+
+	if days == "*" {
+		for _, weekday := range weekdays {
+			parsedDays[weekday] = true
+		}
+
+		return parsedDays, nil
+	}
+
+	for _, dayRange := range strings.Split(days, ",") {
+		bounds := strings.SplitN(dayRange, "-", 2)
+
+		first, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", bounds[0])
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return nil, fmt.Errorf("invalid day %q", bounds[1])
+			}
+		}
+
+		for weekday := first; ; weekday = (weekday + 1) % 7 {
+			parsedDays[weekday] = true
+
+			if weekday == last {
+				break
+			}
+		}
+	}
+
+	return parsedDays, nil
+}
+
+func parseTimeOfDay(timeOfDay string) (time.Duration, error) {
+	parsedTime, err := time.Parse("15:04", timeOfDay)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", timeOfDay)
+	}
+
+	return time.Duration(parsedTime.Hour())*time.Hour + time.Duration(parsedTime.Minute())*time.Minute, nil
+}
+
+func (a *activeWindow) isActive(now time.Time) bool {
+	localNow := now.In(a.Location)
+	sinceMidnight := time.Duration(localNow.Hour())*time.Hour + time.Duration(localNow.Minute())*time.Minute + time.Duration(localNow.Second())*time.Second
+
+	if a.Start < a.End {
+		return a.Days[localNow.Weekday()] && a.Start <= sinceMidnight && sinceMidnight < a.End
+	}
+
+	// The window spans midnight
+	if sinceMidnight >= a.Start {
+		return a.Days[localNow.Weekday()]
+	}
+
+	return sinceMidnight < a.End && a.Days[(localNow.Weekday()+6)%7]
+}
+
+// isActive reports whether a source with the given ExpiresAt and ActiveWindow is active at now. A source with an
+// invalid ExpiresAt or ActiveWindow is never active
+func isActive(expiresAt *string, schedule *string, now time.Time) bool {
+	if expiresAt != nil {
+		expiry, err := time.Parse(time.RFC3339, *expiresAt)
+		if err != nil {
+			logger.Warnf("Unable to parse ExpiresAt %s: %v", *expiresAt, err)
+
+			return false
+		}
+
+		if !now.Before(expiry) {
+			return false
+		}
+	}
+
+	if schedule != nil {
+		window, err := parseActiveWindow(*schedule)
+		if err != nil {
+			logger.Warnf("Unable to parse ActiveWindow %s: %v", *schedule, err)
+
+			return false
+		}
+
+		if !window.isActive(now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// removeInactiveSources removes the IpPermissions, Hosts, IpRanges and Ipv6Ranges that aren't active at now
+func (s *SecurityGroup) removeInactiveSources(now time.Time) {
+	s.IpPermissions = removeInactiveIpPermissions(s.IpPermissions, now)
+	s.IpPermissionsEgress = removeInactiveIpPermissions(s.IpPermissionsEgress, now)
+}
+
+func removeInactiveIpPermissions(ipPermissions []IpPermission, now time.Time) []IpPermission {
+	if ipPermissions == nil {
+		return nil
+	}
+
+	activeIpPermissions := make([]IpPermission, 0, len(ipPermissions))
+
+	for _, ipPermission := range ipPermissions {
+		if !isActive(ipPermission.ExpiresAt, ipPermission.ActiveWindow, now) {
+			continue
+		}
+
+		if ipPermission.Hosts != nil {
+			activeHosts := make([]Host, 0, len(ipPermission.Hosts))
+			for _, host := range ipPermission.Hosts {
+				if isActive(host.ExpiresAt, host.ActiveWindow, now) {
+					activeHosts = append(activeHosts, host)
+				}
+			}
+			ipPermission.Hosts = activeHosts
+		}
+
+		if ipPermission.IpRanges != nil {
+			activeIpRanges := make([]IpRange, 0, len(ipPermission.IpRanges))
+			for _, ipRange := range ipPermission.IpRanges {
+				if isActive(ipRange.ExpiresAt, ipRange.ActiveWindow, now) {
+					activeIpRanges = append(activeIpRanges, ipRange)
+				}
+			}
+			ipPermission.IpRanges = activeIpRanges
+		}
+
+		if ipPermission.Ipv6Ranges != nil {
+			activeIpv6Ranges := make([]Ipv6Range, 0, len(ipPermission.Ipv6Ranges))
+			for _, ipv6Range := range ipPermission.Ipv6Ranges {
+				if isActive(ipv6Range.ExpiresAt, ipv6Range.ActiveWindow, now) {
+					activeIpv6Ranges = append(activeIpv6Ranges, ipv6Range)
+				}
+			}
+			ipPermission.Ipv6Ranges = activeIpv6Ranges
+		}
+
+		activeIpPermissions = append(activeIpPermissions, ipPermission)
+	}
+
+	return activeIpPermissions
+}
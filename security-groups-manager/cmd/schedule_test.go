@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsActive(t *testing.T) {
+	// Wednesday
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		expiresAt *string
+		schedule  *string
+		want      bool
+	}{
+		{name: "No restrictions", want: true},
+		{name: "Not yet expired", expiresAt: aws.String("2021-09-01T12:00:01Z"), want: true},
+		{name: "Expired", expiresAt: aws.String("2021-09-01T12:00:00Z"), want: false},
+		{name: "Expired in another time zone", expiresAt: aws.String("2021-09-01T13:00:00+02:00"), want: false},
+		{name: "Invalid expiry", expiresAt: aws.String("tomorrow"), want: false},
+		{name: "Within weekdays", schedule: aws.String("Mon-Fri 08:00-18:00"), want: true},
+		{name: "Outside weekend", schedule: aws.String("Sat,Sun 08:00-18:00"), want: false},
+		{name: "Outside hours in time zone", schedule: aws.String("* 08:00-18:00 America/Los_Angeles"), want: false},
+		{name: "Within hours in time zone", schedule: aws.String("Wed 13:30-14:30 Europe/Paris"), want: true},
+		{name: "Spanning midnight before", schedule: aws.String("Tue 22:00-13:00"), want: true},
+		{name: "Spanning midnight wrong day", schedule: aws.String("Wed 22:00-13:00"), want: false},
+		{name: "Wrapping day range", schedule: aws.String("Sat-Wed 11:00-12:30"), want: true},
+		{name: "Invalid schedule", schedule: aws.String("weekdays"), want: false},
+		{name: "Active but expired", expiresAt: aws.String("2021-08-31T00:00:00Z"), schedule: aws.String("* 00:00-23:59"), want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, isActive(test.expiresAt, test.schedule, now))
+		})
+	}
+}
+
+func TestInitToBeSecurityGroupsRemovesInactiveSources(t *testing.T) {
+	configuration := &Configuration{
+		SecurityGroups: []SecurityGroup{
+			{
+				GroupId:   aws.String("sg-1"),
+				GroupName: aws.String("contractors"),
+				IpPermissions: []IpPermission{
+					{
+						FromPort:   aws.Int32(22),
+						IpProtocol: aws.String("tcp"),
+						IpRanges: []IpRange{
+							{CidrIp: aws.String("10.0.0.1/32"), Description: aws.String("Permanent")},
+							{CidrIp: aws.String("10.0.0.2/32"), Description: aws.String("Contractor"), ExpiresAt: aws.String("2021-09-01T18:00:00Z")},
+						},
+						ToPort: aws.Int32(22),
+					},
+					{
+						ActiveWindow: aws.String("Mon-Fri 08:00-18:00"),
+						FromPort:     aws.Int32(3389),
+						IpProtocol:   aws.String("tcp"),
+						IpRanges: []IpRange{
+							{CidrIp: aws.String("10.0.0.3/32")},
+						},
+						ToPort: aws.Int32(3389),
+					},
+				},
+				VpcId: aws.String("vpc-1"),
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want []types.IpPermission
+	}{
+		{
+			name: "Before expiry within window",
+			now:  time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC),
+			want: []types.IpPermission{
+				{
+					FromPort:   aws.Int32(22),
+					IpProtocol: aws.String("tcp"),
+					IpRanges: []types.IpRange{
+						{CidrIp: aws.String("10.0.0.1/32"), Description: aws.String("Permanent")},
+						{CidrIp: aws.String("10.0.0.2/32"), Description: aws.String("Contractor")},
+					},
+					ToPort: aws.Int32(22),
+				},
+				{
+					FromPort:   aws.Int32(3389),
+					IpProtocol: aws.String("tcp"),
+					IpRanges: []types.IpRange{
+						{CidrIp: aws.String("10.0.0.3/32")},
+					},
+					ToPort: aws.Int32(3389),
+				},
+			},
+		},
+		{
+			name: "After expiry outside window",
+			now:  time.Date(2021, 9, 1, 19, 0, 0, 0, time.UTC),
+			want: []types.IpPermission{
+				{
+					FromPort:   aws.Int32(22),
+					IpProtocol: aws.String("tcp"),
+					IpRanges: []types.IpRange{
+						{CidrIp: aws.String("10.0.0.1/32"), Description: aws.String("Permanent")},
+					},
+					ToPort: aws.Int32(22),
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := NewController(nil)
+			controller.Now = func() time.Time {
+				return test.now
+			}
+
+			controller.InitToBeSecurityGroups(configuration)
+
+			assert.Len(t, controller.ToBeSecurityGroups, 1)
+			assert.Equal(t, test.want, controller.ToBeSecurityGroups[0].IpPermissions)
+		})
+	}
+}
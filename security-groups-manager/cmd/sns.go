@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// SNS rejects subjects longer than this
+const maximumSNSSubjectLength = 100
+
+// snsClient is the subset of the SNS API used by SNSNotifier
+type snsClient interface {
+	Publish(topicArn string, subject string, message string) error
+}
+
+// SNSNotifier publishes notifications to an SNS topic, from where they can reach email, SMS or chat subscribers
+type SNSNotifier struct {
+	Client   snsClient
+	TopicArn string
+}
+
+func NewSNSNotifier(client snsClient, topicArn string) *SNSNotifier {
+	snsNotifier := new(SNSNotifier)
+
+	snsNotifier.Client = client
+	snsNotifier.TopicArn = topicArn
+
+	return snsNotifier
+}
+
+func (s *SNSNotifier) Name() string {
+	return "sns"
+}
+
+func (s *SNSNotifier) Notify(subject string, message string, notification *Notification) error {
+	if len(subject) > maximumSNSSubjectLength {
+		subject = subject[:maximumSNSSubjectLength]
+	}
+
+	return s.Client.Publish(s.TopicArn, subject, subject+"\n\n"+message)
+}
+
+// sdkSNSClient adapts the SNS client of the AWS SDK to snsClient
+type sdkSNSClient struct {
+	Client *sns.Client
+}
+
+func newSdkSNSClient(client *sns.Client) *sdkSNSClient {
+	sdkSNSClient := new(sdkSNSClient)
+
+	sdkSNSClient.Client = client
+
+	return sdkSNSClient
+}
+
+func (s *sdkSNSClient) Publish(topicArn string, subject string, message string) error {
+	ctx, cancel := context.WithTimeout(context.TODO(), notificationRequestTimeout)
+	defer cancel()
+
+	_, err := s.Client.Publish(ctx, &sns.PublishInput{
+		Message:  aws.String(message),
+		Subject:  aws.String(subject),
+		TopicArn: aws.String(topicArn),
+	})
+
+	return err
+}
+
+// snsTopicRegion returns the region of the topic, which may differ from the configured region
+func snsTopicRegion(topicArn string, defaultRegion string) string {
+	if arnParts := strings.Split(topicArn, ":"); len(arnParts) == 6 && arnParts[3] != "" {
+		return arnParts[3]
+	}
+
+	return defaultRegion
+}
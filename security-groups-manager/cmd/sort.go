@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func compareInt32Pointers(this *int32, other *int32) int {
+	thisValue := int32(-1)
+	otherValue := int32(-1)
+
+	if this != nil {
+		thisValue = *this
+	}
+	if other != nil {
+		otherValue = *other
+	}
+
+	switch {
+	case thisValue < otherValue:
+		return -1
+	case thisValue > otherValue:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareStringPointers(this *string, other *string) int {
+	thisValue := ""
+	otherValue := ""
+
+	if this != nil {
+		thisValue = *this
+	}
+	if other != nil {
+		otherValue = *other
+	}
+
+	switch {
+	case thisValue < otherValue:
+		return -1
+	case thisValue > otherValue:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// sortSecurityGroupDeltas orders the deltas by region, VPC and ID of their to-be security group. A security group that
+// wasn't found has no region, so it is ordered after the others by its VPC and ID
+func sortSecurityGroupDeltas(securityGroupDeltas []SecurityGroupDelta) {
+	sort.SliceStable(securityGroupDeltas, func(i int, j int) bool {
+		if (securityGroupDeltas[i].RegionName == "") != (securityGroupDeltas[j].RegionName == "") {
+			return securityGroupDeltas[j].RegionName == ""
+		}
+
+		if securityGroupDeltas[i].RegionName != securityGroupDeltas[j].RegionName {
+			return securityGroupDeltas[i].RegionName < securityGroupDeltas[j].RegionName
+		}
+
+		if c := compareStringPointers(securityGroupDeltas[i].ToBeSecurityGroup.VpcId, securityGroupDeltas[j].ToBeSecurityGroup.VpcId); c != 0 {
+			return c < 0
+		}
+
+		return compareStringPointers(securityGroupDeltas[i].ToBeSecurityGroup.GroupId, securityGroupDeltas[j].ToBeSecurityGroup.GroupId) < 0
+	})
+}
+
+func sortSecurityGroups(securityGroups []types.SecurityGroup) {
+	sort.SliceStable(securityGroups, func(i int, j int) bool {
+		if c := compareStringPointers(securityGroups[i].VpcId, securityGroups[j].VpcId); c != 0 {
+			return c < 0
+		}
+
+		return compareStringPointers(securityGroups[i].GroupId, securityGroups[j].GroupId) < 0
+	})
+}
+
+func sortTags(tags []types.Tag) []types.Tag {
+	sortedTags := make([]types.Tag, len(tags))
+	copy(sortedTags, tags)
+
+	sort.SliceStable(sortedTags, func(i int, j int) bool {
+		if c := compareStringPointers(sortedTags[i].Key, sortedTags[j].Key); c != 0 {
+			return c < 0
+		}
+
+		return compareStringPointers(sortedTags[i].Value, sortedTags[j].Value) < 0
+	})
+
+	return sortedTags
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortSecurityGroupDeltas(t *testing.T) {
+	newSecurityGroupDelta := func(regionName string, vpcId string, groupId string) SecurityGroupDelta {
+		securityGroupDelta := NewSecurityGroupDelta(&types.SecurityGroup{
+			GroupId: aws.String(groupId),
+			VpcId:   aws.String(vpcId),
+		})
+		securityGroupDelta.RegionName = regionName
+
+		return *securityGroupDelta
+	}
+
+	securityGroupDeltas := []SecurityGroupDelta{
+		newSecurityGroupDelta("us-east-1", "vpc-b", "sg-2"),
+		newSecurityGroupDelta("", "vpc-b", "sg-5"),
+		newSecurityGroupDelta("ca-central-1", "vpc-a", "sg-9"),
+		newSecurityGroupDelta("", "vpc-a", "sg-8"),
+		newSecurityGroupDelta("us-east-1", "vpc-a", "sg-3"),
+		newSecurityGroupDelta("us-east-1", "vpc-b", "sg-1"),
+	}
+
+	sortSecurityGroupDeltas(securityGroupDeltas)
+
+	got := make([]string, 0, len(securityGroupDeltas))
+	for _, securityGroupDelta := range securityGroupDeltas {
+		got = append(got, securityGroupDelta.RegionName+"/"+*securityGroupDelta.ToBeSecurityGroup.VpcId+"/"+*securityGroupDelta.ToBeSecurityGroup.GroupId)
+	}
+
+	assert.Equal(t, []string{
+		"ca-central-1/vpc-a/sg-9",
+		"us-east-1/vpc-a/sg-3",
+		"us-east-1/vpc-b/sg-1",
+		"us-east-1/vpc-b/sg-2",
+		"/vpc-a/sg-8",
+		"/vpc-b/sg-5",
+	}, got, "Security groups that weren't found have no region and are ordered last")
+}
+
+func TestTabulateIpPermissionsIsOrderIndependent(t *testing.T) {
+	securityGroup := types.SecurityGroup{
+		OwnerId: aws.String("123456789012"),
+	}
+
+	ssh := types.IpPermission{
+		FromPort:   aws.Int32(22),
+		IpProtocol: aws.String("tcp"),
+		IpRanges: []types.IpRange{
+			{CidrIp: aws.String("10.0.0.2/32")},
+			{CidrIp: aws.String("10.0.0.1/32")},
+		},
+		ToPort: aws.Int32(22),
+	}
+	http := types.IpPermission{
+		FromPort:   aws.Int32(80),
+		IpProtocol: aws.String("tcp"),
+		Ipv6Ranges: []types.Ipv6Range{
+			{CidrIpv6: aws.String("::/0")},
+		},
+		ToPort: aws.Int32(80),
+	}
+	all := types.IpPermission{
+		IpProtocol: aws.String("-1"),
+		UserIdGroupPairs: []types.UserIdGroupPair{
+			{GroupId: aws.String("sg-1"), UserId: aws.String("123456789012")},
+		},
+	}
+
+	want := tabulateTable(newIpPermissionsTable([]types.IpPermission{ssh, http, all}, securityGroup, "Inbound rules"))
+
+	sshReversed := ssh
+	sshReversed.IpRanges = []types.IpRange{ssh.IpRanges[1], ssh.IpRanges[0]}
+
+	assert.Equal(t, want, tabulateTable(newIpPermissionsTable([]types.IpPermission{all, http, sshReversed}, securityGroup, "Inbound rules")))
+	assert.Equal(t, tabulateTable(newTagsTable([]types.Tag{
+		{Key: aws.String("a"), Value: aws.String("1")},
+		{Key: aws.String("b"), Value: aws.String("2")},
+	}, "Tags")), tabulateTable(newTagsTable([]types.Tag{
+		{Key: aws.String("b"), Value: aws.String("2")},
+		{Key: aws.String("a"), Value: aws.String("1")},
+	}, "Tags")))
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	stateStorePathEnvironmentVariableName      = "STATE_STORE_PATH"
+	stateStoreTableNameEnvironmentVariableName = "STATE_STORE_TABLE_NAME"
+)
+
+const (
+	maximumHostResolutions = 50
+	maximumRunOutcomes     = 100
+)
+
+// A host whose addresses changed more than hostChurnThreshold times within hostChurnWindow is reported as churning
+const (
+	hostChurnThreshold = 3
+	hostChurnWindow    = time.Hour
+)
+
+// AppliedState is the to be state of a security group as of its last successful apply
+type AppliedState struct {
+	AppliedAt         time.Time
+	GroupId           string
+	RegionName        string
+	ToBeSecurityGroup *types.SecurityGroup
+}
+
+// HostResolution records the addresses a host resolved to whenever they change
+type HostResolution struct {
+	Addresses  []string
+	Host       string
+	ResolvedAt time.Time
+}
+
+// NotificationRecord records when a security group was last notified about through a notifier, identified by the
+// fingerprint of what it was notified about
+type NotificationRecord struct {
+	Fingerprint string
+	GroupId     string
+	NotifiedAt  time.Time
+	Notifier    string
+}
+
+// RunOutcome records the outcome of a run. OmittedSecurityGroups counts the security groups whose outcome was dropped
+// to fit a state store's item size limit
+type RunOutcome struct {
+	Command               string
+	FinishedAt            time.Time
+	OmittedSecurityGroups int
+	RunId                 string
+	SecurityGroups        []SecurityGroupOutcome
+	StartedAt             time.Time
+}
+
+type SecurityGroupOutcome struct {
+	Failed    bool
+	GroupId   string
+	GroupName string
+	Results   []string
+	Status    string
+}
+
+func NewRunOutcome(command string, runId string, startedAt time.Time, finishedAt time.Time, securityGroupDeltas []SecurityGroupDelta) *RunOutcome {
+	runOutcome := new(RunOutcome)
+
+	runOutcome.Command = command
+	runOutcome.FinishedAt = finishedAt
+	runOutcome.RunId = runId
+	runOutcome.SecurityGroups = make([]SecurityGroupOutcome, 0, len(securityGroupDeltas))
+	runOutcome.StartedAt = startedAt
+
+	for i := range securityGroupDeltas {
+		securityGroupDelta := &securityGroupDeltas[i]
+
+		runOutcome.SecurityGroups = append(runOutcome.SecurityGroups, SecurityGroupOutcome{
+			Failed:    securityGroupDelta.failed(),
+			GroupId:   aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupId),
+			GroupName: aws.ToString(securityGroupDelta.ToBeSecurityGroup.GroupName),
+			Results:   securityGroupDelta.results(),
+			Status:    securityGroupDelta.status(),
+		})
+	}
+
+	return runOutcome
+}
+
+func (r RunOutcome) String() string {
+	statusCounts := make(map[string]int)
+	failed := 0
+
+	for _, securityGroupOutcome := range r.SecurityGroups {
+		statusCounts[securityGroupOutcome.Status]++
+		if securityGroupOutcome.Failed {
+			failed++
+		}
+	}
+
+	statuses := make([]string, 0, len(statusCounts))
+	for status, count := range statusCounts {
+		statuses = append(statuses, fmt.Sprintf("%d %s", count, status))
+	}
+	sort.Strings(statuses)
+
+	outcome := fmt.Sprintf("%s %s took %s: %d security groups (%s), %d failed", r.StartedAt.UTC().Format(time.RFC3339), r.Command,
+		r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond), len(r.SecurityGroups)+r.OmittedSecurityGroups, strings.Join(statuses, ", "), failed)
+
+	if r.OmittedSecurityGroups > 0 {
+		outcome += fmt.Sprintf(", %d omitted", r.OmittedSecurityGroups)
+	}
+
+	// Outcomes recorded before runs were identified have no run ID
+	if r.RunId != "" {
+		outcome += fmt.Sprintf(" [%s]", r.RunId)
+	}
+
+	return outcome
+}
+
+// StateStore persists state between invocations. Histories are returned oldest first
+type StateStore interface {
+	GetAppliedState(groupId string) (*AppliedState, error)
+	GetNotificationRecord(fingerprint string) (*NotificationRecord, error)
+	ListHostResolutions(host string) ([]HostResolution, error)
+	ListRunOutcomes() ([]RunOutcome, error)
+	PutAppliedState(appliedState AppliedState) error
+	PutHostResolution(hostResolution HostResolution) error
+	PutNotificationRecord(notificationRecord NotificationRecord) error
+	PutRunOutcome(runOutcome RunOutcome) error
+}
+
+type fileState struct {
+	AppliedStates       map[string]AppliedState
+	HostResolutions     map[string][]HostResolution
+	NotificationRecords map[string]NotificationRecord
+	RunOutcomes         []RunOutcome
+}
+
+// FileStateStore stores the state as a JSON document in a local file, keeping the most recent maximumHostResolutions
+// resolutions per host and maximumRunOutcomes run outcomes. Notification records are pruned after
+// notificationRecordRetention
+type FileStateStore struct {
+	Mutex sync.Mutex
+	Path  string
+}
+
+func NewFileStateStore(path string) *FileStateStore {
+	fileStateStore := new(FileStateStore)
+
+	fileStateStore.Path = path
+
+	return fileStateStore
+}
+
+func (f *FileStateStore) GetAppliedState(groupId string) (*AppliedState, error) {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+
+	state, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	appliedState, ok := state.AppliedStates[groupId]
+	if !ok {
+		return nil, nil
+	}
+
+	return &appliedState, nil
+}
+
+func (f *FileStateStore) GetNotificationRecord(fingerprint string) (*NotificationRecord, error) {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+
+	state, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	notificationRecord, ok := state.NotificationRecords[fingerprint]
+	if !ok {
+		return nil, nil
+	}
+
+	return &notificationRecord, nil
+}
+
+func (f *FileStateStore) ListHostResolutions(host string) ([]HostResolution, error) {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+
+	state, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return state.HostResolutions[host], nil
+}
+
+func (f *FileStateStore) ListRunOutcomes() ([]RunOutcome, error) {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+
+	state, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return state.RunOutcomes, nil
+}
+
+func (f *FileStateStore) PutAppliedState(appliedState AppliedState) error {
+	return f.update(func(state *fileState) {
+		state.AppliedStates[appliedState.GroupId] = appliedState
+	})
+}
+
+func (f *FileStateStore) PutHostResolution(hostResolution HostResolution) error {
+	return f.update(func(state *fileState) {
+		hostResolutions := append(state.HostResolutions[hostResolution.Host], hostResolution)
+		if len(hostResolutions) > maximumHostResolutions {
+			hostResolutions = hostResolutions[len(hostResolutions)-maximumHostResolutions:]
+		}
+
+		state.HostResolutions[hostResolution.Host] = hostResolutions
+	})
+}
+
+func (f *FileStateStore) PutNotificationRecord(notificationRecord NotificationRecord) error {
+	return f.update(func(state *fileState) {
+		for fingerprint, existingNotificationRecord := range state.NotificationRecords {
+			if notificationRecord.NotifiedAt.Sub(existingNotificationRecord.NotifiedAt) > notificationRecordRetention {
+				delete(state.NotificationRecords, fingerprint)
+			}
+		}
+
+		state.NotificationRecords[notificationRecord.Fingerprint] = notificationRecord
+	})
+}
+
+func (f *FileStateStore) PutRunOutcome(runOutcome RunOutcome) error {
+	return f.update(func(state *fileState) {
+		state.RunOutcomes = append(state.RunOutcomes, runOutcome)
+		if len(state.RunOutcomes) > maximumRunOutcomes {
+			state.RunOutcomes = state.RunOutcomes[len(state.RunOutcomes)-maximumRunOutcomes:]
+		}
+	})
+}
+
+func (f *FileStateStore) read() (*fileState, error) {
+	state := &fileState{
+		AppliedStates:       make(map[string]AppliedState),
+		HostResolutions:     make(map[string][]HostResolution),
+		NotificationRecords: make(map[string]NotificationRecord),
+		RunOutcomes:         make([]RunOutcome, 0),
+	}
+
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (f *FileStateStore) update(modify func(state *fileState)) error {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+
+	state, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	modify(state)
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(f.Path, b)
+}
+
+// writeFileAtomically writes to a temporary file next to path and renames it, so readers never see a partial write
+func writeFileAtomically(path string, b []byte) error {
+	temporaryPath := path + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryPath, path)
+}
+
+// HostResolver looks up hosts, recording their addresses in the state store whenever they change. When a lookup fails
+// the last known good addresses are used instead. The sorted addresses each host resolved to are kept in Addresses
+type HostResolver struct {
+	Addresses      map[string][]string
+	LookupFailures int
+	Mutex          sync.Mutex
+	Now            func() time.Time
+	StateStore     StateStore
+}
+
+func NewHostResolver(stateStore StateStore, now func() time.Time) *HostResolver {
+	hostResolver := new(HostResolver)
+
+	hostResolver.Addresses = make(map[string][]string)
+	hostResolver.Now = now
+	hostResolver.StateStore = stateStore
+
+	return hostResolver
+}
+
+func (h *HostResolver) resolve(host Host) ([]string, error) {
+	addresses, err := h.resolveWithFallback(host)
+
+	sortedAddresses := append([]string{}, addresses...)
+	sort.Strings(sortedAddresses)
+
+	h.Mutex.Lock()
+	h.Addresses[host.name()] = sortedAddresses
+	h.Mutex.Unlock()
+
+	return addresses, err
+}
+
+func (h *HostResolver) resolveWithFallback(host Host) ([]string, error) {
+	startedAt := time.Now()
+
+	addresses, err := host.lookup()
+	if err != nil {
+		h.Mutex.Lock()
+		h.LookupFailures++
+		h.Mutex.Unlock()
+	}
+
+	if host.FQDN != nil {
+		prometheusRegistry.observe(dnsLookupDurationMetricName, time.Since(startedAt).Seconds())
+	}
+	prometheusRegistry.set(hostResolvedAddressesMetricName, float64(len(addresses)), "host", host.name())
+
+	if h.StateStore == nil {
+		return addresses, err
+	}
+
+	hostResolutions, listErr := h.StateStore.ListHostResolutions(host.name())
+	if listErr != nil {
+		logger.Errorf("Unable to list resolutions of host %s: %v", host.name(), listErr)
+
+		return addresses, err
+	}
+
+	if err != nil || len(addresses) == 0 {
+		if len(hostResolutions) > 0 {
+			lastHostResolution := hostResolutions[len(hostResolutions)-1]
+
+			logger.Warnf("Falling back to the addresses host %s resolved to at %s", host.name(), lastHostResolution.ResolvedAt.UTC().Format(time.RFC3339))
+
+			return lastHostResolution.Addresses, err
+		}
+
+		return addresses, err
+	}
+
+	sortedAddresses := append([]string{}, addresses...)
+	sort.Strings(sortedAddresses)
+
+	if len(hostResolutions) > 0 && strings.Join(hostResolutions[len(hostResolutions)-1].Addresses, ",") == strings.Join(sortedAddresses, ",") {
+		return addresses, nil
+	}
+
+	now := h.Now()
+	hostResolution := HostResolution{
+		Addresses:  sortedAddresses,
+		Host:       host.name(),
+		ResolvedAt: now,
+	}
+
+	if err := h.StateStore.PutHostResolution(hostResolution); err != nil {
+		logger.Errorf("Unable to record resolution of host %s: %v", host.name(), err)
+	}
+
+	// Every resolution but the first of a host is a change
+	changes := 0
+	for i, recordedHostResolution := range append(hostResolutions, hostResolution) {
+		if i > 0 && now.Sub(recordedHostResolution.ResolvedAt) < hostChurnWindow {
+			changes++
+		}
+	}
+
+	if changes > hostChurnThreshold {
+		logger.Warnf("Host %s changed address %d times in the last %s", host.name(), changes, hostChurnWindow)
+	}
+
+	return addresses, nil
+}
+
+func initStateStore(awsConfiguration aws.Config) StateStore {
+	if path := lookupOptionalEnvironmentVariable(stateStorePathEnvironmentVariableName, ""); path != "" {
+		return NewFileStateStore(path)
+	}
+
+	if tableName := lookupOptionalEnvironmentVariable(stateStoreTableNameEnvironmentVariableName, ""); tableName != "" {
+		return NewDynamoDBStateStore(newSdkDynamoDBClient(dynamodb.NewFromConfig(awsConfiguration)), tableName)
+	}
+
+	return nil
+}
+
+// printHistory outputs the run outcomes, the last applied state of subject when it's a security group ID, or else the
+// resolutions of subject when it isn't empty
+func printHistory(stateStore StateStore, subject string) error {
+	if stateStore == nil {
+		logger.Infof("The history requires either the %s or %s environment variable", stateStorePathEnvironmentVariableName, stateStoreTableNameEnvironmentVariableName)
+
+		return fmt.Errorf("no state store")
+	}
+
+	var history interface{}
+	var lines []string
+
+	if strings.HasPrefix(subject, "sg-") {
+		appliedState, err := stateStore.GetAppliedState(subject)
+		if err != nil {
+			logger.Errorf("Unable to get applied state of security group %s: %v", subject, err)
+
+			return err
+		}
+
+		if appliedState == nil {
+			logger.Infof("Security group %s has not been applied", subject)
+
+			return fmt.Errorf("no applied state")
+		}
+
+		history = appliedState
+		lines = append(lines, fmt.Sprintf("%s applied %s in %s with %d ingress and %d egress permissions", appliedState.AppliedAt.UTC().Format(time.RFC3339),
+			appliedState.GroupId, appliedState.RegionName, len(appliedState.ToBeSecurityGroup.IpPermissions), len(appliedState.ToBeSecurityGroup.IpPermissionsEgress)))
+	} else if subject != "" {
+		hostResolutions, err := stateStore.ListHostResolutions(subject)
+		if err != nil {
+			logger.Errorf("Unable to list resolutions of host %s: %v", subject, err)
+
+			return err
+		}
+
+		history = hostResolutions
+		for _, hostResolution := range hostResolutions {
+			lines = append(lines, fmt.Sprintf("%s %s", hostResolution.ResolvedAt.UTC().Format(time.RFC3339), strings.Join(hostResolution.Addresses, ", ")))
+		}
+	} else {
+		runOutcomes, err := stateStore.ListRunOutcomes()
+		if err != nil {
+			logger.Errorf("Unable to list run outcomes: %v", err)
+
+			return err
+		}
+
+		history = runOutcomes
+		for _, runOutcome := range runOutcomes {
+			lines = append(lines, runOutcome.String())
+		}
+	}
+
+	if executionEnvironment.OutputFormat == jsonOutputFormat {
+		b, err := json.MarshalIndent(history, "", "  ")
+		if err != nil {
+			logger.Errorf("Unable to marshal history: %v", err)
+
+			return err
+		}
+
+		fmt.Println(string(b))
+
+		return nil
+	}
+
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFileStateStore(t *testing.T) (*FileStateStore, func()) {
+	directory, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+
+	return NewFileStateStore(filepath.Join(directory, "state.json")), func() { os.RemoveAll(directory) }
+}
+
+func TestFileStateStore(t *testing.T) {
+	fileStateStore, cleanup := newTestFileStateStore(t)
+	defer cleanup()
+
+	testStateStore(t, fileStateStore)
+}
+
+func testStateStore(t *testing.T, stateStore StateStore) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	appliedState, err := stateStore.GetAppliedState("sg-1")
+	assert.NoError(t, err)
+	assert.Nil(t, appliedState)
+
+	assert.NoError(t, stateStore.PutAppliedState(AppliedState{AppliedAt: now, GroupId: "sg-1", RegionName: "eu-west-1", ToBeSecurityGroup: &types.SecurityGroup{GroupId: aws.String("sg-1")}}))
+
+	appliedState, err = stateStore.GetAppliedState("sg-1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, appliedState) {
+		assert.Equal(t, "eu-west-1", appliedState.RegionName)
+		assert.Equal(t, "sg-1", aws.ToString(appliedState.ToBeSecurityGroup.GroupId))
+	}
+
+	for i := 0; i < maximumHostResolutions+1; i++ {
+		assert.NoError(t, stateStore.PutHostResolution(HostResolution{Addresses: []string{"192.0.2.1"}, Host: "example.com", ResolvedAt: now.Add(time.Duration(i) * time.Minute)}))
+	}
+
+	hostResolutions, err := stateStore.ListHostResolutions("example.com")
+	assert.NoError(t, err)
+	if assert.Len(t, hostResolutions, maximumHostResolutions) {
+		assert.True(t, hostResolutions[0].ResolvedAt.Equal(now.Add(time.Minute)))
+		assert.True(t, hostResolutions[maximumHostResolutions-1].ResolvedAt.Equal(now.Add(maximumHostResolutions*time.Minute)))
+	}
+
+	hostResolutions, err = stateStore.ListHostResolutions("example.org")
+	assert.NoError(t, err)
+	assert.Empty(t, hostResolutions)
+
+	notificationRecord, err := stateStore.GetNotificationRecord("fingerprint")
+	assert.NoError(t, err)
+	assert.Nil(t, notificationRecord)
+
+	assert.NoError(t, stateStore.PutNotificationRecord(NotificationRecord{Fingerprint: "fingerprint", GroupId: "sg-1", NotifiedAt: now}))
+
+	notificationRecord, err = stateStore.GetNotificationRecord("fingerprint")
+	assert.NoError(t, err)
+	if assert.NotNil(t, notificationRecord) {
+		assert.Equal(t, "sg-1", notificationRecord.GroupId)
+		assert.True(t, notificationRecord.NotifiedAt.Equal(now))
+	}
+
+	assert.NoError(t, stateStore.PutRunOutcome(RunOutcome{Command: planCommand, FinishedAt: now.Add(time.Second), StartedAt: now}))
+	assert.NoError(t, stateStore.PutRunOutcome(RunOutcome{Command: applyCommand, FinishedAt: now.Add(time.Minute + time.Second), StartedAt: now.Add(time.Minute)}))
+
+	runOutcomes, err := stateStore.ListRunOutcomes()
+	assert.NoError(t, err)
+	if assert.Len(t, runOutcomes, 2) {
+		assert.Equal(t, planCommand, runOutcomes[0].Command)
+		assert.Equal(t, applyCommand, runOutcomes[1].Command)
+	}
+}
+
+func TestHostResolver(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	address := "192.0.2.1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if address == "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.Write([]byte(address))
+	}))
+	defer server.Close()
+
+	fileStateStore, cleanup := newTestFileStateStore(t)
+	defer cleanup()
+
+	hostResolver := NewHostResolver(fileStateStore, func() time.Time { return now })
+	host := Host{URL: aws.String(server.URL)}
+
+	addresses, err := hostResolver.resolve(host)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.0.2.1"}, addresses)
+
+	// Unchanged addresses aren't recorded again
+	now = now.Add(time.Minute)
+	_, err = hostResolver.resolve(host)
+	assert.NoError(t, err)
+
+	address = "192.0.2.2"
+	now = now.Add(time.Minute)
+	addresses, err = hostResolver.resolve(host)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.0.2.2"}, addresses)
+
+	hostResolutions, err := fileStateStore.ListHostResolutions(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, []HostResolution{
+		{Addresses: []string{"192.0.2.1"}, Host: server.URL, ResolvedAt: now.Add(-2 * time.Minute)},
+		{Addresses: []string{"192.0.2.2"}, Host: server.URL, ResolvedAt: now},
+	}, hostResolutions)
+
+	// The last known good addresses are used when the lookup fails
+	address = ""
+	addresses, err = hostResolver.resolve(host)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"192.0.2.2"}, addresses)
+	assert.Equal(t, map[string][]string{server.URL: {"192.0.2.2"}}, hostResolver.Addresses, "The addresses used are kept for the host watcher")
+}
+
+func TestRunOutcomeString(t *testing.T) {
+	startedAt := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	runOutcome := RunOutcome{
+		Command:    applyCommand,
+		FinishedAt: startedAt.Add(1500 * time.Millisecond),
+		SecurityGroups: []SecurityGroupOutcome{
+			{GroupId: "sg-1", Status: inSyncStatus},
+			{GroupId: "sg-2", Status: inSyncStatus},
+			{Failed: true, GroupId: "sg-3", Status: changedStatus},
+		},
+		StartedAt: startedAt,
+	}
+
+	assert.Equal(t, "2021-09-01T12:00:00Z apply took 1.5s: 3 security groups (1 changed, 2 in-sync), 1 failed", runOutcome.String())
+
+	runOutcome.RunId = "01ARYZ6S41ZZZZZZZZZZZZZZZZ"
+	assert.Equal(t, "2021-09-01T12:00:00Z apply took 1.5s: 3 security groups (1 changed, 2 in-sync), 1 failed [01ARYZ6S41ZZZZZZZZZZZZZZZZ]", runOutcome.String())
+
+	runOutcome.OmittedSecurityGroups = 2
+	assert.Equal(t, "2021-09-01T12:00:00Z apply took 1.5s: 5 security groups (1 changed, 2 in-sync), 1 failed, 2 omitted [01ARYZ6S41ZZZZZZZZZZZZZZZZ]", runOutcome.String())
+}
+
+func TestShowHistoryWithoutConfiguration(t *testing.T) {
+	originalArgs, originalLogger := os.Args, logger
+	defer func() {
+		os.Args, logger = originalArgs, originalLogger
+	}()
+
+	configuration, hasConfiguration := os.LookupEnv(configurationEnvironmentVariableName)
+	os.Unsetenv(configurationEnvironmentVariableName)
+	os.Setenv(stateStorePathEnvironmentVariableName, filepath.Join(t.TempDir(), "state.json"))
+	defer func() {
+		os.Unsetenv(stateStorePathEnvironmentVariableName)
+		if hasConfiguration {
+			os.Setenv(configurationEnvironmentVariableName, configuration)
+		}
+	}()
+
+	os.Args = []string{"security-groups-manager", historyCommand}
+	assert.NoError(t, showHistory())
+}
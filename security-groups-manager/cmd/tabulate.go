@@ -2,7 +2,9 @@ package main
 
 import (
 	"strconv"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
@@ -227,189 +229,194 @@ func determineProtocol(ipPermission types.IpPermission) string {
 	return protocol
 }
 
-func tabulateIpPermissions(ipPermissions []types.IpPermission, securityGroup types.SecurityGroup, header string) string {
-	ipPermissionsTable := table.NewWriter()
-
-	ipPermissionsTable.SetTitle(header)
-	ipPermissionsTable.AppendHeader(table.Row{"Protocol", "Port Range", "Source", "Description"})
-	ipPermissionsTable.SetColumnConfigs([]table.ColumnConfig{
-		{
-			Number:      1,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignDefault,
-		},
-		{
-			Number:      2,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignRight,
-			AutoMerge:   true,
-		},
-		{
-			Number:      3,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignDefault,
-		},
-		{
-			Number:      4,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignDefault,
-		},
-	})
-	ipPermissionsTable.Style().Box = table.StyleBoxRounded
-	ipPermissionsTable.Style().Format = table.FormatOptions{
-		Header: text.FormatDefault,
+func determineSource(rule Rule, securityGroup types.SecurityGroup) string {
+	if rule.SourceKind == securityGroupSourceKind {
+		return strings.TrimPrefix(rule.Source, aws.ToString(securityGroup.OwnerId)+"/")
 	}
-	ipPermissionsTable.Style().Title.Align = text.AlignCenter
 
-	for _, ipPermission := range ipPermissions {
-		protocol := determineProtocol(ipPermission)
-		portRange := determinePortRange(ipPermission)
+	return rule.Source
+}
 
-		for _, ipRange := range ipPermission.IpRanges {
-			source := *ipRange.CidrIp
-			description := ""
-			if ipRange.Description != nil {
-				description = *ipRange.Description
-			}
+// TableRenderer renders box-drawing text tables, nesting the tables of every cell in the table of the delta
+type TableRenderer struct{}
 
-			ipPermissionsTable.AppendRow(table.Row{
-				protocol,
-				portRange,
-				source,
-				description,
-			})
-		}
+func (t *TableRenderer) Render(securityGroupDelta *SecurityGroupDelta) string {
+	deltaReport := securityGroupDelta.report()
 
-		for _, ipv6Range := range ipPermission.Ipv6Ranges {
-			source := *ipv6Range.CidrIpv6
-			description := ""
-			if ipv6Range.Description != nil {
-				description = *ipv6Range.Description
-			}
+	securityGroupDeltaTable := table.NewWriter()
+
+	header := make(table.Row, 0, len(deltaReport.Header))
+	columnConfigs := make([]table.ColumnConfig, 0, len(deltaReport.Header))
+	for i, column := range deltaReport.Header {
+		header = append(header, column)
 
-			ipPermissionsTable.AppendRow(table.Row{
-				protocol,
-				portRange,
-				source,
-				description,
-			})
+		columnConfig := table.ColumnConfig{
+			Number:      i + 1,
+			AlignHeader: text.AlignCenter,
+			Align:       text.AlignCenter,
+		}
+		// The cells of a missing security group stay aligned to the top
+		if securityGroupDelta.AsIsSecurityGroup != nil {
+			columnConfig.VAlign = text.VAlignMiddle
 		}
 
-		for _, prefixListId := range ipPermission.PrefixListIds {
-			source := *prefixListId.PrefixListId
-			description := ""
-			if prefixListId.Description != nil {
-				description = *prefixListId.Description
-			}
+		columnConfigs = append(columnConfigs, columnConfig)
+	}
 
-			ipPermissionsTable.AppendRow(table.Row{
-				protocol,
-				portRange,
-				source,
-				description,
-			})
-		}
+	securityGroupDeltaTable.AppendHeader(header)
+	securityGroupDeltaTable.SetColumnConfigs(columnConfigs)
+	securityGroupDeltaTable.Style().Box = table.StyleBoxRounded
+	securityGroupDeltaTable.Style().Format = table.FormatOptions{
+		Header: text.FormatDefault,
+	}
+	securityGroupDeltaTable.Style().Options.SeparateRows = true
 
-		for _, userIdGroupPair := range ipPermission.UserIdGroupPairs {
-			source := *userIdGroupPair.GroupId
-			if userIdGroupPair.UserId != nil && *securityGroup.OwnerId != *userIdGroupPair.UserId {
-				source = *userIdGroupPair.UserId + "/" + source
-			}
-			description := ""
-			if userIdGroupPair.Description != nil {
-				description = *userIdGroupPair.Description
+	for _, reportRow := range deltaReport.Rows {
+		row := make(table.Row, 0, len(reportRow.Cells))
+		for _, cell := range reportRow.Cells {
+			contents := make([]string, 0, len(cell.Tables)+len(cell.Lines))
+			for _, reportTable := range cell.Tables {
+				contents = append(contents, tabulateTable(reportTable))
 			}
+			contents = append(contents, cell.Lines...)
 
-			ipPermissionsTable.AppendRow(table.Row{
-				protocol,
-				portRange,
-				source,
-				description,
-			})
+			row = append(row, strings.Join(contents, "\n"))
 		}
+
+		securityGroupDeltaTable.AppendRow(row)
 	}
 
-	return ipPermissionsTable.Render()
+	return securityGroupDeltaTable.Render()
 }
 
-func tabulateSecurityGroup(securityGroup types.SecurityGroup) string {
-	securityGroupTable := table.NewWriter()
+func tabulateTable(reportTable ReportTable) string {
+	reportTableWriter := table.NewWriter()
 
-	securityGroupTable.SetTitle(*securityGroup.GroupName)
-	securityGroupTable.AppendHeader(table.Row{"VPC ID", "Group ID", "Group Name", "Description", "Owner"})
-	securityGroupTable.SetColumnConfigs([]table.ColumnConfig{
-		{
-			Number:      1,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignCenter,
-		},
-		{
-			Number:      2,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignCenter,
-		},
-		{
-			Number:      3,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignCenter,
-		},
-		{
-			Number:      4,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignCenter,
-		},
-		{
-			Number:      5,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignCenter,
-		},
-	})
-	securityGroupTable.Style().Box = table.StyleBoxRounded
-	securityGroupTable.Style().Format = table.FormatOptions{
-		Header: text.FormatDefault,
+	header := make(table.Row, 0, len(reportTable.Header))
+	for _, column := range reportTable.Header {
+		header = append(header, column)
 	}
-	securityGroupTable.Style().Title.Align = text.AlignCenter
-
-	securityGroupTable.AppendRow(table.Row{
-		*securityGroup.VpcId,
-		*securityGroup.GroupId,
-		*securityGroup.GroupName,
-		*securityGroup.Description,
-		*securityGroup.OwnerId,
-	})
-
-	return securityGroupTable.Render()
-}
-
-func tabulateTags(tags []types.Tag, header string) string {
-	tagsTable := table.NewWriter()
 
-	tagsTable.SetTitle(header)
-	tagsTable.AppendHeader(table.Row{"Key", "Value"})
-	tagsTable.SetColumnConfigs([]table.ColumnConfig{
-		{
-			Number:      1,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignLeft,
-		},
-		{
-			Number:      2,
-			AlignHeader: text.AlignCenter,
-			Align:       text.AlignLeft,
-		},
-	})
-	tagsTable.Style().Box = table.StyleBoxRounded
-	tagsTable.Style().Format = table.FormatOptions{
+	reportTableWriter.SetTitle(reportTable.Title)
+	reportTableWriter.AppendHeader(header)
+	reportTableWriter.SetColumnConfigs(tableColumnConfigs(reportTable.Kind))
+	reportTableWriter.Style().Box = table.StyleBoxRounded
+	reportTableWriter.Style().Format = table.FormatOptions{
 		Header: text.FormatDefault,
 	}
-	tagsTable.Style().Title.Align = text.AlignCenter
+	reportTableWriter.Style().Title.Align = text.AlignCenter
+
+	for _, reportRow := range reportTable.Rows {
+		row := make(table.Row, 0, len(reportRow))
+		for _, cell := range reportRow {
+			row = append(row, cell)
+		}
 
-	for _, tag := range tags {
-		tagsTable.AppendRow(table.Row{
-			*tag.Key,
-			*tag.Value,
-		})
+		reportTableWriter.AppendRow(row)
 	}
 
-	return tagsTable.Render()
-}
\ No newline at end of file
+	return reportTableWriter.Render()
+}
+
+func tableColumnConfigs(kind string) []table.ColumnConfig {
+	switch kind {
+	case ruleModificationsTableKind:
+		return []table.ColumnConfig{
+			{
+				Number:      1,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignDefault,
+			},
+			{
+				Number:      2,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignDefault,
+			},
+			{
+				Number:      3,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignRight,
+			},
+			{
+				Number:      4,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignDefault,
+			},
+			{
+				Number:      5,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignDefault,
+			},
+		}
+	case rulesTableKind:
+		return []table.ColumnConfig{
+			{
+				Number:      1,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignDefault,
+			},
+			{
+				Number:      2,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignRight,
+				AutoMerge:   true,
+			},
+			{
+				Number:      3,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignDefault,
+			},
+			{
+				Number:      4,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignDefault,
+			},
+			{
+				Number:      5,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignDefault,
+			},
+		}
+	case securityGroupTableKind:
+		return []table.ColumnConfig{
+			{
+				Number:      1,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignCenter,
+			},
+			{
+				Number:      2,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignCenter,
+			},
+			{
+				Number:      3,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignCenter,
+			},
+			{
+				Number:      4,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignCenter,
+			},
+			{
+				Number:      5,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignCenter,
+			},
+		}
+	default:
+		return []table.ColumnConfig{
+			{
+				Number:      1,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignLeft,
+			},
+			{
+				Number:      2,
+				AlignHeader: text.AlignCenter,
+				Align:       text.AlignLeft,
+			},
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/rand"
+	"io"
+	"time"
+)
+
+const crockfordBase32Alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a Universally Unique Lexicographically Sortable Identifier, a 48 bit millisecond timestamp followed
+// by 80 bits read from entropy, encoded as 26 Crockford base32 characters. ULIDs of later runs sort after earlier ones.
+func newULID(now time.Time, entropy io.Reader) (string, error) {
+	var b [16]byte
+
+	milliseconds := uint64(now.UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		b[i] = byte(milliseconds >> (40 - 8*i))
+	}
+
+	if _, err := io.ReadFull(entropy, b[6:]); err != nil {
+		return "", err
+	}
+
+	// 128 bits are encoded 5 bits at a time, the first character holding only the 3 most significant bits
+	ulid := make([]byte, 26)
+	for i := range ulid {
+		bit := 5*i - 2
+		index := 0
+		for j := 0; j < 5; j++ {
+			if bit+j < 0 {
+				continue
+			}
+			index = index<<1 | int(b[(bit+j)/8]>>(7-uint((bit+j)%8))&1)
+		}
+		ulid[i] = crockfordBase32Alphabet[index]
+	}
+
+	return string(ulid), nil
+}
+
+// newRunId returns a ULID correlating the records of a single run
+func newRunId() string {
+	runId, err := newULID(time.Now(), rand.Reader)
+	if err != nil {
+		logger.Warnf("Unable to generate run ID: %v", err)
+	}
+
+	return runId
+}
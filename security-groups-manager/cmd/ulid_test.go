@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewULID(t *testing.T) {
+	ulid, err := newULID(time.Unix(1469918176, 385000000), bytes.NewReader(bytes.Repeat([]byte{0xff}, 10)))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "01ARYZ6S41ZZZZZZZZZZZZZZZZ", ulid)
+
+	zeroUlid, err := newULID(time.Unix(0, 0), bytes.NewReader(make([]byte, 10)))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "00000000000000000000000000", zeroUlid)
+}
+
+func TestNewULIDSortsChronologically(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	earlierUlid, _ := newULID(now, bytes.NewReader(bytes.Repeat([]byte{0xff}, 10)))
+	laterUlid, _ := newULID(now.Add(time.Millisecond), bytes.NewReader(make([]byte, 10)))
+
+	assert.Less(t, earlierUlid, laterUlid)
+}
+
+func TestNewULIDFailsWithoutEntropy(t *testing.T) {
+	_, err := newULID(time.Now(), bytes.NewReader(make([]byte, 9)))
+
+	assert.Error(t, err)
+}
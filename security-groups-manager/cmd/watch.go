@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	// Lower bound of the interval between lookups of a host, guarding against records with a TTL of 0
+	minimumWatchInterval = 5 * time.Second
+	// Upper bound of the interval between lookups of a host, so that a long TTL doesn't hide a change for too long
+	maximumWatchInterval = time.Hour
+)
+
+// HostWatcher re-resolves the FQDN Hosts of a configuration whenever their DNS TTL expires, and reconciles only the
+// security groups referencing a Host whose addresses changed
+type HostWatcher struct {
+	Addresses        map[string][]string
+	Lookup           func(fqdn string) ([]string, time.Duration, error)
+	Mutex            sync.Mutex
+	NextLookupAt     map[string]time.Time
+	Now              func() time.Time
+	Reconcile        func(groupIds []string) error
+	SecurityGroupIds map[string][]string
+}
+
+func NewHostWatcher(configuration *Configuration, lookup func(fqdn string) ([]string, time.Duration, error), reconcile func(groupIds []string) error) *HostWatcher {
+	hostWatcher := new(HostWatcher)
+
+	hostWatcher.Addresses = make(map[string][]string)
+	hostWatcher.Lookup = lookup
+	hostWatcher.NextLookupAt = make(map[string]time.Time)
+	hostWatcher.Now = time.Now
+	hostWatcher.Reconcile = reconcile
+	hostWatcher.SecurityGroupIds = watchedHosts(configuration)
+
+	return hostWatcher
+}
+
+// watchedHosts returns the IDs of the configured security groups referencing each FQDN Host. Hosts with a URL have no
+// TTL and aren't watched
+func watchedHosts(configuration *Configuration) map[string][]string {
+	securityGroupIds := make(map[string][]string)
+
+	for _, securityGroup := range configuration.SecurityGroups {
+		groupId := aws.ToString(securityGroup.GroupId)
+
+		for _, ipPermission := range append(append([]IpPermission{}, securityGroup.IpPermissions...), securityGroup.IpPermissionsEgress...) {
+			for _, host := range ipPermission.Hosts {
+				if host.FQDN == nil {
+					continue
+				}
+
+				fqdn := *host.FQDN
+				if !containsString(securityGroupIds[fqdn], groupId) {
+					securityGroupIds[fqdn] = append(securityGroupIds[fqdn], groupId)
+				}
+			}
+		}
+	}
+
+	return securityGroupIds
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seed records the addresses hosts resolved to during a reconciliation, keyed by host name, as the addresses the
+// security groups were reconciled with. Hosts that aren't watched are ignored
+func (h *HostWatcher) seed(addresses map[string][]string) {
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+
+	for fqdn := range h.SecurityGroupIds {
+		if hostAddresses, ok := addresses[fqdn]; ok {
+			h.Addresses[fqdn] = append([]string{}, hostAddresses...)
+		}
+	}
+}
+
+// check looks up the hosts whose TTL expired and returns the sorted IDs of the security groups referencing a host whose
+// addresses changed. The first lookup of a host that wasn't seeded only records its addresses
+func (h *HostWatcher) check() []string {
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+
+	now := h.Now()
+	changedGroupIds := make([]string, 0)
+
+	for fqdn, groupIds := range h.SecurityGroupIds {
+		if nextLookupAt, ok := h.NextLookupAt[fqdn]; ok && now.Before(nextLookupAt) {
+			continue
+		}
+
+		addresses, ttl, err := h.Lookup(fqdn)
+		if err != nil {
+			logger.with("host", fqdn).Warnf("Unable to lookup host: %v", err)
+
+			h.NextLookupAt[fqdn] = now.Add(minimumWatchInterval)
+
+			continue
+		}
+
+		h.NextLookupAt[fqdn] = now.Add(clampWatchInterval(ttl))
+
+		sort.Strings(addresses)
+
+		previousAddresses, ok := h.Addresses[fqdn]
+		h.Addresses[fqdn] = addresses
+
+		if !ok || equalStrings(previousAddresses, addresses) {
+			continue
+		}
+
+		logger.with("host", fqdn).Infof("Host changed address from %v to %v", previousAddresses, addresses)
+
+		for _, groupId := range groupIds {
+			if !containsString(changedGroupIds, groupId) {
+				changedGroupIds = append(changedGroupIds, groupId)
+			}
+		}
+	}
+
+	sort.Strings(changedGroupIds)
+
+	return changedGroupIds
+}
+
+// nextCheckIn returns how long until the TTL of the first host expires
+func (h *HostWatcher) nextCheckIn() time.Duration {
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+
+	now := h.Now()
+	next := maximumWatchInterval
+
+	for fqdn := range h.SecurityGroupIds {
+		nextLookupAt, ok := h.NextLookupAt[fqdn]
+		if !ok {
+			return 0
+		}
+
+		if until := nextLookupAt.Sub(now); until < next {
+			next = until
+		}
+	}
+
+	if next < 0 {
+		return 0
+	}
+
+	return next
+}
+
+// Run watches the hosts until ctx is done. A reconciliation in flight when ctx is done is completed
+func (h *HostWatcher) Run(ctx context.Context) {
+	logger.Infof("Watching %d hosts", len(h.SecurityGroupIds))
+
+	for {
+		timer := time.NewTimer(h.nextCheckIn())
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+		}
+
+		groupIds := h.check()
+		if len(groupIds) == 0 {
+			continue
+		}
+
+		logger.Infof("Reconciling %d security groups whose hosts changed address", len(groupIds))
+
+		if err := h.Reconcile(groupIds); err != nil {
+			logger.Errorf("Unable to reconcile: %v", err)
+		}
+	}
+}
+
+func clampWatchInterval(ttl time.Duration) time.Duration {
+	switch {
+	case ttl < minimumWatchInterval:
+		return minimumWatchInterval
+	case ttl > maximumWatchInterval:
+		return maximumWatchInterval
+	default:
+		return ttl
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
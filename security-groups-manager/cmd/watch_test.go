@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchedHosts(t *testing.T) {
+	configuration := &Configuration{
+		SecurityGroups: []SecurityGroup{
+			{
+				GroupId: aws.String("sg-1"),
+				IpPermissions: []IpPermission{
+					{Hosts: []Host{{FQDN: aws.String("a.example.com")}, {URL: aws.String("https://checkip.amazonaws.com")}}},
+					{Hosts: []Host{{FQDN: aws.String("a.example.com")}}},
+				},
+			},
+			{
+				GroupId:             aws.String("sg-2"),
+				IpPermissionsEgress: []IpPermission{{Hosts: []Host{{FQDN: aws.String("a.example.com")}, {FQDN: aws.String("b.example.com")}}}},
+			},
+			{
+				GroupId: aws.String("sg-3"),
+			},
+		},
+	}
+
+	assert.Equal(t, map[string][]string{
+		"a.example.com": {"sg-1", "sg-2"},
+		"b.example.com": {"sg-2"},
+	}, watchedHosts(configuration))
+
+	assert.Equal(t, []string{"sg-1", "sg-3"}, securityGroupIds(configuration.only([]string{"sg-3", "sg-1"}).SecurityGroups))
+}
+
+func TestHostWatcherCheck(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	configuration := &Configuration{
+		SecurityGroups: []SecurityGroup{
+			{GroupId: aws.String("sg-1"), IpPermissions: []IpPermission{{Hosts: []Host{{FQDN: aws.String("a.example.com")}}}}},
+			{GroupId: aws.String("sg-2"), IpPermissions: []IpPermission{{Hosts: []Host{{FQDN: aws.String("b.example.com")}}}}},
+		},
+	}
+
+	addresses := map[string][]string{
+		"a.example.com": {"192.0.2.2", "192.0.2.1"},
+		"b.example.com": {"192.0.2.3"},
+	}
+	lookups := make(map[string]int)
+
+	hostWatcher := NewHostWatcher(configuration, func(fqdn string) ([]string, time.Duration, error) {
+		lookups[fqdn]++
+
+		if fqdn == "b.example.com" && lookups[fqdn] == 2 {
+			return nil, 0, fmt.Errorf("timeout")
+		}
+
+		return append([]string{}, addresses[fqdn]...), map[string]time.Duration{"a.example.com": 0, "b.example.com": 2 * time.Minute}[fqdn], nil
+	}, nil)
+	hostWatcher.Now = func() time.Time { return now }
+
+	assert.Equal(t, time.Duration(0), hostWatcher.nextCheckIn())
+	assert.Empty(t, hostWatcher.check(), "First lookups only record the addresses")
+	assert.Equal(t, minimumWatchInterval, hostWatcher.nextCheckIn(), "A TTL of 0 is clamped")
+
+	now = now.Add(minimumWatchInterval)
+	addresses["a.example.com"] = []string{"192.0.2.1", "192.0.2.2"}
+	assert.Empty(t, hostWatcher.check(), "Same addresses in another order")
+	assert.Equal(t, map[string]int{"a.example.com": 2, "b.example.com": 1}, lookups, "b.example.com's TTL didn't expire")
+
+	now = now.Add(2 * time.Minute)
+	addresses["a.example.com"] = []string{"192.0.2.4"}
+	assert.Equal(t, []string{"sg-1"}, hostWatcher.check())
+	assert.Equal(t, []string{"192.0.2.3"}, hostWatcher.Addresses["b.example.com"], "Failed lookups keep the previous addresses")
+
+	now = now.Add(minimumWatchInterval)
+	addresses["b.example.com"] = []string{"192.0.2.5"}
+	assert.Equal(t, []string{"sg-2"}, hostWatcher.check())
+}
+
+func TestHostWatcherCheckSeeded(t *testing.T) {
+	configuration := &Configuration{
+		SecurityGroups: []SecurityGroup{
+			{GroupId: aws.String("sg-1"), IpPermissions: []IpPermission{{Hosts: []Host{{FQDN: aws.String("a.example.com")}}}}},
+			{GroupId: aws.String("sg-2"), IpPermissions: []IpPermission{{Hosts: []Host{{FQDN: aws.String("b.example.com")}}}}},
+		},
+	}
+
+	hostWatcher := NewHostWatcher(configuration, func(fqdn string) ([]string, time.Duration, error) {
+		return map[string][]string{"a.example.com": {"192.0.2.2"}, "b.example.com": {"192.0.2.3"}}[fqdn], time.Minute, nil
+	}, nil)
+
+	// a.example.com changed address after the reconciliation resolved it
+	hostWatcher.seed(map[string][]string{
+		"a.example.com":                  {"192.0.2.1"},
+		"b.example.com":                  {"192.0.2.3"},
+		"https://checkip.amazonaws.com/": {"198.51.100.1"},
+	})
+	assert.NotContains(t, hostWatcher.Addresses, "https://checkip.amazonaws.com/", "Hosts that aren't watched aren't seeded")
+
+	assert.Equal(t, []string{"sg-1"}, hostWatcher.check())
+}
+
+func TestClampWatchInterval(t *testing.T) {
+	assert.Equal(t, minimumWatchInterval, clampWatchInterval(time.Second))
+	assert.Equal(t, 5*time.Minute, clampWatchInterval(5*time.Minute))
+	assert.Equal(t, maximumWatchInterval, clampWatchInterval(24*time.Hour))
+}
+
+func securityGroupIds(securityGroups []SecurityGroup) []string {
+	groupIds := make([]string, 0, len(securityGroups))
+	for _, securityGroup := range securityGroups {
+		groupIds = append(groupIds, aws.ToString(securityGroup.GroupId))
+	}
+
+	return groupIds
+}
+
+func TestInitAsIsSecurityGroupsInSkipsMissingSecurityGroups(t *testing.T) {
+	// Like EC2, a security group ID that doesn't exist fails the whole request while a filter only matches what exists
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+
+		w.Header().Set("Content-Type", "text/xml")
+
+		switch r.Form.Get("Action") {
+		case "DescribeSecurityGroups":
+			if r.Form.Get("GroupId.1") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `<Response><Errors><Error><Code>InvalidGroup.NotFound</Code><Message>The security group 'sg-2' does not exist</Message></Error></Errors></Response>`)
+
+				return
+			}
+
+			assert.Equal(t, "group-id", r.Form.Get("Filter.1.Name"))
+			assert.Equal(t, []string{"sg-1", "sg-2"}, []string{r.Form.Get("Filter.1.Value.1"), r.Form.Get("Filter.1.Value.2")})
+
+			fmt.Fprint(w, `<DescribeSecurityGroupsResponse><securityGroupInfo><item><groupId>sg-1</groupId><groupName>bastion</groupName><vpcId>vpc-1</vpcId></item></securityGroupInfo></DescribeSecurityGroupsResponse>`)
+		case "DescribeSecurityGroupRules":
+			fmt.Fprint(w, `<DescribeSecurityGroupRulesResponse><securityGroupRuleSet/></DescribeSecurityGroupRulesResponse>`)
+		}
+	}))
+	defer server.Close()
+
+	controller := NewController(ec2.New(ec2.Options{
+		Credentials:      staticCredentialsProvider{},
+		EndpointResolver: ec2.EndpointResolverFromURL(server.URL),
+		HTTPClient:       server.Client(),
+		Region:           "eu-west-1",
+		Retryer:          aws.NopRetryer{},
+	}))
+
+	assert.NoError(t, controller.InitAsIsSecurityGroupsIn(map[string]string{"sg-1": "eu-west-1", "sg-2": "eu-west-1"}))
+	if assert.Len(t, controller.AsIsSecurityGroups, 1) {
+		assert.Equal(t, "sg-1", aws.ToString(controller.AsIsSecurityGroups[0].GroupId))
+	}
+	assert.Equal(t, map[string]string{"sg-1": "eu-west-1"}, controller.SecurityGroupIdRegionName)
+}
@@ -2,6 +2,8 @@ resource "random_id" "suffix" {
   byte_length = 4
 }
 
+data "aws_caller_identity" "current" {}
+
 resource "aws_iam_policy" "security_groups_manager_cloud_watch_logs_policy" {
   name = "SecurityGroupsManagerLambdaFunctionCloudWatchLogsPolicy-${random_id.suffix.id}"
   path = "/"
@@ -22,6 +24,28 @@ resource "aws_iam_policy" "security_groups_manager_cloud_watch_logs_policy" {
   })
 }
 
+resource "aws_iam_policy" "security_groups_manager_dynamodb_policy" {
+  count = var.state_store_table_name == "" ? 0 : 1
+
+  name = "SecurityGroupsManagerLambdaFunctionDynamoDBPolicy-${random_id.suffix.id}"
+  path = "/"
+
+  policy = jsonencode({
+    Version : "2012-10-17",
+    Statement : [
+      {
+        Action : [
+          "dynamodb:GetItem",
+          "dynamodb:PutItem",
+          "dynamodb:Query"
+        ],
+        Resource : "arn:aws:dynamodb:${var.aws_region}:${data.aws_caller_identity.current.account_id}:table/${var.state_store_table_name}",
+        Effect : "Allow"
+      }
+    ]
+  })
+}
+
 resource "aws_iam_policy" "security_groups_manager_ec2_policy" {
   name = "SecurityGroupsManagerLambdaFunctionEC2Policy-${random_id.suffix.id}"
   path = "/"
@@ -36,7 +60,9 @@ resource "aws_iam_policy" "security_groups_manager_ec2_policy" {
           "ec2:CreateTags",
           "ec2:DeleteTags",
           "ec2:DescribeRegions",
+          "ec2:DescribeSecurityGroupRules",
           "ec2:DescribeSecurityGroups",
+          "ec2:ModifySecurityGroupRules",
           "ec2:RevokeSecurityGroupEgress",
           "ec2:RevokeSecurityGroupIngress",
           "ec2:UpdateSecurityGroupRuleDescriptionsEgress",
@@ -49,8 +75,46 @@ resource "aws_iam_policy" "security_groups_manager_ec2_policy" {
   })
 }
 
+resource "aws_iam_policy" "security_groups_manager_lambda_policy" {
+  name = "SecurityGroupsManagerLambdaFunctionLambdaPolicy-${random_id.suffix.id}"
+  path = "/"
+
+  policy = jsonencode({
+    Version : "2012-10-17",
+    Statement : [
+      {
+        Action : [
+          "lambda:InvokeFunction"
+        ],
+        Resource : "arn:aws:lambda:${var.aws_region}:${data.aws_caller_identity.current.account_id}:function:SecurityGroupsManager-${random_id.suffix.id}",
+        Effect : "Allow"
+      }
+    ]
+  })
+}
+
+resource "aws_iam_policy" "security_groups_manager_sns_policy" {
+  count = var.notification_sns_topic_arn == "" ? 0 : 1
+
+  name = "SecurityGroupsManagerLambdaFunctionSNSPolicy-${random_id.suffix.id}"
+  path = "/"
+
+  policy = jsonencode({
+    Version : "2012-10-17",
+    Statement : [
+      {
+        Action : [
+          "sns:Publish"
+        ],
+        Resource : var.notification_sns_topic_arn,
+        Effect : "Allow"
+      }
+    ]
+  })
+}
+
 resource "aws_iam_role" "security_groups_manager_execution_role" {
-  managed_policy_arns = [aws_iam_policy.security_groups_manager_cloud_watch_logs_policy.arn, aws_iam_policy.security_groups_manager_ec2_policy.arn]
+  managed_policy_arns = concat([aws_iam_policy.security_groups_manager_cloud_watch_logs_policy.arn, aws_iam_policy.security_groups_manager_ec2_policy.arn, aws_iam_policy.security_groups_manager_lambda_policy.arn], aws_iam_policy.security_groups_manager_dynamodb_policy[*].arn, aws_iam_policy.security_groups_manager_sns_policy[*].arn)
   name                = "SecurityGroupsManagerLambdaFunctionRole-${random_id.suffix.id}"
   path                = "/"
 
@@ -78,8 +142,10 @@ resource "aws_lambda_function" "security_groups_manager" {
 
   environment {
     variables = {
-      CONFIGURATION = var.configuration
-      DEBUG         = var.debug
+      CONFIGURATION              = var.configuration
+      DEBUG                      = var.debug
+      NOTIFICATION_SNS_TOPIC_ARN = var.notification_sns_topic_arn
+      STATE_STORE_TABLE_NAME     = var.state_store_table_name
     }
   }
 }
@@ -1,4 +1,10 @@
 variable "aws_region" {}
 variable "configuration" {}
 variable "debug" {}
+variable "notification_sns_topic_arn" {
+  default = ""
+}
 variable "schedule_expression" {}
+variable "state_store_table_name" {
+  default = ""
+}